@@ -0,0 +1,153 @@
+package address
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+)
+
+// Type is the type of output script an address pays to.
+type Type int
+
+const (
+	P2PKH      Type = iota // Legacy pay-to-pubkey-hash.
+	P2SHP2WPKH             // Pay-to-witness-pubkey-hash nested in pay-to-script-hash, as in BIP49.
+	P2WPKH                 // Native segwit v0 pay-to-witness-pubkey-hash, as in BIP84.
+	P2TR                   // Segwit v1 key path only taproot output of BIP86.
+)
+
+var typeNames = map[Type]string{
+	P2PKH:      "p2pkh",
+	P2SHP2WPKH: "p2sh-p2wpkh",
+	P2WPKH:     "p2wpkh",
+	P2TR:       "p2tr",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseType parses the name of an address type as returned by Type.String.
+func ParseType(name string) (Type, error) {
+	for t, n := range typeNames {
+		if strings.EqualFold(n, name) {
+			return t, nil
+		}
+	}
+	return 0, errors.Errorf("unknown address type %q", name)
+}
+
+// NetParams returns the parameters of the network with the given name, which is one of mainnet, testnet and regtest.
+func NetParams(name string) (*chaincfg.Params, error) {
+	switch strings.ToLower(name) {
+	case "mainnet", "main":
+		return &chaincfg.MainNetParams, nil
+	case "testnet", "testnet3", "test":
+		return &chaincfg.TestNet3Params, nil
+	case "regtest":
+		return &chaincfg.RegressionNetParams, nil
+	}
+	return nil, errors.Errorf("unknown network %q", name)
+}
+
+// Encode returns the address of the given type paying to the public key on the given network.
+func Encode(pk node.PublicKey, t Type, params *chaincfg.Params) (string, error) {
+	if pk == nil || (*pk).IsIdentity() {
+		return "", errors.New("invalid public key")
+	}
+	pkHash := btcutil.Hash160((*pk).ToAffineCompressed())
+
+	switch t {
+	case P2PKH:
+		addr, err := btcutil.NewAddressPubKeyHash(pkHash, params)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+
+	case P2SHP2WPKH:
+		witnessAddr, err := btcutil.NewAddressWitnessPubKeyHash(pkHash, params)
+		if err != nil {
+			return "", err
+		}
+		redeemScript, err := txscript.PayToAddrScript(witnessAddr)
+		if err != nil {
+			return "", err
+		}
+		addr, err := btcutil.NewAddressScriptHash(redeemScript, params)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+
+	case P2WPKH:
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(pkHash, params)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+
+	case P2TR:
+		key, err := signing.NewTaprootKey(pk, nil)
+		if err != nil {
+			return "", err
+		}
+		return encodeSegwit(params.Bech32HRPSegwit, 1, key.XOnly())
+	}
+
+	return "", errors.Errorf("unsupported address type %d", t)
+}
+
+// PkScript decodes an address of the given network and returns the output script it pays to. Besides the legacy
+// formats, segwit addresses of any witness version are accepted.
+func PkScript(addr string, params *chaincfg.Params) ([]byte, error) {
+	if strings.HasPrefix(strings.ToLower(addr), params.Bech32HRPSegwit+"1") {
+		version, program, err := decodeSegwit(params.Bech32HRPSegwit, addr)
+		if err != nil {
+			return nil, err
+		}
+		op := byte(txscript.OP_0)
+		if version > 0 {
+			op = txscript.OP_1 + version - 1
+		}
+		return txscript.NewScriptBuilder().AddOp(op).AddData(program).Script()
+	}
+
+	decoded, err := btcutil.DecodeAddress(addr, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding address")
+	}
+	if !decoded.IsForNet(params) {
+		return nil, errors.New("address does not belong to the network")
+	}
+	return txscript.PayToAddrScript(decoded)
+}
+
+// FromDevices returns the address of the given type of the node shared among the devices. All devices must share the
+// same public key.
+func FromDevices(devices []node.Device, t Type, params *chaincfg.Params) (string, error) {
+	if len(devices) == 0 {
+		return "", errors.New("no devices given")
+	}
+	pk := devices[0].PublicKey()
+	for i := range devices {
+		if !(*devices[i].PublicKey()).Equal(*pk) {
+			return "", errors.New("devices do not share the same public key")
+		}
+	}
+	return Encode(pk, t, params)
+}
+
+// FromNode returns the address of the given type of a hardened node.
+func FromNode(n *node.Node, t Type, params *chaincfg.Params) (string, error) {
+	return Encode(n.PublicKey, t, params)
+}
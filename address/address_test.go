@@ -0,0 +1,90 @@
+package address_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func publicKey(t *testing.T, compressed string) node.PublicKey {
+	b, err := hex.DecodeString(compressed)
+	require.NoError(t, err)
+	pk, err := curves.K256().Point.FromAffineCompressed(b)
+	require.NoError(t, err)
+	return &pk
+}
+
+func TestEncode(t *testing.T) {
+	// The generator, i.e. the public key of the secret key 1.
+	g := publicKey(t, "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	// The internal key of the first receiving address of BIP86.
+	bip86 := publicKey(t, "02cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115")
+
+	tests := []struct {
+		pk       node.PublicKey
+		typ      address.Type
+		params   *chaincfg.Params
+		expected string
+	}{
+		{g, address.P2PKH, &chaincfg.MainNetParams, "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"},
+		{g, address.P2PKH, &chaincfg.TestNet3Params, "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r"},
+		{g, address.P2SHP2WPKH, &chaincfg.MainNetParams, "3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN"},
+		{g, address.P2WPKH, &chaincfg.MainNetParams, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{g, address.P2WPKH, &chaincfg.TestNet3Params, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+		{bip86, address.P2TR, &chaincfg.MainNetParams, "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"},
+	}
+	for _, test := range tests {
+		addr, err := address.Encode(test.pk, test.typ, test.params)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, addr, "%s on %s", test.typ, test.params.Name)
+	}
+
+	addr, err := address.Encode(bip86, address.P2TR, &chaincfg.RegressionNetParams)
+	require.NoError(t, err)
+	assert.Regexp(t, "^bcrt1p", addr)
+}
+
+func TestFromDevicesAndNode(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	n := node.NewNode(0, nil, nil, devices[0].PublicKey())
+
+	for _, typ := range []address.Type{address.P2PKH, address.P2SHP2WPKH, address.P2WPKH, address.P2TR} {
+		parsed, err := address.ParseType(typ.String())
+		require.NoError(t, err)
+		assert.Equal(t, typ, parsed)
+
+		fromDevices, err := address.FromDevices(devices, typ, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		fromNode, err := address.FromNode(&n, typ, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		assert.Equal(t, fromNode, fromDevices)
+	}
+}
+
+func TestPkScript(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	for addr, expected := range map[string]string{
+		"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH":                             "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac",
+		"3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN":                             "a914bcfeb728b584253d5f3f70bcb780e9ef218a68f487",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4":                     "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr": "5120a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c",
+	} {
+		script, err := address.PkScript(addr, params)
+		require.NoError(t, err, addr)
+		assert.Equal(t, expected, hex.EncodeToString(script), addr)
+	}
+
+	// Corrupted checksums must be rejected.
+	_, err := address.PkScript("bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"[:61]+"q", params)
+	assert.Error(t, err)
+	_, err = address.PkScript("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", params)
+	assert.Error(t, err, "addresses of other networks must be rejected")
+}
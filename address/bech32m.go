@@ -0,0 +1,133 @@
+package address
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/pkg/errors"
+)
+
+// The btcutil version in use only implements bech32, hence segwit v1+ addresses are encoded with the bech32m checksum
+// of BIP350 here.
+
+const (
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const    = 1
+	bech32mConst   = 0x2bc830a3
+	maxWitnessProg = 40
+)
+
+// encodeSegwit encodes a segwit address of the given witness version and program, using bech32 for version 0 and
+// bech32m for later versions.
+func encodeSegwit(hrp string, version byte, program []byte) (string, error) {
+	if version > 16 {
+		return "", errors.Errorf("invalid witness version %d", version)
+	}
+	if len(program) < 2 || len(program) > maxWitnessProg {
+		return "", errors.Errorf("invalid witness program length %d", len(program))
+	}
+
+	converted, err := bech32.ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	data := append([]byte{version}, converted...)
+
+	checksumConst := bech32mConst
+	if version == 0 {
+		checksumConst = bech32Const
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, b := range data {
+		sb.WriteByte(bech32Charset[b])
+	}
+	for _, b := range bech32Checksum(hrp, data, checksumConst) {
+		sb.WriteByte(bech32Charset[b])
+	}
+	return sb.String(), nil
+}
+
+func bech32Checksum(hrp string, data []byte, checksumConst int) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := polymod(values) ^ checksumConst
+
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte((mod >> (5 * (5 - i))) & 31)
+	}
+	return checksum
+}
+
+func polymod(values []byte) int {
+	gen := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := 1
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ int(v)
+		for i := 0; i < 5; i++ {
+			if (b>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// decodeSegwit decodes a segwit address with the given human-readable part and returns its witness version and
+// program. Version 0 addresses must use the bech32 checksum and later versions the bech32m checksum.
+func decodeSegwit(hrp, addr string) (byte, []byte, error) {
+	if len(addr) > 90 || (strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr) {
+		return 0, nil, errors.New("invalid segwit address")
+	}
+	addr = strings.ToLower(addr)
+	sep := strings.LastIndexByte(addr, '1')
+	if sep < 1 || sep+7 > len(addr) || addr[:sep] != hrp {
+		return 0, nil, errors.New("segwit address does not belong to the network")
+	}
+
+	data := make([]byte, len(addr)-sep-1)
+	for i := range data {
+		pos := strings.IndexByte(bech32Charset, addr[sep+1+i])
+		if pos < 0 {
+			return 0, nil, errors.Errorf("invalid character %q in segwit address", addr[sep+1+i])
+		}
+		data[i] = byte(pos)
+	}
+	if len(data) < 7 {
+		return 0, nil, errors.New("invalid segwit address")
+	}
+
+	version := data[0]
+	checksumConst := bech32mConst
+	if version == 0 {
+		checksumConst = bech32Const
+	}
+	if polymod(append(hrpExpand(hrp), data...)) != checksumConst {
+		return 0, nil, errors.New("invalid segwit address checksum")
+	}
+
+	program, err := bech32.ConvertBits(data[1:len(data)-6], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > 16 || len(program) < 2 || len(program) > maxWitnessProg || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, errors.New("invalid witness program")
+	}
+	return version, program, nil
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"bip32_threshold_wallet/node"
+
+	"github.com/pkg/errors"
+	"go.dedis.ch/dela/crypto"
+	"go.dedis.ch/dela/crypto/ed25519"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/dela/mino/minogrpc"
+	"go.dedis.ch/dela/mino/router/tree"
+	"go.dedis.ch/kyber/v3"
+)
+
+// CollectiveAuthority is the set of devices of a committee, given by the overlay address and the public Ed25519 key
+// of each device. It implements crypto.CollectiveAuthority, so the devices can be addressed as players of an RPC and
+// the authority can be passed to dela components expecting Ed25519 keys, such as the Pedersen DKG run by
+// node.Device.ListenDKG. Collective signing with cosi requires BLS keys and is thus not supported by the authority.
+type CollectiveAuthority struct {
+	addrs   []mino.Address
+	pubkeys []kyber.Point
+}
+
+var _ crypto.CollectiveAuthority = CollectiveAuthority{}
+
+// NewAuthority returns the collective authority of the devices with the given addresses and public keys, which must
+// be given in the same order.
+func NewAuthority(addrs []mino.Address, pubkeys []kyber.Point) (CollectiveAuthority, error) {
+	if len(addrs) != len(pubkeys) {
+		return CollectiveAuthority{}, errors.Errorf("%d addresses and %d public keys given", len(addrs), len(pubkeys))
+	}
+	return CollectiveAuthority{
+		pubkeys: pubkeys,
+		addrs:   addrs,
+	}, nil
+}
+
+// Len implements mino.Players. It returns the number of devices.
+func (ca CollectiveAuthority) Len() int {
+	return len(ca.addrs)
+}
+
+// Take implements mino.Players. It returns the devices selected by the filters.
+func (ca CollectiveAuthority) Take(updaters ...mino.FilterUpdater) mino.Players {
+	filter := mino.ApplyFilters(updaters)
+	taken := CollectiveAuthority{
+		addrs:   make([]mino.Address, len(filter.Indices)),
+		pubkeys: make([]kyber.Point, len(filter.Indices)),
+	}
+	for i, k := range filter.Indices {
+		taken.addrs[i] = ca.addrs[k]
+		taken.pubkeys[i] = ca.pubkeys[k]
+	}
+	return taken
+}
+
+// AddressIterator implements mino.Players. It iterates over the addresses of the devices.
+func (ca CollectiveAuthority) AddressIterator() mino.AddressIterator {
+	return mino.NewAddressIterator(ca.addrs)
+}
+
+// GetPublicKey implements crypto.CollectiveAuthority. It returns the public key of the device with the address and
+// its index, or nil and -1 if no device has the address.
+func (ca CollectiveAuthority) GetPublicKey(addr mino.Address) (crypto.PublicKey, int) {
+	for i, a := range ca.addrs {
+		if a.Equal(addr) {
+			return ed25519.NewPublicKeyFromPoint(ca.pubkeys[i]), i
+		}
+	}
+	return nil, -1
+}
+
+// PublicKeyIterator implements crypto.CollectiveAuthority. It iterates over the public keys of the devices in the
+// order of AddressIterator.
+func (ca CollectiveAuthority) PublicKeyIterator() crypto.PublicKeyIterator {
+	return &publicKeyIterator{pubkeys: ca.pubkeys}
+}
+
+// publicKeyIterator iterates over the public keys of an authority.
+type publicKeyIterator struct {
+	index   int
+	pubkeys []kyber.Point
+}
+
+// Seek implements crypto.PublicKeyIterator.
+func (it *publicKeyIterator) Seek(index int) {
+	it.index = index
+}
+
+// HasNext implements crypto.PublicKeyIterator.
+func (it *publicKeyIterator) HasNext() bool {
+	return it.index < len(it.pubkeys)
+}
+
+// GetNext implements crypto.PublicKeyIterator.
+func (it *publicKeyIterator) GetNext() crypto.PublicKey {
+	pubkey := ed25519.NewPublicKeyFromPoint(it.pubkeys[it.index])
+	it.index++
+	return pubkey
+}
+
+// InitDevices creates a committee of n devices sharing a fresh key with threshold t, each joining a minogrpc overlay
+// on the loopback interface. The certificates of all overlays are exchanged in-process.
+func InitDevices(t int, n int) (CollectiveAuthority, []node.Device) {
+	minos := make([]mino.Mino, n)
+	devices := make([]node.Device, n)
+	addrs := make([]mino.Address, n)
+	for i := 0; i < n; i++ {
+		addr := minogrpc.ParseAddress("127.0.0.1", 0)
+		minogrpc, _ := minogrpc.NewMinogrpc(addr, nil, tree.NewRouter(minogrpc.NewAddressFactory()))
+
+		minos[i] = minogrpc
+		addrs[i] = minogrpc.GetAddress()
+	}
+
+	pubkeys := make([]kyber.Point, len(minos))
+
+	pubShares, privShares, pubkeyGlobal, commitments := node.GenSharedKey(uint32(t), uint32(n))
+
+	chaincode, _ := node.NewMasterChainCode()
+	index := uint32(0x0)
+
+	for i, mino := range minos {
+		for _, m := range minos {
+			mino.(*minogrpc.Minogrpc).GetCertificateStore().Store(m.GetAddress(),
+				m.(*minogrpc.Minogrpc).GetCertificateChain())
+		}
+
+		device, pubkey := node.NewDevice(
+			i,
+			uint32(t),
+			uint32(n),
+			pubShares[uint32(i)+1].Point,
+			privShares[uint32(i)+1].ShamirShare,
+			pubkeyGlobal,
+			commitments,
+			index,
+			chaincode,
+			mino.(*minogrpc.Minogrpc),
+		)
+
+		if err := device.VerifyShare(); err != nil {
+			panic(err)
+		}
+
+		pubkeys[i] = pubkey
+		devices[i] = device
+	}
+
+	Authority, err := NewAuthority(addrs, pubkeys)
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := node.RerandomizeDevices(devices); err != nil {
+		panic(err)
+	}
+
+	return Authority, devices
+}
@@ -0,0 +1,105 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/dela/crypto/ed25519"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/dela/mino/minogrpc"
+	"go.dedis.ch/dela/mino/minogrpc/session"
+	"go.dedis.ch/dela/mino/router/tree"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/node"
+)
+
+func TestCollectiveAuthority(t *testing.T) {
+	suite := suites.MustFind("Ed25519")
+	addrs := make([]mino.Address, 4)
+	pubkeys := make([]kyber.Point, 4)
+	for i := range addrs {
+		addrs[i] = session.NewAddress(fmt.Sprintf("127.0.0.1:%d", 2001+i))
+		pubkeys[i] = suite.Point().Pick(suite.RandomStream())
+	}
+	ca, err := cluster.NewAuthority(addrs, pubkeys)
+	require.NoError(t, err)
+	assert.Equal(t, 4, ca.Len())
+	_, err = cluster.NewAuthority(addrs, pubkeys[1:])
+	assert.Error(t, err)
+
+	pubkey, index := ca.GetPublicKey(addrs[2])
+	assert.Equal(t, 2, index)
+	assert.True(t, pubkey.Equal(ed25519.NewPublicKeyFromPoint(pubkeys[2])))
+	pubkey, index = ca.GetPublicKey(session.NewAddress("127.0.0.1:3000"))
+	assert.Nil(t, pubkey)
+	assert.Equal(t, -1, index)
+
+	addrIter, pubkeyIter := ca.AddressIterator(), ca.PublicKeyIterator()
+	for i := range addrs {
+		require.True(t, addrIter.HasNext())
+		require.True(t, pubkeyIter.HasNext())
+		assert.Equal(t, addrs[i], addrIter.GetNext())
+		assert.True(t, pubkeyIter.GetNext().Equal(ed25519.NewPublicKeyFromPoint(pubkeys[i])))
+	}
+	assert.False(t, addrIter.HasNext())
+	assert.False(t, pubkeyIter.HasNext())
+	pubkeyIter.Seek(3)
+	assert.True(t, pubkeyIter.GetNext().Equal(ed25519.NewPublicKeyFromPoint(pubkeys[3])))
+
+	taken := ca.Take(mino.RangeFilter(1, 3), mino.RotateFilter(1)).(cluster.CollectiveAuthority)
+	require.Equal(t, 2, taken.Len())
+	_, index = taken.GetPublicKey(addrs[2])
+	assert.Equal(t, 0, index)
+	_, index = taken.GetPublicKey(addrs[1])
+	assert.Equal(t, 1, index)
+	assert.Equal(t, 0, ca.Take().Len())
+}
+
+// The authority of the device keys is used with dela's Pedersen DKG run by the devices.
+func TestCollectiveAuthorityDKG(t *testing.T) {
+	n := 3
+	pkShares, skShares, pk, commitments := node.GenSharedKey(2, uint32(n))
+	chainCode, err := node.NewMasterChainCode()
+	require.NoError(t, err)
+
+	minos := make([]*minogrpc.Minogrpc, n)
+	devices := make([]node.Device, n)
+	addrs := make([]mino.Address, n)
+	pubkeys := make([]kyber.Point, n)
+	for i := range minos {
+		m, err := minogrpc.NewMinogrpc(minogrpc.ParseAddress("127.0.0.1", 0), nil, tree.NewRouter(minogrpc.NewAddressFactory()))
+		require.NoError(t, err)
+		t.Cleanup(func() { m.GracefulStop() })
+		minos[i] = m
+		addrs[i] = m.GetAddress()
+
+		id := uint32(i + 1)
+		devices[i], _ = node.NewDevice(i, 2, uint32(n), pkShares[id].Point, skShares[id].ShamirShare, pk, commitments, 0, chainCode, m)
+		pubkeys[i] = devices[i].DeviceKey()
+	}
+	for _, m := range minos {
+		for _, other := range minos {
+			require.NoError(t, m.GetCertificateStore().Store(other.GetAddress(), other.GetCertificateChain()))
+		}
+	}
+
+	dkgs := make([]*node.DKG, n)
+	for i := range devices {
+		dkgs[i], err = devices[i].ListenDKG()
+		require.NoError(t, err)
+	}
+	ca, err := cluster.NewAuthority(addrs, pubkeys)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pubkey, err := dkgs[0].Setup(ctx, ca, 2)
+	require.NoError(t, err)
+	assert.NotNil(t, pubkey)
+}
@@ -0,0 +1,373 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/dela/mino/minogrpc/certs"
+	"go.dedis.ch/dela/mino/minogrpc/session"
+	"go.dedis.ch/kyber/v3"
+	"gopkg.in/yaml.v3"
+
+	"bip32_threshold_wallet/node"
+)
+
+// Role is the role of a device in the committee.
+type Role string
+
+const (
+	// RoleSigner devices take part in all protocols.
+	RoleSigner Role = "signer"
+	// RoleStandby devices hold a share, but only take part in derivations and signing sessions if too few signers are
+	// reachable. They always take part in refreshes.
+	RoleStandby Role = "standby"
+)
+
+// certificateDuration is the validity of the TLS certificates created by NewCertificate.
+const certificateDuration = 10 * 365 * 24 * time.Hour
+
+// DeviceConfig describes a device of the committee.
+type DeviceConfig struct {
+	ID          string `yaml:"id"`          // Human-readable name of the device.
+	Share       uint32 `yaml:"share"`       // Identifier of the device's share, i.e. the x-coordinate of the sharing.
+	Address     string `yaml:"address"`     // Overlay address of form <host>:<port>.
+	Certificate string `yaml:"certificate"` // PEM file of the overlay's TLS certificate, relative to the config file.
+	DeviceKey   string `yaml:"deviceKey"`   // Hex-encoded public Ed25519 device key.
+	Role        Role   `yaml:"role,omitempty"`
+
+	certChain certs.CertChain
+	deviceKey kyber.Point
+}
+
+// Config describes the topology of a committee of devices sharing a key.
+type Config struct {
+	Threshold uint32         `yaml:"threshold"`
+	Parties   uint32         `yaml:"parties"`
+	Devices   []DeviceConfig `yaml:"devices"`
+}
+
+// LoadConfig reads and validates a YAML cluster configuration, including the certificates it refers to.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cluster configuration")
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var c Config
+	if err := dec.Decode(&c); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if d.Certificate == "" {
+			return nil, errors.Errorf("device %q has no certificate", d.ID)
+		}
+		certPath := d.Certificate
+		if !filepath.IsAbs(certPath) {
+			certPath = filepath.Join(dir, certPath)
+		}
+		if d.certChain, err = readCertificate(certPath); err != nil {
+			return nil, errors.Wrapf(err, "device %q", d.ID)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid cluster configuration %s", path)
+	}
+	return &c, nil
+}
+
+// WriteConfig writes the configuration of a committee of devices listening on the given addresses to path. A new TLS
+// certificate and key is created for each device and written next to the configuration as tls-<share>.pem and
+// tls-<share>.key.
+func WriteConfig(path string, devices []node.Device, addresses []string) (*Config, error) {
+	if len(devices) == 0 || len(addresses) != len(devices) {
+		return nil, errors.Errorf("%d addresses given for %d devices", len(addresses), len(devices))
+	}
+	c := Config{
+		Threshold: devices[0].Threshold(),
+		Parties:   devices[0].Parties(),
+		Devices:   make([]DeviceConfig, len(devices)),
+	}
+	dir := filepath.Dir(path)
+	for i := range devices {
+		share := devices[i].Identifier()
+		cert, key, err := NewCertificate(addresses[i])
+		if err != nil {
+			return nil, err
+		}
+		certFile := fmt.Sprintf("tls-%d.pem", share)
+		if err := os.WriteFile(filepath.Join(dir, certFile), cert, 0644); err != nil {
+			return nil, errors.Wrap(err, "writing certificate")
+		}
+		if err := node.WriteSecretFile(filepath.Join(dir, fmt.Sprintf("tls-%d.key", share)), key); err != nil {
+			return nil, err
+		}
+		deviceKey, err := devices[i].DeviceKey().MarshalBinary()
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding device key")
+		}
+
+		c.Devices[i] = DeviceConfig{
+			ID:          fmt.Sprintf("device-%d", share),
+			Share:       share,
+			Address:     addresses[i],
+			Certificate: certFile,
+			DeviceKey:   hex.EncodeToString(deviceKey),
+			Role:        RoleSigner,
+		}
+	}
+
+	if err := c.Save(path); err != nil {
+		return nil, err
+	}
+	return LoadConfig(path)
+}
+
+// Save writes the configuration as YAML. The certificates are not written.
+func (c *Config) Save(path string) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return errors.Wrap(err, "encoding cluster configuration")
+	}
+	return errors.Wrap(os.WriteFile(path, buf.Bytes(), 0644), "writing cluster configuration")
+}
+
+// Validate checks that the configuration describes a consistent committee.
+func (c *Config) Validate() error {
+	if c.Threshold == 0 || c.Threshold > c.Parties {
+		return errors.Errorf("invalid threshold %d of %d", c.Threshold, c.Parties)
+	}
+	if uint32(len(c.Devices)) != c.Parties {
+		return errors.Errorf("%d devices are configured for %d parties", len(c.Devices), c.Parties)
+	}
+
+	ids := make(map[string]bool, len(c.Devices))
+	shares := make(map[uint32]bool, len(c.Devices))
+	addrs := make(map[string]bool, len(c.Devices))
+	signers := uint32(0)
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if d.ID == "" {
+			return errors.Errorf("device %d has no id", i)
+		}
+		if ids[d.ID] {
+			return errors.Errorf("duplicate device id %q", d.ID)
+		}
+		ids[d.ID] = true
+
+		if d.Share == 0 || d.Share > c.Parties {
+			return errors.Errorf("share %d of device %q is not in 1, ..., %d", d.Share, d.ID, c.Parties)
+		}
+		if shares[d.Share] {
+			return errors.Errorf("duplicate share %d", d.Share)
+		}
+		shares[d.Share] = true
+
+		if _, _, err := net.SplitHostPort(d.Address); err != nil {
+			return errors.Wrapf(err, "address of device %q", d.ID)
+		}
+		if addrs[d.Address] {
+			return errors.Errorf("duplicate address %s", d.Address)
+		}
+		addrs[d.Address] = true
+
+		if d.certChain != nil {
+			if err := verifyCertificate(d.certChain, d.Address); err != nil {
+				return errors.Wrapf(err, "certificate of device %q", d.ID)
+			}
+		}
+
+		key, err := hex.DecodeString(d.DeviceKey)
+		if err != nil {
+			return errors.Wrapf(err, "device key of device %q", d.ID)
+		}
+		if d.deviceKey, err = node.UnmarshalDeviceKey(key); err != nil {
+			return errors.Wrapf(err, "device %q", d.ID)
+		}
+
+		switch d.Role {
+		case "":
+			d.Role = RoleSigner
+			signers++
+		case RoleSigner:
+			signers++
+		case RoleStandby:
+		default:
+			return errors.Errorf("unknown role %q of device %q", d.Role, d.ID)
+		}
+	}
+	if signers < c.Threshold {
+		return errors.Errorf("%d signers are configured, at least %d are required", signers, c.Threshold)
+	}
+	return nil
+}
+
+// Device returns the configuration of the device holding the share with the given identifier.
+func (c *Config) Device(share uint32) (*DeviceConfig, error) {
+	for i := range c.Devices {
+		if c.Devices[i].Share == share {
+			return &c.Devices[i], nil
+		}
+	}
+	return nil, errors.Errorf("no device holds share %d", share)
+}
+
+// Authority returns the collective authority of the configured devices.
+func (c *Config) Authority() (CollectiveAuthority, error) {
+	addrs := make([]mino.Address, len(c.Devices))
+	pubkeys := make([]kyber.Point, len(c.Devices))
+	for i := range c.Devices {
+		addrs[i] = c.Devices[i].MinoAddress()
+		pubkeys[i] = c.Devices[i].deviceKey
+	}
+	return NewAuthority(addrs, pubkeys)
+}
+
+// StoreCertificates adds the certificates of all devices to the certificate store of an overlay, so that the devices
+// can reach each other without joining.
+func (c *Config) StoreCertificates(store certs.Storage) error {
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if d.certChain == nil {
+			return errors.Errorf("certificate of device %q is not loaded", d.ID)
+		}
+		if err := store.Store(d.MinoAddress(), d.certChain); err != nil {
+			return errors.Wrapf(err, "storing certificate of device %q", d.ID)
+		}
+	}
+	return nil
+}
+
+// MinoAddress returns the overlay address of the device.
+func (d *DeviceConfig) MinoAddress() mino.Address {
+	return session.NewAddress(d.Address)
+}
+
+// PublicDeviceKey returns the device key of a validated configuration.
+func (d *DeviceConfig) PublicDeviceKey() kyber.Point {
+	return d.deviceKey
+}
+
+// NewCertificate creates a self-signed TLS certificate for the overlay of a device listening on the address, as
+// minogrpc creates it for overlays without a configured certificate. It returns the PEM-encoded certificate and key.
+func NewCertificate(address string) ([]byte, []byte, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing address")
+	}
+	secret, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generating certificate key")
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(certificateDuration),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		MaxPathLen:            1,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, secret.Public(), secret)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating certificate")
+	}
+	key, err := x509.MarshalECPrivateKey(secret)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "encoding certificate key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), nil
+}
+
+// TLSCertificate pairs the device's certificate with its key read from a PEM file, to be used by the device's overlay.
+func (d *DeviceConfig) TLSCertificate(keyFile string) (*tls.Certificate, error) {
+	if d.certChain == nil {
+		return nil, errors.Errorf("certificate of device %q is not loaded", d.ID)
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading certificate key")
+	}
+	parsed, err := x509.ParseCertificates(d.certChain)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing certificate")
+	}
+	var chain []byte
+	for _, c := range parsed {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	cert, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "certificate key does not match certificate of device %q", d.ID)
+	}
+	return &cert, nil
+}
+
+// readCertificate reads a PEM file of a certificate chain and returns the concatenated DER certificates, as stored
+// by minogrpc.
+func readCertificate(path string) (certs.CertChain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading certificate")
+	}
+	var chain []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes...)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.Errorf("no certificate in %s", path)
+	}
+	return chain, nil
+}
+
+// verifyCertificate checks that the leaf certificate of the chain is valid for the host of the address.
+func verifyCertificate(chain certs.CertChain, address string) error {
+	parsed, err := x509.ParseCertificates(chain)
+	if err != nil {
+		return errors.Wrap(err, "parsing certificate")
+	}
+	host, _, _ := net.SplitHostPort(address)
+	if err := parsed[0].VerifyHostname(host); err != nil {
+		return err
+	}
+	if time.Now().After(parsed[0].NotAfter) {
+		return errors.New("certificate has expired")
+	}
+	return nil
+}
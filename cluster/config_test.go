@@ -0,0 +1,81 @@
+package cluster_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/dela/crypto/ed25519"
+
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestConfig(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	path := filepath.Join(t.TempDir(), "cluster.yaml")
+	addrs := []string{"127.0.0.1:2001", "127.0.0.1:2002", "localhost:2003"}
+
+	c, err := cluster.WriteConfig(path, devices, addrs)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), c.Threshold)
+
+	loaded, err := cluster.LoadConfig(path)
+	require.NoError(t, err)
+	for i := range devices {
+		d, err := loaded.Device(devices[i].Identifier())
+		require.NoError(t, err)
+		assert.Equal(t, addrs[i], d.Address)
+		assert.True(t, d.PublicDeviceKey().Equal(devices[i].DeviceKey()))
+
+		_, err = d.TLSCertificate(filepath.Join(filepath.Dir(path), fmt.Sprintf("tls-%d.key", d.Share)))
+		assert.NoError(t, err)
+	}
+	_, err = loaded.Device(4)
+	assert.Error(t, err)
+
+	// The collective authority holds the configured devices with their device keys.
+	ca, err := loaded.Authority()
+	require.NoError(t, err)
+	assert.Equal(t, len(devices), ca.Len())
+	for i := range loaded.Devices {
+		pubkey, idx := ca.GetPublicKey(loaded.Devices[i].MinoAddress())
+		require.NotNil(t, pubkey)
+		assert.Equal(t, i, idx)
+		assert.True(t, pubkey.(ed25519.PublicKey).GetPoint().Equal(loaded.Devices[i].PublicDeviceKey()))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for name, edit := range map[string]func(string) string{
+		"threshold above parties": func(s string) string {
+			return strings.Replace(s, "threshold: 2", "threshold: 4", 1)
+		},
+		"too few signers": func(s string) string {
+			return strings.Replace(s, "role: signer", "role: standby", 2)
+		},
+		"unknown role": func(s string) string {
+			return strings.Replace(s, "role: signer", "role: leader", 1)
+		},
+		"duplicate share": func(s string) string {
+			return strings.Replace(s, "share: 2", "share: 1", 1)
+		},
+		"certificate of another host": func(s string) string {
+			return strings.Replace(s, "localhost:2003", "example.com:2003", 1)
+		},
+		"unknown field": func(s string) string {
+			return s + "leader: 1\n"
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			invalid := filepath.Join(filepath.Dir(path), "invalid.yaml")
+			require.NoError(t, os.WriteFile(invalid, []byte(edit(string(data))), 0644))
+			_, err := cluster.LoadConfig(invalid)
+			assert.Error(t, err)
+		})
+	}
+}
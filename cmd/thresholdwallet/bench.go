@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/simnet"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/utils"
+)
+
+func runBench(args []string) error {
+	fs := newFlagSet("bench", "")
+	t := fs.Uint("t", 2, "number of devices needed to use the key")
+	n := fs.Uint("n", 5, "number of devices")
+	children := fs.Int("children", 10, "number of hardened children to derive")
+	latency := fs.Duration("latency", 10*time.Millisecond, "mean latency of the simulated links between the devices")
+	stddev := fs.Duration("stddev", 0, "standard deviation of the normally distributed latency")
+	jitter := fs.Duration("jitter", 0, "maximum jitter added to the latency of each message")
+	loss := fs.Float64("loss", 0, "probability that a message is lost")
+	bandwidth := fs.Int64("bandwidth", 0, "bandwidth of each link in bytes per second (default unlimited)")
+	seed := fs.Int64("seed", 1, "seed of the simulated network")
+	optimized := fs.Bool("optimized", false, "combine the TVRF evaluations with a multi-scalar multiplication")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *t == 0 || *t > *n {
+		return errors.Errorf("invalid threshold %d of %d", *t, *n)
+	}
+	if *children <= 0 {
+		return errors.New("at least one child must be derived")
+	}
+	if *loss < 0 || *loss >= 1 {
+		return errors.Errorf("invalid loss probability %g", *loss)
+	}
+
+	curve := curves.K256()
+	devices := utils.CreateDevices(uint32(*t), uint32(*n))
+	deriv := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(uint32(*t), uint32(*n), curve, sha256.New(), *optimized), true)
+	network := simnet.New(*seed, simnet.Link{
+		Latency:   simnet.Normal{Mean: *latency, StdDev: *stddev},
+		Jitter:    *jitter,
+		Loss:      *loss,
+		Bandwidth: *bandwidth,
+	})
+	deriv.SetNetwork(network, 10*time.Second+10*(*latency))
+
+	start := time.Now()
+	for i := 0; i < *children; i++ {
+		if _, err := deriv.DeriveSharedHardenedChild(uint32(i)); err != nil {
+			return errors.Wrapf(err, "deriving child %d", i)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// Every device but the combining one sends its signed evaluation and proof.
+	stats := network.Stats()
+	fmt.Printf("t=%d n=%d latency=%s: %d hardened children in %s, %s per child, %d bytes in %d messages sent, %d lost\n",
+		*t, *n, *latency, *children, elapsed, elapsed/time.Duration(*children), stats.Bytes, stats.Sent, stats.Lost+stats.Partitioned)
+	return nil
+}
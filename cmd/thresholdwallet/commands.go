@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/message"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+func runKeygen(args []string) error {
+	fs := newFlagSet("keygen", "")
+	var c committee
+	c.register(fs)
+	t := fs.Uint("t", 2, "number of devices needed to use the key")
+	n := fs.Uint("n", 3, "number of devices")
+	withECDSA := fs.Bool("ecdsa", false, "also generate the Paillier keys for threshold ECDSA, which takes a while")
+	clusterFile := fs.String("cluster", "", "also write a cluster configuration with TLS certificates for device daemons")
+	addresses := fs.String("addresses", "", "comma-separated overlay addresses of the devices in the cluster configuration (default 127.0.0.1:2001, ...)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *t == 0 || *t > *n {
+		return errors.Errorf("invalid threshold %d of %d", *t, *n)
+	}
+	if existing, _ := filepath.Glob(filepath.Join(c.dir, keystorePattern)); len(existing) > 0 {
+		return errors.Errorf("%s already contains keystores", c.dir)
+	}
+
+	devices := utils.CreateDevices(uint32(*t), uint32(*n))
+	if _, err := node.RerandomizeDevices(devices); err != nil {
+		return err
+	}
+	if err := c.save(devices); err != nil {
+		return err
+	}
+	log.Infof("wrote %d keystores to %s", len(devices), c.dir)
+
+	if *clusterFile != "" {
+		addrs := make([]string, len(devices))
+		if *addresses != "" {
+			addrs = strings.Split(*addresses, ",")
+		} else {
+			for i := range devices {
+				addrs[i] = fmt.Sprintf("127.0.0.1:%d", 2000+devices[i].Identifier())
+			}
+		}
+		if _, err := cluster.WriteConfig(*clusterFile, devices, addrs); err != nil {
+			return err
+		}
+		log.Infof("wrote cluster configuration %s", *clusterFile)
+	}
+
+	if *withECDSA {
+		log.Info("generating threshold ECDSA parameters")
+		params, decryptKeys, err := newECDSAParams(devices)
+		if err != nil {
+			return err
+		}
+		if err := c.saveECDSA(devices, params, decryptKeys); err != nil {
+			return err
+		}
+	}
+
+	xpub, err := devices[0].XPub()
+	if err != nil {
+		return err
+	}
+	return printXPub(xpub, "mainnet")
+}
+
+func runXPub(args []string) error {
+	fs := newFlagSet("xpub", "")
+	var c committee
+	c.register(fs)
+	network := fs.String("network", "mainnet", "network the key is encoded for: mainnet, testnet or regtest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	devices, err := c.load()
+	if err != nil {
+		return err
+	}
+	xpub, err := devices[0].XPub()
+	if err != nil {
+		return err
+	}
+	return printXPub(xpub, *network)
+}
+
+func runDerive(args []string) error {
+	fs := newFlagSet("derive", "<path>")
+	var c committee
+	c.register(fs)
+	network := fs.String("network", "mainnet", "network the key is encoded for: mainnet, testnet or regtest")
+	path, err := parsePathArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	devices, err := c.load()
+	if err != nil {
+		return err
+	}
+	key, err := resolvePath(devices, path)
+	if err != nil {
+		return err
+	}
+	xpub, err := key.xpub()
+	if err != nil {
+		return err
+	}
+	return printXPub(xpub, *network)
+}
+
+func runAddress(args []string) error {
+	fs := newFlagSet("address", "<path>")
+	var c committee
+	c.register(fs)
+	network := fs.String("network", "mainnet", "network of the address: mainnet, testnet or regtest")
+	typ := fs.String("type", "p2wpkh", "address type: p2pkh, p2sh-p2wpkh, p2wpkh or p2tr")
+	path, err := parsePathArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	t, err := address.ParseType(*typ)
+	if err != nil {
+		return err
+	}
+	params, err := address.NetParams(*network)
+	if err != nil {
+		return err
+	}
+	devices, err := c.load()
+	if err != nil {
+		return err
+	}
+	key, err := resolvePath(devices, path)
+	if err != nil {
+		return err
+	}
+	pk, err := key.publicKey()
+	if err != nil {
+		return err
+	}
+	addr, err := address.Encode(pk, t, params)
+	if err != nil {
+		return err
+	}
+	fmt.Println(addr)
+	return nil
+}
+
+func runSign(args []string) error {
+	fs := newFlagSet("sign", "<path>")
+	var c committee
+	c.register(fs)
+	format := fs.String("format", "bip322", "signature format: ecdsa or bip340 of a digest, or bip137 or bip322 of a message")
+	msg := fs.String("msg", "", "message to sign, hashed with SHA-256 for the ecdsa and bip340 formats")
+	digestHex := fs.String("digest", "", "hex encoded 32-byte digest to sign with the ecdsa and bip340 formats")
+	network := fs.String("network", "mainnet", "network of the address of message signatures")
+	typ := fs.String("type", "p2wpkh", "address type of message signatures")
+	path, err := parsePathArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	devices, err := c.load()
+	if err != nil {
+		return err
+	}
+	key, err := resolvePath(devices, path)
+	if err != nil {
+		return err
+	}
+
+	// Only threshold ECDSA needs the Paillier keys, BIP340 signatures are produced with FROST.
+	var ecdsaParams *signing.ECDSAParams
+	var decryptKeys map[uint32]*paillier.SecretKey
+	loadECDSA := func() error {
+		if ecdsaParams != nil {
+			return nil
+		}
+		ecdsaParams, decryptKeys, err = c.loadECDSA(key.signers())
+		return err
+	}
+
+	switch *format {
+	case "ecdsa", "bip340":
+		digest, err := digestToSign(*msg, *digestHex)
+		if err != nil {
+			return err
+		}
+		if *format == "bip340" {
+			sig, err := key.signBIP340(digest)
+			if err != nil {
+				return err
+			}
+			fmt.Println(hex.EncodeToString(sig))
+			return nil
+		}
+		if err := loadECDSA(); err != nil {
+			return err
+		}
+		r, s, err := key.digestSigner(ecdsaParams, decryptKeys)(digest)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString((&btcec.Signature{R: r, S: s}).Serialize()))
+		return nil
+
+	case "bip137", "bip322":
+		t, err := address.ParseType(*typ)
+		if err != nil {
+			return err
+		}
+		params, err := address.NetParams(*network)
+		if err != nil {
+			return err
+		}
+		if t != address.P2TR {
+			if err := loadECDSA(); err != nil {
+				return err
+			}
+		}
+		k, err := key.messageKey(ecdsaParams, decryptKeys)
+		if err != nil {
+			return err
+		}
+
+		var sig string
+		if *format == "bip137" {
+			sig, err = message.SignBIP137(k, t, []byte(*msg))
+		} else {
+			sig, err = message.SignBIP322(k, t, []byte(*msg), params)
+		}
+		if err != nil {
+			return err
+		}
+		addr, err := address.Encode(k.PublicKey(), t, params)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("address:   %s\nsignature: %s\n", addr, sig)
+		return nil
+	}
+
+	return errors.Errorf("unknown signature format %q", *format)
+}
+
+func runRefresh(args []string) error {
+	fs := newFlagSet("refresh", "")
+	var c committee
+	c.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	devices, err := c.load()
+	if err != nil {
+		return err
+	}
+	if uint32(len(devices)) < devices[0].Parties() {
+		return errors.Errorf("refreshing requires all %d devices", devices[0].Parties())
+	}
+	if err := node.RefreshDevices(devices); err != nil {
+		return err
+	}
+	if err := c.save(devices); err != nil {
+		return err
+	}
+	fmt.Printf("refreshed %d devices to epoch %d\n", len(devices), devices[0].Epoch())
+	return nil
+}
+
+// digestToSign returns the given hex encoded digest or the SHA-256 of the message.
+func digestToSign(msg, digestHex string) ([]byte, error) {
+	if digestHex == "" {
+		digest := sha256.Sum256([]byte(msg))
+		return digest[:], nil
+	}
+	if msg != "" {
+		return nil, errors.New("either a message or a digest must be given")
+	}
+	digest, err := hex.DecodeString(digestHex)
+	if err != nil || len(digest) != 32 {
+		return nil, errors.New("digest must be 32 hex encoded bytes")
+	}
+	return digest, nil
+}
+
+func printXPub(xpub *bip32.Key, network string) error {
+	params, err := address.NetParams(network)
+	if err != nil {
+		return err
+	}
+	key := *xpub
+	key.Version = params.HDPublicKeyID[:]
+	_, err = fmt.Fprintln(os.Stdout, key.String())
+	return err
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/daemon"
+	"bip32_threshold_wallet/wallet"
+)
+
+const defaultControl = "unix:thresholdwallet.sock"
+
+// controlTokenEnv is the environment variable the control token is read from if no token file is given.
+const controlTokenEnv = "THRESHOLD_WALLET_CONTROL_TOKEN"
+
+// controlToken reads the bearer token of the control API from the file or, if none is given, from the environment.
+// The token is optional for control sockets.
+func controlToken(file string) (string, error) {
+	if file == "" {
+		return os.Getenv(controlTokenEnv), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrap(err, "reading control token file")
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func runDaemon(args []string) error {
+	fs := newFlagSet("daemon", "")
+	var c committee
+	fs.StringVar(&c.passwordFile, "password-file", "", "file containing the keystore password (default $"+passwordEnv+")")
+	keystore := fs.String("keystore", "", "keystore of the device run by the daemon")
+	listen := fs.String("listen", "127.0.0.1:2000", "address the overlay listens on")
+	public := fs.String("public", "", "public URL of the overlay of form //<host>:<port> (default the listen address)")
+	control := fs.String("control", defaultControl, "control endpoint, either unix:<path> or a loopback TCP address")
+	tokenFile := fs.String("control-token-file", "", "file containing the control token, required for TCP (default $"+controlTokenEnv+")")
+	clusterFile := fs.String("cluster", "", "cluster configuration to connect to the configured devices instead of joining")
+	share := fs.Uint("share", 0, "share identifier of the device in the cluster configuration")
+	tlsKey := fs.String("tls-key", "", "key of the device's certificate in the cluster configuration (default tls-<share>.key next to it)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keystore == "" {
+		fs.Usage()
+		return errors.New("no keystore given")
+	}
+	password, err := c.password()
+	if err != nil {
+		return err
+	}
+	token, err := controlToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+
+	cfg := daemon.Config{
+		Keystore:     *keystore,
+		Password:     password,
+		KDFParams:    kdfParams,
+		Listen:       *listen,
+		Public:       *public,
+		Control:      *control,
+		ControlToken: token,
+	}
+	if *clusterFile != "" {
+		if *share == 0 {
+			return errors.New("no share given for the cluster configuration")
+		}
+		if cfg.Cluster, err = cluster.LoadConfig(*clusterFile); err != nil {
+			return err
+		}
+		cfg.Share = uint32(*share)
+		cfg.TLSKey = *tlsKey
+		if cfg.TLSKey == "" {
+			cfg.TLSKey = filepath.Join(filepath.Dir(*clusterFile), fmt.Sprintf("tls-%d.key", *share))
+		}
+		// The configured address is used unless another listen address is given explicitly.
+		if !isFlagSet(fs, "listen") {
+			cfg.Listen = ""
+		}
+	}
+
+	d, err := daemon.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info("shutting down")
+		if err := d.Close(); err != nil {
+			log.Warnf("closing daemon: %v", err)
+		}
+	}()
+	return d.Serve()
+}
+
+func runCluster(args []string) error {
+	fs := newFlagSet("cluster", "<config>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single cluster configuration")
+	}
+	c, err := cluster.LoadConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ca, err := c.Authority()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("threshold %d of %d, collective authority of %d devices\n", c.Threshold, c.Parties, ca.Len())
+	for _, d := range c.Devices {
+		fmt.Printf("share %d: %s at %s (%s), device key %s\n", d.Share, d.ID, d.Address, d.Role, d.DeviceKey)
+	}
+	return nil
+}
+
+// isFlagSet returns whether the flag has been given on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+func runControl(args []string) error {
+	fs := newFlagSet("control", "status | token | join <token> | derive <index> | sign <path> <hex> | refresh | dkg")
+	control := fs.String("control", defaultControl, "control endpoint of the daemon")
+	tokenFile := fs.String("control-token-file", "", "file containing the control token (default $"+controlTokenEnv+")")
+	expiration := fs.String("expiration", "1h", "validity of a generated join token")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no request given")
+	}
+	token, err := controlToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	client := daemon.NewClient(*control, token)
+	req, rest := fs.Arg(0), fs.Args()[1:]
+
+	expectArgs := func(n int) error {
+		if len(rest) != n {
+			fs.Usage()
+			return errors.Errorf("%s expects %d arguments", req, n)
+		}
+		return nil
+	}
+
+	var reply interface{}
+	switch req {
+	case "status":
+		if err := expectArgs(0); err != nil {
+			return err
+		}
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		reply = status
+	case "token":
+		if err := expectArgs(0); err != nil {
+			return err
+		}
+		token, err := client.Token(*expiration)
+		if err != nil {
+			return err
+		}
+		reply = token
+	case "join":
+		if err := expectArgs(1); err != nil {
+			return err
+		}
+		var token daemon.JoinRequest
+		if err := json.Unmarshal([]byte(rest[0]), &token); err != nil {
+			return errors.Wrap(err, "parsing token, pass the output of the token request")
+		}
+		return client.Join(&token)
+	case "derive":
+		if err := expectArgs(1); err != nil {
+			return err
+		}
+		index, err := strconv.ParseUint(rest[0], 10, 31)
+		if err != nil {
+			return errors.Wrap(err, "parsing index")
+		}
+		xpub, err := client.Derive(uint32(index))
+		if err != nil {
+			return err
+		}
+		fmt.Println(xpub)
+		return nil
+	case "sign":
+		if err := expectArgs(2); err != nil {
+			return err
+		}
+		path, err := wallet.ParsePath(rest[0])
+		if err != nil {
+			return err
+		}
+		msg, err := hex.DecodeString(rest[1])
+		if err != nil {
+			return errors.Wrap(err, "decoding message")
+		}
+		sig, err := client.Sign(path, msg)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(sig.Signature))
+		return nil
+	case "refresh":
+		if err := expectArgs(0); err != nil {
+			return err
+		}
+		epoch, err := client.Refresh()
+		if err != nil {
+			return err
+		}
+		fmt.Println(epoch)
+		return nil
+	case "dkg":
+		if err := expectArgs(0); err != nil {
+			return err
+		}
+		pubkey, err := client.DKG()
+		if err != nil {
+			return err
+		}
+		fmt.Println(pubkey)
+		return nil
+	default:
+		fs.Usage()
+		return errors.Errorf("unknown request %q", req)
+	}
+
+	out, err := json.MarshalIndent(reply, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
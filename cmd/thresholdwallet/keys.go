@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/message"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/wallet"
+)
+
+// pathKey is the key at a path below the devices' node, which the devices use with threshold signing. The shares of the
+// node at the last hardened step of the path are derived by the devices, deriving hardened steps with a TVRF, and the
+// shares are tweaked with the non-hardened steps below it at signing time.
+type pathKey struct {
+	devices []node.Device
+	path    []uint32 // Non-hardened path below the devices' node.
+}
+
+// resolvePath parses the path and derives the key at it.
+func resolvePath(devices []node.Device, path string) (*pathKey, error) {
+	steps, err := wallet.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hardened := -1
+	for i, idx := range steps {
+		if idx >= bip32.FirstHardenedChild {
+			hardened = i
+		}
+	}
+	if hardened < 0 {
+		return &pathKey{devices: devices, path: steps}, nil
+	}
+
+	curve := curves.K256()
+	t, n := devices[0].Threshold(), devices[0].Parties()
+	if uint32(len(devices)) < n {
+		return nil, errors.Errorf("hardened derivation requires all %d devices", n)
+	}
+	for i, idx := range steps[:hardened+1] {
+		if idx < bip32.FirstHardenedChild {
+			if devices, err = derivation.NewNonHardDerivation(devices).DeriveNonHardenedChild(idx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		log.Debugf("deriving the hardened node at step %d with a TVRF", i+1)
+		deriv := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(t, n, curve, sha256.New(), true), true)
+		if devices, err = deriv.DeriveSharedHardenedChild(idx - bip32.FirstHardenedChild); err != nil {
+			return nil, err
+		}
+	}
+	return &pathKey{devices: devices, path: steps[hardened+1:]}, nil
+}
+
+// xpub returns the extended public key at the path.
+func (k *pathKey) xpub() (*bip32.Key, error) {
+	key, err := k.devices[0].XPub()
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range k.path {
+		if key, err = key.NewChildKey(idx); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// publicKey returns the public key at the path.
+func (k *pathKey) publicKey() (node.PublicKey, error) {
+	xpub, err := k.xpub()
+	if err != nil {
+		return nil, err
+	}
+	pk, err := curves.K256().Point.FromAffineCompressed(xpub.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &pk, nil
+}
+
+// signers returns the first t devices, which run the threshold signing protocols.
+func (k *pathKey) signers() []node.Device {
+	return k.devices[:k.devices[0].Threshold()]
+}
+
+// messageKey returns the key for message signing.
+func (k *pathKey) messageKey(params *signing.ECDSAParams, decryptKeys map[uint32]*paillier.SecretKey) (*message.Key, error) {
+	return message.ThresholdKey(k.signers(), decryptKeys, params, k.path)
+}
+
+// digestSigner returns the ECDSA signer of digests.
+func (k *pathKey) digestSigner(params *signing.ECDSAParams, decryptKeys map[uint32]*paillier.SecretKey) signing.DigestSigner {
+	return signing.ThresholdSigner(k.signers(), decryptKeys, params, k.path)
+}
+
+// signBIP340 produces a BIP340 signature of the 32-byte message under the x-only public key at the path.
+func (k *pathKey) signBIP340(msg []byte) ([]byte, error) {
+	key, err := signing.NewBIP340KeyForPath(&k.devices[0], k.path)
+	if err != nil {
+		return nil, err
+	}
+	return signing.SignSchnorr(k.signers(), key, msg)
+}
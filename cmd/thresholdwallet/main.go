@@ -0,0 +1,109 @@
+// Command thresholdwallet drives a committee of devices sharing a BIP32 node. Most commands keep the keystores of all
+// devices in one directory and run the devices in-process, e.g. for testing and benchmarking. The daemon command runs
+// a single device as its own process instead, which the control command sends requests to.
+//
+// Usage:
+//
+//	thresholdwallet [-v] <command> [flags] [arguments]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"keygen", "", "generate a fresh shared key and write the keystores of the devices", runKeygen},
+	{"xpub", "", "print the extended public key of the devices' node", runXPub},
+	{"derive", "<path>", "derive the node at a path and print its extended public key", runDerive},
+	{"address", "<path>", "print the address of the key at a path", runAddress},
+	{"sign", "<path>", "sign a message or digest with the key at a path", runSign},
+	{"refresh", "", "refresh the shares of all devices", runRefresh},
+	{"bench", "", "benchmark hardened derivation with a TVRF", runBench},
+	{"cluster", "<config>", "validate a cluster configuration and print its devices", runCluster},
+	{"daemon", "", "run a single device and serve its control API", runDaemon},
+	{"control", "<request>", "send a request to the control API of a daemon", runControl},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-v] <command> [flags] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-8s %-9s %s\n", c.name, c.args, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "enable debug logging")
+	flag.Usage = usage
+	flag.Parse()
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(flag.Args()[1:])
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// newFlagSet creates the flag set of a command, which prints the usage of the command on error.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\nFlags:\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parsePathArg parses the flags of a command taking a single path argument, which the flags may also follow.
+func parsePathArg(fs *flag.FlagSet, args []string) (string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return "", err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return "", errors.New("expected a single path")
+	}
+	return positional[0], nil
+}
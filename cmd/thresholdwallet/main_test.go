@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/daemon"
+	"bip32_threshold_wallet/internal/testutil"
+	"bip32_threshold_wallet/message"
+)
+
+func TestCommands(t *testing.T) {
+	kdfParams.Time, kdfParams.Memory, kdfParams.Threads = 1, 1024, 1
+	t.Setenv(passwordEnv, "password")
+	c := committee{dir: t.TempDir()}
+	dirFlag := []string{"-dir", c.dir}
+
+	clusterFile := filepath.Join(c.dir, "cluster.yaml")
+	require.NoError(t, runKeygen(append(dirFlag, "-t", "2", "-n", "3", "-cluster", clusterFile)))
+	assert.Error(t, runKeygen(dirFlag), "existing keystores must not be overwritten")
+
+	devices, err := c.load()
+	require.NoError(t, err)
+	require.Len(t, devices, 3)
+	xpub, err := devices[0].XPub()
+	require.NoError(t, err)
+
+	params, decryptKeys := testutil.CreateECDSAParams(3)
+	require.NoError(t, c.saveECDSA(devices, params, decryptKeys))
+	loadedParams, loadedKeys, err := c.loadECDSA(devices[:2])
+	require.NoError(t, err)
+	assert.Equal(t, params.EncryptKeys[2].N, loadedParams.EncryptKeys[2].N)
+	assert.Equal(t, decryptKeys[1].Lambda, loadedKeys[1].Lambda)
+
+	t.Run("Cluster", func(t *testing.T) {
+		require.NoError(t, runCluster([]string{clusterFile}))
+		config, err := cluster.LoadConfig(clusterFile)
+		require.NoError(t, err)
+		for i := range devices {
+			d, err := config.Device(devices[i].Identifier())
+			require.NoError(t, err)
+			assert.True(t, d.PublicDeviceKey().Equal(devices[i].DeviceKey()))
+		}
+	})
+
+	t.Run("Paths", func(t *testing.T) {
+		key, err := resolvePath(devices, "m/0/5")
+		require.NoError(t, err)
+		_, expected, _, err := devices[0].PathTweak([]uint32{0, 5})
+		require.NoError(t, err)
+		pk, err := key.publicKey()
+		require.NoError(t, err)
+		assert.True(t, (*pk).Equal(*expected))
+
+		// Keys below a hardened step are tweaks of the shares of the node the devices derive with a TVRF.
+		hardened, err := resolvePath(devices, "m/0/86'/0/1")
+		require.NoError(t, err)
+		assert.Equal(t, []uint32{0, 1}, hardened.path)
+		again, err := resolvePath(devices, "m/0/86h/0/1")
+		require.NoError(t, err)
+		xpub, err := hardened.xpub()
+		require.NoError(t, err)
+		againXPub, err := again.xpub()
+		require.NoError(t, err)
+		assert.Equal(t, xpub.String(), againXPub.String())
+		assert.Equal(t, uint8(4), xpub.Depth)
+
+		_, err = resolvePath(devices, "m/x")
+		assert.Error(t, err)
+	})
+
+	t.Run("Sign", func(t *testing.T) {
+		for _, path := range []string{"m/0/5", "m/84'/0"} {
+			key, err := resolvePath(devices, path)
+			require.NoError(t, err)
+			k, err := key.messageKey(params, decryptKeys)
+			require.NoError(t, err)
+			sig, err := message.SignBIP322(k, address.P2TR, []byte("hello"), &chaincfg.MainNetParams)
+			require.NoError(t, err)
+			addr, err := address.Encode(k.PublicKey(), address.P2TR, &chaincfg.MainNetParams)
+			require.NoError(t, err)
+			assert.NoError(t, message.VerifyBIP322(addr, []byte("hello"), sig, &chaincfg.MainNetParams))
+		}
+
+		assert.NoError(t, runSign(append(dirFlag, "-format", "bip137", "-msg", "hello", "m/1")))
+		assert.NoError(t, runSign(append(dirFlag, "m/1", "-format", "ecdsa", "-digest", "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")))
+		assert.Error(t, runSign(append(dirFlag, "-format", "bip340", "-msg", "hello")), "a path is required")
+		assert.Error(t, runSign(append(dirFlag, "-format", "unknown", "m/1")))
+	})
+
+	t.Run("Daemon", func(t *testing.T) {
+		password, err := c.password()
+		require.NoError(t, err)
+		clients := make([]*daemon.Client, len(devices))
+		for i := range devices {
+			control := "unix:" + filepath.Join(c.dir, fmt.Sprintf("control-%d.sock", i))
+			d, err := daemon.New(daemon.Config{
+				Keystore:     c.keystorePath(&devices[i]),
+				Password:     password,
+				KDFParams:    kdfParams,
+				Listen:       "127.0.0.1:0",
+				Control:      control,
+				ControlToken: "token",
+			})
+			require.NoError(t, err)
+			go d.Serve()
+			defer d.Close()
+			clients[i] = daemon.NewClient(control, "token")
+		}
+		for _, client := range clients[1:] {
+			token, err := clients[0].Token("1m")
+			require.NoError(t, err)
+			require.NoError(t, client.Join(token))
+		}
+
+		// The daemons derive the same hardened children as the devices deriving them in process.
+		key, err := resolvePath(devices, "m/7'")
+		require.NoError(t, err)
+		expected, err := key.xpub()
+		require.NoError(t, err)
+		for _, client := range clients {
+			xpub, err := client.Derive(7)
+			require.NoError(t, err)
+			assert.Equal(t, expected.String(), xpub)
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		keystore := c.keystorePath(&devices[1])
+		backup, err := os.ReadFile(keystore)
+		require.NoError(t, err)
+
+		require.NoError(t, runRefresh(dirFlag))
+		refreshed, err := c.load()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), refreshed[0].Epoch())
+		refreshedXPub, err := refreshed[0].XPub()
+		require.NoError(t, err)
+		assert.Equal(t, xpub.String(), refreshedXPub.String())
+
+		// A keystore left behind by the refresh is rejected.
+		current, err := os.ReadFile(keystore)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keystore, backup, 0600))
+		_, err = c.load()
+		assert.Error(t, err)
+		require.NoError(t, os.WriteFile(keystore, current, 0600))
+	})
+}
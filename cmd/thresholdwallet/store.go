@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/coinbase/kryptology/pkg/tecdsa/gg20/dealer"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+)
+
+// passwordEnv is the environment variable the keystore password is read from if no password file is given.
+const passwordEnv = "THRESHOLD_WALLET_PASSWORD"
+
+const (
+	ecdsaParamsFile    = "ecdsa.json"
+	paillierKeyLabel   = "bip32_threshold_wallet/paillier"
+	keystorePattern    = "device-*.json"
+	paillierKeyPattern = "paillier-%d.json"
+)
+
+// kdfParams are the Argon2id parameters new keystores are written with.
+var kdfParams = node.DefaultKDFParams
+
+// committee holds the flags locating the keystores of the devices.
+type committee struct {
+	dir          string
+	passwordFile string
+}
+
+func (c *committee) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.dir, "dir", "keystores", "directory of the device keystores")
+	fs.StringVar(&c.passwordFile, "password-file", "", "file containing the keystore password (default $"+passwordEnv+")")
+}
+
+func (c *committee) password() ([]byte, error) {
+	if c.passwordFile == "" {
+		password := os.Getenv(passwordEnv)
+		if password == "" {
+			return nil, errors.Errorf("no password given, use -password-file or set %s", passwordEnv)
+		}
+		return []byte(password), nil
+	}
+
+	data, err := os.ReadFile(c.passwordFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading password file")
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return nil, errors.New("password file is empty")
+	}
+	return []byte(password), nil
+}
+
+func (c *committee) keystorePath(d *node.Device) string {
+	return filepath.Join(c.dir, fmt.Sprintf("device-%d.json", d.Identifier()))
+}
+
+// load decrypts the keystores of all devices, ordered by share identifier. It fails if a keystore has been left behind
+// by a refresh, which happens if the refresh was interrupted before all keystores were written.
+func (c *committee) load() ([]node.Device, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir, keystorePattern))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.Errorf("no keystores in %s, run keygen first", c.dir)
+	}
+	password, err := c.password()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]node.Device, len(paths))
+	for i, path := range paths {
+		if devices[i], _, err = node.LoadKeystore(path, password, nil); err != nil {
+			return nil, errors.Wrapf(err, "loading %s", path)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Identifier() < devices[j].Identifier()
+	})
+	if err := node.CheckDevices(devices); err != nil {
+		return nil, errors.Wrapf(err, "loading keystores in %s", c.dir)
+	}
+	return devices, nil
+}
+
+// save writes the keystores of the given devices.
+func (c *committee) save(devices []node.Device) error {
+	password, err := c.password()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	for i := range devices {
+		if err := devices[i].SaveKeystore(c.keystorePath(&devices[i]), password, kdfParams); err != nil {
+			return errors.Wrapf(err, "saving keystore of device %d", devices[i].Identifier())
+		}
+	}
+	return nil
+}
+
+// saveECDSA writes the public threshold ECDSA parameters and the Paillier secret key of each device, encrypted with
+// the device's storage key.
+func (c *committee) saveECDSA(devices []node.Device, params *signing.ECDSAParams, decryptKeys map[uint32]*paillier.SecretKey) error {
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := node.WriteSecretFile(filepath.Join(c.dir, ecdsaParamsFile), data); err != nil {
+		return err
+	}
+
+	for i := range devices {
+		d := &devices[i]
+		plaintext, err := json.Marshal(decryptKeys[d.Identifier()])
+		if err != nil {
+			return err
+		}
+		aead, err := storageAEAD(d)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return errors.Wrap(err, "sampling nonce")
+		}
+		sealed := aead.Seal(nonce, nonce, plaintext, nil)
+		if err := node.WriteSecretFile(filepath.Join(c.dir, fmt.Sprintf(paillierKeyPattern, d.Identifier())), sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadECDSA reads the threshold ECDSA parameters and the Paillier secret keys of the given devices.
+func (c *committee) loadECDSA(devices []node.Device) (*signing.ECDSAParams, map[uint32]*paillier.SecretKey, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, ecdsaParamsFile))
+	if os.IsNotExist(err) {
+		return nil, nil, errors.New("no threshold ECDSA parameters, run keygen with -ecdsa first")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	params := &signing.ECDSAParams{}
+	if err := json.Unmarshal(data, params); err != nil {
+		return nil, nil, errors.Wrap(err, "decoding threshold ECDSA parameters")
+	}
+
+	decryptKeys := make(map[uint32]*paillier.SecretKey, len(devices))
+	for i := range devices {
+		d := &devices[i]
+		sealed, err := os.ReadFile(filepath.Join(c.dir, fmt.Sprintf(paillierKeyPattern, d.Identifier())))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading Paillier key of device %d", d.Identifier())
+		}
+		aead, err := storageAEAD(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return nil, nil, errors.Errorf("Paillier key of device %d is corrupted", d.Identifier())
+		}
+		plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, nil, errors.Errorf("Paillier key of device %d cannot be decrypted", d.Identifier())
+		}
+		sk := &paillier.SecretKey{}
+		if err := json.Unmarshal(plaintext, sk); err != nil {
+			return nil, nil, errors.Wrapf(err, "decoding Paillier key of device %d", d.Identifier())
+		}
+		decryptKeys[d.Identifier()] = sk
+	}
+	return params, decryptKeys, nil
+}
+
+// newECDSAParams samples the proof parameters and the Paillier keys of the devices, which takes a while.
+func newECDSAParams(devices []node.Device) (*signing.ECDSAParams, map[uint32]*paillier.SecretKey, error) {
+	proofParams, err := dealer.NewProofParams()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generating proof parameters")
+	}
+	params := &signing.ECDSAParams{
+		ProofParams: proofParams,
+		EncryptKeys: make(map[uint32]*paillier.PublicKey, len(devices)),
+	}
+	decryptKeys := make(map[uint32]*paillier.SecretKey, len(devices))
+	for i := range devices {
+		sk, err := signing.NewPaillierKey()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "generating Paillier key of device %d", devices[i].Identifier())
+		}
+		decryptKeys[devices[i].Identifier()] = sk
+		params.EncryptKeys[devices[i].Identifier()] = &sk.PublicKey
+	}
+	return params, decryptKeys, nil
+}
+
+func storageAEAD(d *node.Device) (cipher.AEAD, error) {
+	key, err := d.StorageKey(paillierKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Client calls the control API of a daemon.
+type Client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// NewClient creates a client of the daemon whose control API listens on the given endpoint, either "unix:<path>" or
+// a TCP address, presenting the given control token unless it is empty.
+func NewClient(control, token string) *Client {
+	if strings.HasPrefix(control, unixPrefix) {
+		path := strings.TrimPrefix(control, unixPrefix)
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		return &Client{http: &http.Client{Transport: transport}, base: "http://unix", token: token}
+	}
+	return &Client{http: &http.Client{}, base: "http://" + control, token: token}
+}
+
+// Status returns the status of the daemon.
+func (c *Client) Status() (*Status, error) {
+	var reply Status
+	return &reply, c.do(http.MethodGet, "/status", nil, &reply)
+}
+
+// Token generates a token other devices join the daemon's overlay with.
+func (c *Client) Token(expiration string) (*JoinRequest, error) {
+	var reply JoinRequest
+	return &reply, c.do(http.MethodPost, "/token", TokenRequest{Expiration: expiration}, &reply)
+}
+
+// Join lets the daemon join the overlay of the device that generated the token.
+func (c *Client) Join(req *JoinRequest) error {
+	return c.do(http.MethodPost, "/join", req, nil)
+}
+
+// Derive derives the hardened child with the given index and returns its extended public key.
+func (c *Client) Derive(index uint32) (string, error) {
+	var reply DeriveReply
+	return reply.XPub, c.do(http.MethodPost, "/derive", DeriveRequest{Index: index}, &reply)
+}
+
+// Sign signs the message with the BIP340 key of the non-hardened path.
+func (c *Client) Sign(path []uint32, msg []byte) (*SignReply, error) {
+	var reply SignReply
+	return &reply, c.do(http.MethodPost, "/sign", SignRequest{Path: path, Message: msg}, &reply)
+}
+
+// Refresh refreshes the shares of all devices and returns the new epoch.
+func (c *Client) Refresh() (uint64, error) {
+	var reply RefreshReply
+	return reply.Epoch, c.do(http.MethodPost, "/refresh", nil, &reply)
+}
+
+// DKG runs a DKG among the configured devices and returns the hex-encoded distributed public key.
+func (c *Client) DKG() (string, error) {
+	var reply DKGReply
+	return reply.PublicKey, c.do(http.MethodPost, "/dkg", nil, &reply)
+}
+
+func (c *Client) do(method, path string, body, reply interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "calling daemon")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorReply
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return errors.Errorf("daemon replied %s", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if reply == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(reply), "decoding reply")
+}
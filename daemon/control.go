@@ -0,0 +1,269 @@
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// requestTimeout bounds the protocol run for a single control request.
+const requestTimeout = time.Minute
+
+// defaultTokenExpiration is the validity of join tokens if none is requested.
+const defaultTokenExpiration = time.Hour
+
+// The requests and replies of the control API, all encoded as JSON.
+
+// Status is the reply to GET /status.
+type Status struct {
+	Identifier uint32   `json:"identifier"`
+	Threshold  uint32   `json:"threshold"`
+	Parties    uint32   `json:"parties"`
+	Epoch      uint64   `json:"epoch"`
+	Address    string   `json:"address"`
+	XPub       string   `json:"xpub"`
+	Peers      []uint32 `json:"peers"` // Identifiers of the devices found by the last protocol run.
+}
+
+// TokenRequest is the request of POST /token.
+type TokenRequest struct {
+	Expiration string `json:"expiration,omitempty"` // A duration such as "1h".
+}
+
+// JoinRequest is the reply to POST /token and the request of POST /join. It contains all a device needs to join the
+// overlay of the device that generated the token.
+type JoinRequest struct {
+	Address  string `json:"address"`
+	Token    string `json:"token"`
+	CertHash []byte `json:"certHash"`
+}
+
+// DeriveRequest is the request of POST /derive.
+type DeriveRequest struct {
+	Index uint32 `json:"index"` // Index of the hardened child without the hardened bit.
+}
+
+// DeriveReply is the reply to POST /derive.
+type DeriveReply struct {
+	XPub string `json:"xpub"`
+}
+
+// SignRequest is the request of POST /sign.
+type SignRequest struct {
+	Path    []uint32 `json:"path"` // Non-hardened path below the devices' node.
+	Message []byte   `json:"message"`
+}
+
+// SignReply is the reply to POST /sign.
+type SignReply struct {
+	Signature []byte `json:"signature"` // 64-byte BIP340 signature.
+	PublicKey []byte `json:"publicKey"` // x-only public key.
+}
+
+// DKGReply is the reply to POST /dkg.
+type DKGReply struct {
+	PublicKey string `json:"publicKey"` // Hex-encoded distributed Ed25519 public key.
+}
+
+// RefreshReply is the reply to POST /refresh.
+type RefreshReply struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+func (d *Daemon) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", get(d.handleStatus))
+	mux.HandleFunc("/token", post(d.handleToken))
+	mux.HandleFunc("/join", post(d.handleJoin))
+	mux.HandleFunc("/derive", post(d.handleDerive))
+	mux.HandleFunc("/sign", post(d.handleSign))
+	mux.HandleFunc("/refresh", post(d.handleRefresh))
+	mux.HandleFunc("/dkg", post(d.handleDKG))
+	return authorize(d.cfg.ControlToken, mux)
+}
+
+// authorize only passes requests presenting the bearer token on to the handler, if a token is configured.
+func authorize(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorReply{Error: "invalid control token"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apiHandler handles a control request, returning the reply to be encoded as JSON.
+type apiHandler func(ctx context.Context, r *http.Request) (interface{}, error)
+
+func get(h apiHandler) http.HandlerFunc {
+	return serve(http.MethodGet, h)
+}
+
+func post(h apiHandler) http.HandlerFunc {
+	return serve(http.MethodPost, h)
+}
+
+func serve(method string, h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, errorReply{Error: "method not allowed"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+
+		reply, err := h(ctx, r)
+		if err != nil {
+			log.Warnf("%s %s: %v", r.Method, r.URL.Path, err)
+			writeJSON(w, http.StatusInternalServerError, errorReply{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("writing reply: %v", err)
+	}
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(r.Body).Decode(v), "decoding request")
+}
+
+func (d *Daemon) handleStatus(context.Context, *http.Request) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	xpub, err := d.device.XPub()
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]uint32, 0, len(d.peers))
+	for id := range d.peers {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	return Status{
+		Identifier: d.device.Identifier(),
+		Threshold:  d.device.Threshold(),
+		Parties:    d.device.Parties(),
+		Epoch:      d.device.Epoch(),
+		Address:    d.Address().String(),
+		XPub:       xpub.String(),
+		Peers:      peers,
+	}, nil
+}
+
+func (d *Daemon) handleToken(_ context.Context, r *http.Request) (interface{}, error) {
+	var req TokenRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	expiration := defaultTokenExpiration
+	if req.Expiration != "" {
+		var err error
+		if expiration, err = time.ParseDuration(req.Expiration); err != nil {
+			return nil, errors.Wrap(err, "parsing expiration")
+		}
+	}
+
+	store := d.mino.GetCertificateStore()
+	hash, err := store.Hash(d.mino.GetCertificateChain())
+	if err != nil {
+		return nil, errors.Wrap(err, "hashing certificate")
+	}
+	return JoinRequest{
+		Address:  d.Address().String(),
+		Token:    d.mino.GenerateToken(expiration),
+		CertHash: hash,
+	}, nil
+}
+
+func (d *Daemon) handleJoin(_ context.Context, r *http.Request) (interface{}, error) {
+	var req JoinRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	addr, err := url.Parse("//" + req.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing address")
+	}
+	if err := d.mino.Join(addr, req.Token, req.CertHash); err != nil {
+		return nil, errors.Wrapf(err, "joining %s", req.Address)
+	}
+	return struct{}{}, nil
+}
+
+func (d *Daemon) handleDerive(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req DeriveRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	child, err := d.Derive(ctx, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	xpub, err := child.XPub()
+	if err != nil {
+		return nil, err
+	}
+	return DeriveReply{XPub: xpub.String()}, nil
+}
+
+func (d *Daemon) handleSign(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req SignRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	sig, key, err := d.Sign(ctx, req.Path, req.Message)
+	if err != nil {
+		return nil, err
+	}
+	return SignReply{Signature: sig, PublicKey: key.XOnly()}, nil
+}
+
+func (d *Daemon) handleRefresh(ctx context.Context, _ *http.Request) (interface{}, error) {
+	epoch, err := d.Refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return RefreshReply{Epoch: epoch}, nil
+}
+
+func (d *Daemon) handleDKG(ctx context.Context, _ *http.Request) (interface{}, error) {
+	pubkey, err := d.DKG(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pubkey.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding distributed public key")
+	}
+	return DKGReply{PublicKey: hex.EncodeToString(data)}, nil
+}
@@ -0,0 +1,245 @@
+// Package daemon runs a single device as a long-running process. The daemon loads the device's share from its
+// keystore, joins a minogrpc overlay with the other devices and exposes a local HTTP control API, over a Unix socket
+// or TCP, to trigger derivations, refreshes and signing sessions.
+package daemon
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/dela/mino/minogrpc"
+	"go.dedis.ch/dela/mino/router/tree"
+	"go.dedis.ch/kyber/v3"
+
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/node"
+)
+
+// rpcName is the name of the overlay RPC all devices exchange protocol messages on.
+const rpcName = "thresholdwallet"
+
+// unixPrefix marks a control endpoint as the path of a Unix socket.
+const unixPrefix = "unix:"
+
+// Config configures a daemon.
+type Config struct {
+	Keystore  string         // Path of the device's keystore, which is rewritten when the share is refreshed.
+	Password  []byte         // Password of the keystore.
+	KDFParams node.KDFParams // Argon2id parameters the keystore is rewritten with.
+	Listen    string         // TCP address the overlay listens on, e.g. "0.0.0.0:2000".
+	Public    string         // Public URL of the overlay of form //<host>:<port>, defaults to the listen address.
+	Control   string         // Control endpoint, either "unix:<path>" or a loopback TCP address such as "127.0.0.1:2100".
+
+	// ControlToken is the bearer token control requests must present. It is required for TCP control endpoints, which
+	// all local users can connect to, while the control socket is only accessible by the user running the daemon.
+	ControlToken string
+
+	// Cluster optionally describes the committee. If set, the daemon connects to the collective authority of the
+	// configured devices without joining, uses the configured certificate and address of the device holding Share, only
+	// accepts the configured devices as peers and can run a DKG among them.
+	Cluster *cluster.Config
+	Share   uint32 // Share identifier of the device in the cluster configuration.
+	TLSKey  string // PEM file of the key of the device's certificate in the cluster configuration.
+}
+
+// peer is another device of the committee as identified over the overlay.
+type peer struct {
+	addr      mino.Address
+	deviceKey kyber.Point
+	epoch     uint64
+}
+
+// Daemon runs a device and serves its control API.
+type Daemon struct {
+	cfg Config
+
+	mu       sync.Mutex // Guards the device, the peers, the roster and the sessions.
+	device   *node.Device
+	peers    map[uint32]peer
+	roster   node.Roster // Device keys protocol messages are verified against.
+	replays  *node.ReplayGuard
+	sessions map[string]*inbox // Inboxes of the sessions by their identifiers.
+
+	authority cluster.CollectiveAuthority // Configured devices, empty without a cluster configuration.
+	dkg       *node.DKG                   // DKG among the authority, nil without a cluster configuration.
+
+	mino    *minogrpc.Minogrpc
+	rpc     mino.RPC
+	control net.Listener
+	server  *http.Server
+}
+
+// New loads the device from the keystore, starts its overlay and listens on the control endpoint. The control API is
+// served once Serve is called.
+func New(cfg Config) (*Daemon, error) {
+	var opts []minogrpc.Option
+	var entry *cluster.DeviceConfig
+	var authority cluster.CollectiveAuthority
+	if cfg.Cluster != nil {
+		var err error
+		if entry, err = cfg.Cluster.Device(cfg.Share); err != nil {
+			return nil, err
+		}
+		if authority, err = cfg.Cluster.Authority(); err != nil {
+			return nil, err
+		}
+		cert, err := entry.TLSCertificate(cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, minogrpc.WithCert(cert))
+		if cfg.Listen == "" {
+			cfg.Listen = entry.Address
+		}
+		if cfg.Public == "" {
+			cfg.Public = "//" + entry.Address
+		}
+	}
+
+	listen, err := net.ResolveTCPAddr("tcp", cfg.Listen)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving listen address")
+	}
+	var public *url.URL
+	if cfg.Public != "" {
+		if public, err = url.Parse(cfg.Public); err != nil {
+			return nil, errors.Wrap(err, "parsing public URL")
+		}
+	}
+
+	m, err := minogrpc.NewMinogrpc(listen, public, tree.NewRouter(minogrpc.NewAddressFactory()), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting overlay")
+	}
+
+	device, _, err := node.LoadKeystore(cfg.Keystore, cfg.Password, m)
+	if err == nil && cfg.Cluster != nil {
+		err = checkDevice(&device, cfg.Cluster, entry)
+		if err == nil {
+			err = cfg.Cluster.StoreCertificates(m.GetCertificateStore())
+		}
+	}
+	if err != nil {
+		m.Stop()
+		return nil, err
+	}
+	if err := device.VerifyShare(); err != nil {
+		m.Stop()
+		return nil, err
+	}
+
+	roster := node.Roster{device.Identifier(): device.DeviceKey()}
+	if cfg.Cluster != nil {
+		for i := range cfg.Cluster.Devices {
+			roster[cfg.Cluster.Devices[i].Share] = cfg.Cluster.Devices[i].PublicDeviceKey()
+		}
+	}
+
+	d := &Daemon{
+		cfg:       cfg,
+		device:    &device,
+		peers:     make(map[uint32]peer),
+		sessions:  make(map[string]*inbox),
+		roster:    roster,
+		replays:   node.NewReplayGuard(replayWindow),
+		authority: authority,
+		mino:      m,
+	}
+	if d.rpc, err = m.CreateRPC(rpcName, handler{d}, messageFactory{}); err != nil {
+		m.Stop()
+		return nil, errors.Wrap(err, "creating RPC")
+	}
+	if cfg.Cluster != nil {
+		if d.dkg, err = device.ListenDKG(); err != nil {
+			m.Stop()
+			return nil, err
+		}
+	}
+
+	if d.control, err = listenControl(cfg.Control, cfg.ControlToken); err != nil {
+		m.Stop()
+		return nil, err
+	}
+	d.server = &http.Server{Handler: d.routes()}
+
+	log.Infof("device %d listening on %s, control API on %s", device.Identifier(), m.GetAddress(), d.control.Addr())
+	return d, nil
+}
+
+// Serve serves the control API until the daemon is closed.
+func (d *Daemon) Serve() error {
+	err := d.server.Serve(d.control)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close stops serving the control API and leaves the overlay.
+func (d *Daemon) Close() error {
+	err := d.server.Close()
+	if stopErr := d.mino.GracefulStop(); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// Address returns the overlay address of the daemon.
+func (d *Daemon) Address() mino.Address {
+	return d.mino.GetAddress()
+}
+
+// ControlAddr returns the address the control API listens on.
+func (d *Daemon) ControlAddr() net.Addr {
+	return d.control.Addr()
+}
+
+// checkDevice checks that the device loaded from the keystore is the configured device holding the share.
+func checkDevice(device *node.Device, c *cluster.Config, entry *cluster.DeviceConfig) error {
+	if device.Identifier() != entry.Share {
+		return errors.Errorf("keystore holds share %d, expected share %d", device.Identifier(), entry.Share)
+	}
+	if device.Threshold() != c.Threshold || device.Parties() != c.Parties {
+		return errors.Errorf("keystore is for threshold %d of %d, cluster is configured for %d of %d",
+			device.Threshold(), device.Parties(), c.Threshold, c.Parties)
+	}
+	if !device.DeviceKey().Equal(entry.PublicDeviceKey()) {
+		return errors.Errorf("device key of the keystore does not match the key configured for device %q", entry.ID)
+	}
+	return nil
+}
+
+func listenControl(control, token string) (net.Listener, error) {
+	if strings.HasPrefix(control, unixPrefix) {
+		path := strings.TrimPrefix(control, unixPrefix)
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, errors.Wrap(err, "listening on control socket")
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return nil, errors.Wrap(err, "restricting access to control socket")
+		}
+		return l, nil
+	}
+
+	host, _, err := net.SplitHostPort(control)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing control address")
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, errors.Errorf("control address %s is not a loopback address", control)
+	}
+	if token == "" {
+		return nil, errors.New("TCP control endpoints require a control token")
+	}
+	l, err := net.Listen("tcp", control)
+	return l, errors.Wrap(err, "listening on control address")
+}
@@ -0,0 +1,263 @@
+package daemon_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/daemon"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/utils"
+)
+
+var testKDFParams = node.KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+const controlToken = "control token"
+
+func TestDaemons(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	dir := t.TempDir()
+	password := []byte("password")
+
+	clients := make([]*daemon.Client, len(devices))
+	controls := make([]string, len(devices))
+	keystores := make([]string, len(devices))
+	for i := range devices {
+		keystores[i] = filepath.Join(dir, fmt.Sprintf("device-%d.json", devices[i].Identifier()))
+		require.NoError(t, devices[i].SaveKeystore(keystores[i], password, testKDFParams))
+
+		control := "127.0.0.1:0"
+		if i == 0 {
+			control = "unix:" + filepath.Join(dir, "control.sock")
+		}
+		d, err := daemon.New(daemon.Config{
+			Keystore:  keystores[i],
+			Password:  password,
+			KDFParams: testKDFParams,
+			Listen:    "127.0.0.1:0",
+			Control:   control,
+
+			ControlToken: controlToken,
+		})
+		require.NoError(t, err)
+		go d.Serve()
+		t.Cleanup(func() { d.Close() })
+
+		if i != 0 {
+			control = d.ControlAddr().String()
+		}
+		controls[i] = control
+		clients[i] = daemon.NewClient(control, controlToken)
+	}
+
+	for _, c := range clients[1:] {
+		token, err := clients[0].Token("1m")
+		require.NoError(t, err)
+		require.NoError(t, c.Join(token))
+	}
+
+	status, err := clients[2].Status()
+	require.NoError(t, err)
+	assert.Equal(t, devices[2].Identifier(), status.Identifier)
+	xpub, err := devices[0].XPub()
+	require.NoError(t, err)
+	assert.Equal(t, xpub.String(), status.XPub)
+
+	t.Run("Derive", func(t *testing.T) {
+		curve := curves.K256()
+		local := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(2, 3, curve, sha256.New(), true), true)
+		expected, err := local.DeriveSharedHardenedChild(7)
+		require.NoError(t, err)
+		expectedXPub, err := expected[0].XPub()
+		require.NoError(t, err)
+
+		for _, c := range clients {
+			xpub, err := c.Derive(7)
+			require.NoError(t, err)
+			assert.Equal(t, expectedXPub.String(), xpub)
+		}
+	})
+
+	msg := sha256.Sum256([]byte("message"))
+	sign := func(t *testing.T, c *daemon.Client) {
+		reply, err := c.Sign([]uint32{0, 1}, msg[:])
+		require.NoError(t, err)
+		key, err := signing.NewBIP340KeyForPath(&devices[0], []uint32{0, 1})
+		require.NoError(t, err)
+		assert.Equal(t, key.XOnly(), reply.PublicKey)
+		assert.True(t, signing.VerifySchnorr(reply.PublicKey, msg[:], reply.Signature))
+	}
+
+	t.Run("Sign", func(t *testing.T) {
+		sign(t, clients[1])
+	})
+
+	t.Run("DKG requires a cluster configuration", func(t *testing.T) {
+		_, err := clients[0].DKG()
+		assert.Error(t, err)
+	})
+
+	t.Run("Authenticate control requests", func(t *testing.T) {
+		info, err := os.Stat(filepath.Join(dir, "control.sock"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		for _, token := range []string{"", "other token"} {
+			_, err := daemon.NewClient(controls[1], token).Status()
+			assert.Error(t, err)
+		}
+
+		// TCP control endpoints must be loopback addresses and require a token.
+		for control, token := range map[string]string{"0.0.0.0:0": controlToken, "127.0.0.1:0": ""} {
+			_, err := daemon.New(daemon.Config{
+				Keystore:     keystores[0],
+				Password:     password,
+				Listen:       "127.0.0.1:0",
+				Control:      control,
+				ControlToken: token,
+			})
+			assert.Error(t, err, control)
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		epoch, err := clients[1].Refresh()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), epoch)
+
+		for i, c := range clients {
+			status, err := c.Status()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), status.Epoch)
+
+			refreshed, _, err := node.LoadKeystore(keystores[i], password, nil)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), refreshed.Epoch())
+			assert.Equal(t, devices[i].PublicKey(), refreshed.PublicKey())
+		}
+
+		sign(t, clients[0])
+	})
+
+	t.Run("Reject devices presenting another device key", func(t *testing.T) {
+		impostor := utils.CreateDevices(2, 3)[1]
+		keystore := filepath.Join(dir, "impostor.json")
+		require.NoError(t, impostor.SaveKeystore(keystore, password, testKDFParams))
+		d, err := daemon.New(daemon.Config{
+			Keystore:  keystore,
+			Password:  password,
+			KDFParams: testKDFParams,
+			Listen:    "127.0.0.1:0",
+			Control:   "127.0.0.1:0",
+
+			ControlToken: controlToken,
+		})
+		require.NoError(t, err)
+		go d.Serve()
+		t.Cleanup(func() { d.Close() })
+		c := daemon.NewClient(d.ControlAddr().String(), controlToken)
+
+		token, err := clients[0].Token("1m")
+		require.NoError(t, err)
+		require.NoError(t, c.Join(token))
+
+		_, err = c.Derive(7)
+		assert.Error(t, err, "the other devices have pinned the key of device 2")
+		sign(t, clients[0])
+	})
+}
+
+func TestClusterDaemons(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	dir := t.TempDir()
+	password := []byte("password")
+
+	addrs := make([]string, len(devices))
+	for i := range addrs {
+		addrs[i] = freeAddress(t)
+	}
+	path := filepath.Join(dir, "cluster.yaml")
+	c, err := cluster.WriteConfig(path, devices, addrs)
+	require.NoError(t, err)
+	c.Devices[2].Role = cluster.RoleStandby
+	require.NoError(t, c.Save(path))
+	c, err = cluster.LoadConfig(path)
+	require.NoError(t, err)
+
+	start := func(i int) *daemon.Client {
+		share := devices[i].Identifier()
+		keystore := filepath.Join(dir, fmt.Sprintf("device-%d.json", share))
+		require.NoError(t, devices[i].SaveKeystore(keystore, password, testKDFParams))
+
+		d, err := daemon.New(daemon.Config{
+			Keystore:  keystore,
+			Password:  password,
+			KDFParams: testKDFParams,
+			Control:   "127.0.0.1:0",
+			Cluster:   c,
+
+			ControlToken: controlToken,
+			Share:        share,
+			TLSKey:       filepath.Join(dir, fmt.Sprintf("tls-%d.key", share)),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, addrs[i], d.Address().String())
+		go d.Serve()
+		t.Cleanup(func() { d.Close() })
+		return daemon.NewClient(d.ControlAddr().String(), controlToken)
+	}
+
+	// Device 2 is offline, so that the standby device 3 is needed to sign.
+	clients := []*daemon.Client{start(0), start(2)}
+
+	msg := sha256.Sum256([]byte("message"))
+	reply, err := clients[0].Sign(nil, msg[:])
+	require.NoError(t, err)
+	assert.True(t, signing.VerifySchnorr(reply.PublicKey, msg[:], reply.Signature))
+
+	status, err := clients[0].Status()
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 3}, status.Peers)
+
+	_, err = clients[1].Refresh()
+	assert.Error(t, err, "refreshes require all devices")
+
+	t.Run("DKG", func(t *testing.T) {
+		clients = append(clients, start(1))
+		pubkey, err := clients[2].DKG()
+		require.NoError(t, err)
+		assert.Len(t, pubkey, 64, "hex-encoded Ed25519 point")
+	})
+
+	t.Run("Reject keystore of another device", func(t *testing.T) {
+		_, err := daemon.New(daemon.Config{
+			Keystore: filepath.Join(dir, "device-1.json"),
+			Password: password,
+			Listen:   "127.0.0.1:0",
+			Control:  "127.0.0.1:0",
+			Cluster:  c,
+			Share:    2,
+			TLSKey:   filepath.Join(dir, "tls-2.key"),
+		})
+		assert.Error(t, err)
+	})
+}
+
+// freeAddress returns a loopback address with a port that is currently free.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
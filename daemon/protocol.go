@@ -0,0 +1,686 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyler-smith/go-bip32"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/dela/mino/minogrpc/certs"
+	"go.dedis.ch/dela/serde"
+	"go.dedis.ch/kyber/v3"
+
+	"bip32_threshold_wallet/cluster"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/tvrf"
+)
+
+// The operations devices request from each other over the overlay. The requesting device coordinates the protocol.
+// Identify and evaluate requests are answered directly, so that the coordinating device can derive from the replies of
+// any t devices. Sign and refresh requests start a session among the participants, which exchange the messages of its
+// rounds with each other as requests of the round operation, so that the coordinating device never learns the nonces
+// or shares of the other devices.
+const (
+	opIdentify     = "identify"      // Return the share identifier, the device key and the epoch.
+	opEvaluate     = "evaluate"      // Return a partial TVRF evaluation for a hardened child index.
+	opSign         = "sign"          // Run a FROST session among the signers and return the signature.
+	opSignRound    = "sign-round"    // Deliver a message of a FROST session.
+	opRefresh      = "refresh"       // Run a refresh session among all devices and return the new epoch.
+	opRefreshRound = "refresh-round" // Deliver a message of a refresh session.
+)
+
+// replayWindow is the maximum age of accepted messages, which bounds the clock skew tolerated between devices.
+const replayWindow = 5 * time.Minute
+
+// message is the envelope of all requests and replies exchanged over the overlay. Each message is signed with the
+// device key of its sender and carries the session identifier chosen by the coordinating device, so that messages
+// cannot be forged by other overlay participants, replayed or moved between sessions.
+type message struct {
+	*node.SignedMessage
+}
+
+// Serialize implements serde.Message.
+func (m message) Serialize(serde.Context) ([]byte, error) {
+	return json.Marshal(m.SignedMessage)
+}
+
+type messageFactory struct{}
+
+// Deserialize implements serde.Factory.
+func (messageFactory) Deserialize(_ serde.Context, data []byte) (serde.Message, error) {
+	var m node.SignedMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "decoding message")
+	}
+	return message{&m}, nil
+}
+
+// identity is the body of identify requests and replies. Since the device key of the sender may not be known yet,
+// the message is verified against the key it carries, which is then checked against the roster.
+type identity struct {
+	Identifier uint32 `json:"identifier"`
+	DeviceKey  []byte `json:"deviceKey"`
+	Epoch      uint64 `json:"epoch"`
+}
+
+// The evaluate and sign requests carry the epoch of the coordinating device, so that a device in another epoch rejects
+// them instead of using a share the other devices no longer hold.
+type evaluateRequest struct {
+	Epoch uint64 `json:"epoch"`
+	Index uint32 `json:"index"`
+}
+
+type signRequest struct {
+	Epoch   uint64   `json:"epoch"`
+	Path    []uint32 `json:"path"`
+	Message []byte   `json:"message"`
+	Signers []uint32 `json:"signers"`
+}
+
+type signReply struct {
+	Signature []byte `json:"signature"`
+}
+
+type refreshRequest struct {
+	Epoch        uint64   `json:"epoch"`
+	Participants []uint32 `json:"participants"`
+}
+
+type refreshReply struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+// handler processes the requests of other devices.
+type handler struct {
+	d *Daemon
+}
+
+// Process implements mino.Handler.
+func (h handler) Process(req mino.Request) (serde.Message, error) {
+	m, ok := req.Message.(message)
+	if !ok {
+		return nil, errors.Errorf("unexpected message of type %T", req.Message)
+	}
+	if err := h.d.authenticate(m); err != nil {
+		return nil, errors.Wrapf(err, "rejecting message of %s", req.Address)
+	}
+	log.Debugf("processing %s request of device %d", m.Op, m.Sender)
+
+	var reply interface{}
+	var err error
+	switch m.Op {
+	case opIdentify:
+		reply, err = h.d.identify()
+	case opEvaluate:
+		var body evaluateRequest
+		if err = json.Unmarshal(m.Body, &body); err == nil {
+			reply, err = h.d.evaluate(body)
+		}
+	case opSign:
+		var body signRequest
+		if err = json.Unmarshal(m.Body, &body); err == nil {
+			reply, err = h.d.sign(m.Session, body)
+		}
+	case opRefresh:
+		var body refreshRequest
+		if err = json.Unmarshal(m.Body, &body); err == nil {
+			reply, err = h.d.refresh(m.Session, body)
+		}
+	case opSignRound, opRefreshRound:
+		reply, err = h.d.deliver(m)
+	default:
+		return nil, errors.Errorf("unknown operation %q", m.Op)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, m.Op)
+	}
+	return h.d.seal(m.Op, m.Session, m.Sender, reply)
+}
+
+// Stream implements mino.Handler. The devices do not use streams.
+func (h handler) Stream(mino.Sender, mino.Receiver) error {
+	return errors.New("streams are not supported")
+}
+
+// seal encodes the body and signs it as a message of the session for the device holding the share with the given
+// identifier, or for all devices if it is node.Broadcast.
+func (d *Daemon) seal(op string, session []byte, recipient uint32, body interface{}) (message, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return message{}, errors.Wrapf(err, "encoding %s message", op)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m, err := d.device.SignMessage(op, session, recipient, data)
+	if err != nil {
+		return message{}, err
+	}
+	return message{m}, nil
+}
+
+// authenticate verifies the message against the roster, checks that it is addressed to the device and rejects
+// replayed messages. Only identify messages, whose sender does not know the identifiers of the devices yet, and the
+// messages of sessions may be addressed to all devices. The device keys of identify messages are checked against the roster, and added to
+// it if the device is unknown, first.
+func (d *Daemon) authenticate(m message) error {
+	if m.SignedMessage == nil {
+		return errors.New("empty message")
+	}
+	if m.Op == opIdentify {
+		if err := d.learn(m); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	err := d.roster.Verify(m.SignedMessage)
+	self := d.device.Identifier()
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	broadcast := m.Op == opIdentify || m.Op == opSignRound || m.Op == opRefreshRound
+	if m.Recipient != self && (m.Recipient != node.Broadcast || !broadcast) {
+		return errors.Errorf("%s message of device %d is addressed to device %d", m.Op, m.Sender, m.Recipient)
+	}
+	return d.replays.Check(m.SignedMessage, time.Now())
+}
+
+// learn checks the device key presented in an identify message. With a cluster configuration, the roster holds the
+// configured keys of all devices. Otherwise, the key a device presents first is trusted, relying on the certificates
+// exchanged when joining the overlay, and pinned for the lifetime of the daemon.
+func (d *Daemon) learn(m message) error {
+	var id identity
+	if err := json.Unmarshal(m.Body, &id); err != nil {
+		return errors.Wrap(err, "decoding identity")
+	}
+	if id.Identifier != m.Sender {
+		return errors.Errorf("identity of device %d is sent by device %d", id.Identifier, m.Sender)
+	}
+	key, err := node.UnmarshalDeviceKey(id.DeviceKey)
+	if err != nil {
+		return err
+	}
+	if err := m.VerifyWith(key); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if known, ok := d.roster[id.Identifier]; ok {
+		if !known.Equal(key) {
+			return errors.Errorf("device %d presents another device key than known", id.Identifier)
+		}
+		return nil
+	}
+	if d.cfg.Cluster != nil || id.Identifier == 0 || id.Identifier > d.device.Parties() {
+		return errors.Errorf("device %d is not part of the committee", id.Identifier)
+	}
+	d.roster[id.Identifier] = key
+	log.Infof("added device key of device %d to the roster", id.Identifier)
+	return nil
+}
+
+func (d *Daemon) identify() (*identity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key, err := d.device.DeviceKey().MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding device key")
+	}
+	return &identity{
+		Identifier: d.device.Identifier(),
+		DeviceKey:  key,
+		Epoch:      d.device.Epoch(),
+	}, nil
+}
+
+func (d *Daemon) evaluate(req evaluateRequest) (json.RawMessage, error) {
+	if req.Index >= bip32.FirstHardenedChild {
+		return nil, errors.Errorf("invalid child index %d", req.Index)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.checkEpoch(req.Epoch); err != nil {
+		return nil, err
+	}
+	t := d.tvrf()
+	eval, err := derivation.PartialEvaluation(curves.K256(), t, d.device, req.Index)
+	if err != nil {
+		return nil, err
+	}
+	return t.MarshalPartialEvaluation(eval)
+}
+
+// sign runs the FROST session of the coordinating device among the signers and returns the signature.
+func (d *Daemon) sign(session []byte, req signRequest) (*signReply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var p *signing.FrostProtocol
+	err := d.run(ctx, opSignRound, session, req.Signers, func(node.Roster) (node.Protocol, error) {
+		if err := d.checkEpoch(req.Epoch); err != nil {
+			return nil, err
+		}
+		key, err := signing.NewBIP340KeyForPath(d.device, req.Path)
+		if err != nil {
+			return nil, err
+		}
+		p, err = signing.NewFrostProtocol(d.device, key, req.Message)
+		return p, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &signReply{Signature: p.Signature()}, nil
+}
+
+// refresh runs the refresh session of the coordinating device among all devices and persists the refreshed share.
+func (d *Daemon) refresh(session []byte, req refreshRequest) (*refreshReply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := d.run(ctx, opRefreshRound, session, req.Participants, func(roster node.Roster) (node.Protocol, error) {
+		if err := d.checkEpoch(req.Epoch); err != nil {
+			return nil, err
+		}
+		return node.NewRefreshProtocol(d.device, req.Participants, roster), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.device.SaveKeystore(d.cfg.Keystore, d.cfg.Password, d.cfg.KDFParams); err != nil {
+		return nil, errors.Wrap(err, "persisting refreshed share")
+	}
+	log.Infof("device %d refreshed its share to epoch %d", d.device.Identifier(), d.device.Epoch())
+	return &refreshReply{Epoch: d.device.Epoch()}, nil
+}
+
+// checkEpoch checks that the device is in the epoch of the coordinating device. The caller must hold the lock.
+func (d *Daemon) checkEpoch(epoch uint64) error {
+	if epoch != d.device.Epoch() {
+		return errors.Errorf("request is for epoch %d, device is in epoch %d", epoch, d.device.Epoch())
+	}
+	return nil
+}
+
+// tvrf returns the TVRF hardened children are derived with.
+func (d *Daemon) tvrf() *tvrf.DDHTVRF {
+	return tvrf.NewDDHTVRF(d.device.Threshold(), d.device.Parties(), curves.K256(), sha256.New(), true)
+}
+
+// call sends the request of the session to the devices with the given identifiers and returns their authenticated
+// replies in the same order. It fails if any device fails to reply.
+func (d *Daemon) call(ctx context.Context, session []byte, op string, body interface{}, peers map[uint32]peer, ids []uint32) ([]*node.SignedMessage, error) {
+	addrs := addresses(peers, ids)
+	replies, errs, err := d.gather(ctx, session, op, body, addrs, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s of %s", op, addrs[i])
+		}
+		if replies[i].Sender != ids[i] {
+			return nil, errors.Errorf("%s reply of %s is sent by device %d instead of device %d", op, addrs[i], replies[i].Sender, ids[i])
+		}
+	}
+	return replies, nil
+}
+
+// gather sends the request of the session to all given devices, signed for the device holding the share with the
+// corresponding identifier or for all devices if it is node.Broadcast, and returns their authenticated replies and
+// errors in the same order.
+func (d *Daemon) gather(ctx context.Context, session []byte, op string, body interface{}, addrs []mino.Address, recipients []uint32) ([]*node.SignedMessage, []error, error) {
+	type result struct {
+		i     int
+		reply message
+		err   error
+	}
+	results := make(chan result, len(addrs))
+	for i := range addrs {
+		req, err := d.seal(op, session, recipients[i], body)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func(i int, req message) {
+			reply, err := d.request(ctx, session, op, req, addrs[i])
+			results <- result{i: i, reply: reply, err: err}
+		}(i, req)
+	}
+
+	replies := make([]*node.SignedMessage, len(addrs))
+	errs := make([]error, len(addrs))
+	for range addrs {
+		r := <-results
+		if errs[r.i] = r.err; r.err == nil {
+			replies[r.i] = r.reply.SignedMessage
+		}
+	}
+	return replies, errs, nil
+}
+
+// request sends the request to a single device and returns its authenticated reply.
+func (d *Daemon) request(ctx context.Context, session []byte, op string, req message, addr mino.Address) (message, error) {
+	resps, err := d.rpc.Call(ctx, req, mino.NewAddresses(addr))
+	if err != nil {
+		return message{}, errors.Wrapf(err, "calling %s", op)
+	}
+	var resp mino.Response
+	select {
+	case resp = <-resps:
+	case <-ctx.Done():
+		return message{}, errors.Wrapf(ctx.Err(), "waiting for %s reply", op)
+	}
+	if resp == nil {
+		return message{}, errors.New("no reply")
+	}
+	msg, err := resp.GetMessageOrError()
+	if err != nil {
+		return message{}, err
+	}
+	return d.checkReply(msg, session, op)
+}
+
+// checkReply authenticates a reply and checks that it belongs to the request of the session.
+func (d *Daemon) checkReply(msg serde.Message, session []byte, op string) (message, error) {
+	reply, ok := msg.(message)
+	if !ok {
+		return message{}, errors.Errorf("unexpected reply of type %T", msg)
+	}
+	if err := d.authenticate(reply); err != nil {
+		return message{}, errors.Wrap(err, "rejecting reply")
+	}
+	if reply.Op != op || !bytes.Equal(reply.Session, session) {
+		return message{}, errors.Errorf("reply of device %d is not a %s reply of the session", reply.Sender, op)
+	}
+	return reply, nil
+}
+
+// discover identifies the reachable devices, including the daemon's own device. These are the configured devices if
+// the daemon runs with a cluster configuration and the devices whose certificates are known to the overlay otherwise.
+func (d *Daemon) discover(ctx context.Context, session []byte) (map[uint32]peer, error) {
+	var addrs []mino.Address
+	if d.cfg.Cluster != nil {
+		for iter := d.authority.AddressIterator(); iter.HasNext(); {
+			addrs = append(addrs, iter.GetNext())
+		}
+	} else {
+		err := d.mino.GetCertificateStore().Range(func(addr mino.Address, _ certs.CertChain) bool {
+			addrs = append(addrs, addr)
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "listing devices")
+		}
+	}
+
+	own, err := d.identify()
+	if err != nil {
+		return nil, err
+	}
+	// The identifiers of the devices are not known yet, so that the identify requests are addressed to all devices.
+	replies, errs, err := d.gather(ctx, session, opIdentify, own, addrs, make([]uint32, len(addrs)))
+	if err != nil {
+		return nil, err
+	}
+	peers := make(map[uint32]peer, len(addrs))
+	for i, m := range replies {
+		if errs[i] != nil {
+			log.Debugf("device %s is unreachable: %v", addrs[i], errs[i])
+			continue
+		}
+		var reply identity
+		if err := json.Unmarshal(m.Body, &reply); err != nil {
+			return nil, errors.Wrapf(err, "decoding identity of %s", addrs[i])
+		}
+		if _, ok := peers[reply.Identifier]; ok {
+			return nil, errors.Errorf("devices %s and %s have the same identifier %d", peers[reply.Identifier].addr, addrs[i], reply.Identifier)
+		}
+		key, err := node.UnmarshalDeviceKey(reply.DeviceKey)
+		if err != nil {
+			return nil, err
+		}
+		if d.cfg.Cluster != nil {
+			entry, err := d.cfg.Cluster.Device(reply.Identifier)
+			if err != nil || !entry.MinoAddress().Equal(addrs[i]) || !entry.PublicDeviceKey().Equal(key) {
+				return nil, errors.Errorf("device %s does not match the cluster configuration", addrs[i])
+			}
+		}
+		peers[reply.Identifier] = peer{addr: addrs[i], deviceKey: key, epoch: reply.Epoch}
+	}
+
+	d.mu.Lock()
+	d.peers = peers
+	d.mu.Unlock()
+	return peers, nil
+}
+
+// signers selects t devices to run a protocol with, starting with the daemon's own device and preferring devices with
+// the signer role over standby devices.
+func (d *Daemon) signers(peers map[uint32]peer) ([]uint32, error) {
+	ids, err := d.ranked(peers)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ids[:d.device.Threshold()], nil
+}
+
+// ranked orders the reachable devices in the order they are selected to run a protocol with, see signers, and fails if
+// fewer than t devices are reachable. Devices left behind by a refresh are skipped, and ranked fails if the daemon's own
+// device has been left behind, as its share is no longer consistent with the shares of the other devices.
+func (d *Daemon) ranked(peers map[uint32]peer) ([]uint32, error) {
+	d.mu.Lock()
+	own, t, epoch := d.device.Identifier(), d.device.Threshold(), d.device.Epoch()
+	d.mu.Unlock()
+
+	ids := []uint32{own}
+	for id, p := range peers {
+		switch {
+		case id == own:
+		case p.epoch > epoch:
+			return nil, errors.Errorf("device is in epoch %d, behind device %d in epoch %d", epoch, id, p.epoch)
+		case p.epoch < epoch:
+			log.Warnf("skipping device %d left behind in epoch %d", id, p.epoch)
+		default:
+			ids = append(ids, id)
+		}
+	}
+	if uint32(len(ids)) < t {
+		return nil, errors.Errorf("%d devices are reachable, %d are required", len(ids), t)
+	}
+	others := ids[1:]
+	sort.Slice(others, func(i, j int) bool {
+		if si, sj := d.isStandby(others[i]), d.isStandby(others[j]); si != sj {
+			return sj
+		}
+		return others[i] < others[j]
+	})
+	return ids, nil
+}
+
+// isStandby returns whether the device holding the share has the standby role.
+func (d *Daemon) isStandby(share uint32) bool {
+	if d.cfg.Cluster == nil {
+		return false
+	}
+	entry, err := d.cfg.Cluster.Device(share)
+	return err == nil && entry.Role == cluster.RoleStandby
+}
+
+func addresses(peers map[uint32]peer, ids []uint32) []mino.Address {
+	addrs := make([]mino.Address, len(ids))
+	for i, id := range ids {
+		addrs[i] = peers[id].addr
+	}
+	return addrs
+}
+
+// Derive derives the daemon's share of the hardened child with the given index, without the hardened bit, of the
+// devices' node from the partial TVRF evaluations of the devices, like derivation.TVRFDerivation's
+// DeriveSharedHardenedChild. All reachable devices are asked for their evaluations, so that t valid ones suffice even if
+// some devices fail to reply or reply with invalid evaluations. The evaluations are requested directly rather than in a
+// session, which would wait for the messages of all participants.
+func (d *Daemon) Derive(ctx context.Context, index uint32) (node.Device, error) {
+	if index >= bip32.FirstHardenedChild {
+		return node.Device{}, errors.Errorf("invalid child index %d", index)
+	}
+	session, err := node.NewSessionID()
+	if err != nil {
+		return node.Device{}, err
+	}
+	peers, err := d.discover(ctx, session)
+	if err != nil {
+		return node.Device{}, err
+	}
+	ids, err := d.ranked(peers)
+	if err != nil {
+		return node.Device{}, err
+	}
+	d.mu.Lock()
+	epoch := d.device.Epoch()
+	d.mu.Unlock()
+	replies, errs, err := d.gather(ctx, session, opEvaluate, evaluateRequest{Epoch: epoch, Index: index}, addresses(peers, ids), ids)
+	if err != nil {
+		return node.Device{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.tvrf()
+	var evals []*tvrf.PartialEvaluation
+	for i, m := range replies {
+		if errs[i] == nil && m.Sender != ids[i] {
+			errs[i] = errors.Errorf("reply is sent by device %d", m.Sender)
+		}
+		var eval *tvrf.PartialEvaluation
+		if errs[i] == nil {
+			eval, errs[i] = d.partialEvaluation(t, index, ids[i], m.Body)
+		}
+		if errs[i] != nil {
+			log.Debugf("dropping evaluation of device %d: %v", ids[i], errs[i])
+			continue
+		}
+		evals = append(evals, eval)
+	}
+	if threshold := d.device.Threshold(); uint32(len(evals)) < threshold {
+		return node.Device{}, errors.Errorf("received %d of %d valid partial evaluations", len(evals), threshold)
+	}
+	return derivation.CombineSharedHardenedChild(t, d.device, index, evals)
+}
+
+// partialEvaluation decodes the partial evaluation of the device holding the share with the given identifier and
+// verifies it. The evaluation is verified against the public key share committed to by the sharing rather than the one
+// claimed by the device. The caller must hold the lock.
+func (d *Daemon) partialEvaluation(t *tvrf.DDHTVRF, index, id uint32, body []byte) (*tvrf.PartialEvaluation, error) {
+	eval, err := t.UnmarshalPartialEvaluation(derivation.TVRFMessage(index), body)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := d.device.PublicKeyShareOf(id)
+	if err != nil {
+		return nil, err
+	}
+	point, err := curves.K256().Point.Set(pk.X, pk.Y)
+	if err != nil {
+		return nil, errors.Wrapf(err, "converting public key share of device %d", id)
+	}
+	eval.PubKeyShare = tvrf.PublicKeyShare{Idx: id, Value: &point}
+	if !t.VerifyPartialEval(eval) {
+		return nil, errors.Errorf("invalid partial evaluation of device %d", id)
+	}
+	return eval, nil
+}
+
+// Sign signs the message with FROST using the BIP340 key of the non-hardened path below the devices' node. It selects t
+// signers, which run FROST in a session with each other, and returns the 64-byte signature and the signing key.
+func (d *Daemon) Sign(ctx context.Context, path []uint32, msg []byte) ([]byte, *signing.SchnorrKey, error) {
+	d.mu.Lock()
+	key, err := signing.NewBIP340KeyForPath(d.device, path)
+	epoch := d.device.Epoch()
+	d.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := node.NewSessionID()
+	if err != nil {
+		return nil, nil, err
+	}
+	peers, err := d.discover(ctx, session)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := d.signers(peers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replies, err := d.call(ctx, session, opSign, signRequest{Epoch: epoch, Path: path, Message: msg, Signers: ids}, peers, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Every signer aggregates the signature, the daemon's own device being the first.
+	var reply signReply
+	if err := json.Unmarshal(replies[0].Body, &reply); err != nil {
+		return nil, nil, errors.Wrap(err, "decoding signature")
+	}
+	if !signing.VerifySchnorr(key.XOnly(), msg, reply.Signature) {
+		return nil, nil, errors.New("signature is invalid")
+	}
+	return reply.Signature, key, nil
+}
+
+// Refresh runs one epoch of the proactive refresh among all devices and returns the new epoch. All n devices must be
+// reachable and run the refresh in a session with each other. If a device fails to apply the deals, the devices end up
+// in different epochs and the refresh must be repeated by the devices left behind, e.g. by restoring their keystores.
+func (d *Daemon) Refresh(ctx context.Context) (uint64, error) {
+	session, err := node.NewSessionID()
+	if err != nil {
+		return 0, err
+	}
+	peers, err := d.discover(ctx, session)
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	epoch, n := d.device.Epoch(), d.device.Parties()
+	d.mu.Unlock()
+	if uint32(len(peers)) != n {
+		return 0, errors.Errorf("%d of %d devices are reachable", len(peers), n)
+	}
+	ids := make([]uint32, 0, len(peers))
+	for id, p := range peers {
+		if p.epoch != epoch {
+			return 0, errors.Errorf("device %d is in epoch %d, expected %d", id, p.epoch, epoch)
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if _, err := d.call(ctx, session, opRefresh, refreshRequest{Epoch: epoch, Participants: ids}, peers, ids); err != nil {
+		return 0, err
+	}
+	return epoch + 1, nil
+}
+
+// DKG runs dela's Pedersen DKG among the collective authority of the configured devices with the configured threshold
+// and returns the distributed public key. All configured devices must be reachable.
+func (d *Daemon) DKG(ctx context.Context) (kyber.Point, error) {
+	if d.dkg == nil {
+		return nil, errors.New("a DKG requires a cluster configuration")
+	}
+	return d.dkg.Setup(ctx, d.authority, int(d.cfg.Cluster.Threshold))
+}
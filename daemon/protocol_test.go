@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestAuthenticate(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	roster := node.Roster{}
+	for i := range devices {
+		roster[devices[i].Identifier()] = devices[i].DeviceKey()
+	}
+	daemons := make([]*Daemon, len(devices))
+	for i := range devices {
+		daemons[i] = &Daemon{device: &devices[i], roster: roster, replays: node.NewReplayGuard(replayWindow)}
+	}
+	session, err := node.NewSessionID()
+	require.NoError(t, err)
+
+	// A request of device 1 for device 2 must not be accepted when replayed to device 3.
+	req, err := daemons[0].seal(opEvaluate, session, devices[1].Identifier(), evaluateRequest{Index: 1})
+	require.NoError(t, err)
+	assert.Error(t, daemons[2].authenticate(req))
+	assert.NoError(t, daemons[1].authenticate(req))
+
+	broadcast, err := daemons[0].seal(opEvaluate, session, node.Broadcast, evaluateRequest{Index: 1})
+	require.NoError(t, err)
+	assert.Error(t, daemons[1].authenticate(broadcast), "evaluate requests must not be addressed to all devices")
+
+	round, err := daemons[0].seal(opRefreshRound, session, node.Broadcast, nil)
+	require.NoError(t, err)
+	assert.NoError(t, daemons[1].authenticate(round), "messages of sessions may be addressed to all devices")
+}
+
+func TestInbox(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	d := &Daemon{device: &devices[0], sessions: make(map[string]*inbox)}
+	sessions := make([][]byte, maxSessions)
+	for i := range sessions {
+		var err error
+		sessions[i], err = node.NewSessionID()
+		require.NoError(t, err)
+	}
+
+	// Messages arriving before the session is run are kept for it.
+	m, err := devices[1].SignRoundMessage(opSignRound, sessions[0], 0, node.Broadcast, nil)
+	require.NoError(t, err)
+	_, err = d.deliver(message{m})
+	require.NoError(t, err)
+	messages, err := d.open(sessions[0])
+	require.NoError(t, err)
+	assert.Equal(t, m, <-messages)
+	_, err = d.open(sessions[0])
+	assert.Error(t, err, "a session must not be run twice")
+
+	// The inboxes of sessions are capped, and inboxes of sessions that are not run expire.
+	for _, session := range sessions[1:] {
+		m, err := devices[1].SignRoundMessage(opSignRound, session, 0, node.Broadcast, nil)
+		require.NoError(t, err)
+		_, err = d.deliver(message{m})
+		require.NoError(t, err)
+	}
+	extra, err := node.NewSessionID()
+	require.NoError(t, err)
+	_, err = d.open(extra)
+	assert.Error(t, err)
+	for _, in := range d.sessions {
+		in.created = time.Now().Add(-sessionTimeout - time.Second)
+	}
+	_, err = d.open(extra)
+	require.NoError(t, err)
+	assert.Len(t, d.sessions, 2, "only running sessions are kept")
+
+	d.close(sessions[0])
+	d.close(extra)
+	assert.Empty(t, d.sessions)
+}
+
+func TestPartialEvaluation(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	daemons := make([]*Daemon, len(devices))
+	for i := range devices {
+		daemons[i] = &Daemon{device: &devices[i]}
+	}
+	body, err := daemons[1].evaluate(evaluateRequest{Index: 1})
+	require.NoError(t, err)
+	_, err = daemons[1].evaluate(evaluateRequest{Epoch: 1, Index: 1})
+	assert.Error(t, err, "requests of other epochs must be rejected")
+
+	tvrf := daemons[0].tvrf()
+	_, err = daemons[0].partialEvaluation(tvrf, 1, devices[1].Identifier(), body)
+	assert.NoError(t, err)
+	_, err = daemons[0].partialEvaluation(tvrf, 2, devices[1].Identifier(), body)
+	assert.Error(t, err, "evaluations of other children must be rejected")
+	_, err = daemons[0].partialEvaluation(tvrf, 1, devices[2].Identifier(), body)
+	assert.Error(t, err, "evaluations must be verified against the share of the device")
+}
+
+func TestRanked(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	require.NoError(t, node.RefreshDevices(devices[:2]))
+	d := &Daemon{device: &devices[0]}
+
+	// Devices left behind by a refresh are skipped.
+	peers := map[uint32]peer{1: {epoch: 1}, 2: {epoch: 1}, 3: {epoch: 0}}
+	ids, err := d.ranked(peers)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, ids)
+
+	// A device left behind itself refuses to run protocols.
+	d = &Daemon{device: &devices[2]}
+	_, err = d.ranked(peers)
+	assert.Error(t, err)
+}
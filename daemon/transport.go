@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bip32_threshold_wallet/node"
+)
+
+// A device holds the inboxes of at most maxSessions sessions, each with at most inboxSize undelivered messages, so that
+// other devices cannot exhaust its memory by sending messages of sessions it never runs. Inboxes of sessions that are
+// not run within sessionTimeout are dropped.
+const (
+	maxSessions    = 16
+	inboxSize      = 64
+	sessionTimeout = requestTimeout
+)
+
+// roundTimeout bounds a round of a session.
+const roundTimeout = 30 * time.Second
+
+// inbox holds the messages delivered to a session of the device.
+type inbox struct {
+	messages chan *node.SignedMessage
+	created  time.Time
+	running  bool
+}
+
+// transport is the node.Transport of a session of the daemon over the overlay. The messages of the session are sent as
+// requests of the session's operation, whose replies acknowledge their delivery, and the handler delivers the messages
+// of other devices to the inbox of the session.
+type transport struct {
+	ctx     context.Context
+	d       *Daemon
+	session []byte
+	peers   map[uint32]peer
+	inbox   chan *node.SignedMessage
+}
+
+// Send implements node.Transport.
+func (t *transport) Send(to uint32, m *node.SignedMessage) error {
+	p, ok := t.peers[to]
+	if !ok {
+		return errors.Errorf("device %d is not reachable", to)
+	}
+	reply, err := t.d.request(t.ctx, t.session, m.Op, message{m}, p.addr)
+	if err != nil {
+		return err
+	}
+	if reply.Sender != to {
+		return errors.Errorf("delivery of device %d is acknowledged by device %d", to, reply.Sender)
+	}
+	return nil
+}
+
+// Receive implements node.Transport.
+func (t *transport) Receive() <-chan *node.SignedMessage {
+	return t.inbox
+}
+
+// run runs a protocol in the session with the participants, which all run it concurrently when asked to by the
+// coordinating device. The device keys and addresses of the participants are looked up by the device itself, so that
+// the coordinating device cannot substitute them. The protocol is created for the device keys with the lock held and
+// holds the lock whenever it uses the device.
+func (d *Daemon) run(ctx context.Context, op string, session []byte, participants []uint32, protocol func(node.Roster) (node.Protocol, error)) error {
+	discovery, err := node.NewSessionID()
+	if err != nil {
+		return err
+	}
+	peers, err := d.discover(ctx, discovery)
+	if err != nil {
+		return err
+	}
+	roster := make(node.Roster, len(participants))
+	for _, id := range participants {
+		p, ok := peers[id]
+		if !ok {
+			return errors.Errorf("device %d is not reachable", id)
+		}
+		roster[id] = p.deviceKey
+	}
+
+	messages, err := d.open(session)
+	if err != nil {
+		return err
+	}
+	defer d.close(session)
+
+	// The session signs its messages with a copy of the device, as the protocol may refresh the share concurrently.
+	d.mu.Lock()
+	signer := *d.device
+	p, err := protocol(roster)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s, err := node.NewSession(&signer, &transport{ctx: ctx, d: d, session: session, peers: peers, inbox: messages}, node.SessionConfig{
+		Op:           op,
+		ID:           session,
+		Participants: participants,
+		Roster:       roster,
+		Timeout:      roundTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx, lockedProtocol{d: d, p: p})
+}
+
+// deliver delivers a message of another device, which the handler has authenticated, to the inbox of its session.
+func (d *Daemon) deliver(m message) (struct{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	in, err := d.inbox(m.Session)
+	if err != nil {
+		return struct{}{}, err
+	}
+	select {
+	case in.messages <- m.SignedMessage:
+		return struct{}{}, nil
+	default:
+		return struct{}{}, errors.Errorf("inbox of %s session is full", m.Op)
+	}
+}
+
+// open marks the inbox of the session as running, creating it unless messages of other devices have arrived first.
+func (d *Daemon) open(session []byte) (chan *node.SignedMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	in, err := d.inbox(session)
+	if err != nil {
+		return nil, err
+	}
+	if in.running {
+		return nil, errors.New("session is already running")
+	}
+	in.running = true
+	return in.messages, nil
+}
+
+func (d *Daemon) close(session []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.sessions, string(session))
+}
+
+// inbox returns the inbox of the session, creating it if needed. The caller must hold the lock.
+func (d *Daemon) inbox(session []byte) (*inbox, error) {
+	if in, ok := d.sessions[string(session)]; ok {
+		return in, nil
+	}
+	now := time.Now()
+	for id, in := range d.sessions {
+		if !in.running && now.Sub(in.created) > sessionTimeout {
+			log.Debugf("dropping inbox of session %x that has not been run", id)
+			delete(d.sessions, id)
+		}
+	}
+	if len(d.sessions) >= maxSessions {
+		return nil, errors.Errorf("device holds the inboxes of %d sessions", len(d.sessions))
+	}
+	in := &inbox{messages: make(chan *node.SignedMessage, inboxSize), created: now}
+	d.sessions[string(session)] = in
+	return in, nil
+}
+
+// lockedProtocol holds the daemon's lock while the protocol uses the device.
+type lockedProtocol struct {
+	d *Daemon
+	p node.Protocol
+}
+
+// Start implements node.Protocol.
+func (l lockedProtocol) Start() ([]node.Outgoing, error) {
+	l.d.mu.Lock()
+	defer l.d.mu.Unlock()
+	return l.p.Start()
+}
+
+// Advance implements node.Protocol.
+func (l lockedProtocol) Advance(round uint32, received map[uint32][]byte) ([]node.Outgoing, bool, error) {
+	l.d.mu.Lock()
+	defer l.d.mu.Unlock()
+	return l.p.Advance(round, received)
+}
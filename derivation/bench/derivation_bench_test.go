@@ -12,6 +12,7 @@ import (
 	"golang.org/x/crypto/sha3"
 
 	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/simnet"
 	"bip32_threshold_wallet/tvrf"
 	"bip32_threshold_wallet/utils"
 )
@@ -26,8 +27,23 @@ var (
 	// Number of children to derive per benchmark evaluation.
 	numChildren = 1
 
-	// Simulated network latency
-	netLatency = 10 * time.Millisecond
+	// Simulated network between the devices, which send their evaluations to the first device. The seed determines
+	// the latencies and losses of the messages.
+	link = simnet.Link{
+		Latency: simnet.Constant(10 * time.Millisecond),
+	}
+	seed = int64(1)
+
+	// Links of the WAN benchmark, e.g. devices spread over several continents.
+	wanLink = simnet.Link{
+		Latency:   simnet.LogNormal{Median: 80 * time.Millisecond, Sigma: 0.4},
+		Jitter:    10 * time.Millisecond,
+		Loss:      0.01,
+		Bandwidth: 1 << 20,
+	}
+
+	// Time the combining device waits for t evaluations.
+	timeout = 10 * time.Second
 )
 
 type thresholdParam struct {
@@ -49,14 +65,12 @@ func BenchmarkMultipleTVRFDerivations(b *testing.B) {
 	log.Infof("Reuse key-pair: %t, optimized TVRF: %t", reuseKeyPair, optimizedTvrfCombination)
 	log.Infof("Number of CPUs available: %d", runtime.NumCPU())
 
-	if netLatency.Milliseconds() > 0 {
-		log.Infof("Simulated network latency: %s", netLatency)
-	}
+	log.Infof("Simulated network: %+v, seed %d", link, seed)
 
 	for _, param := range benchmarkParams {
 		runName := fmt.Sprintf("Run t=%d, n=%d", param.t, param.n)
 		b.Run(runName, func(b *testing.B) {
-			benchmarkTVRFDerivation(b, param.t, param.n)
+			benchmarkTVRFDerivation(b, param.t, param.n, link)
 		})
 
 		// Calculate the total bandwidth used for the derivation which results from all parties sending their evaluation
@@ -66,6 +80,18 @@ func BenchmarkMultipleTVRFDerivations(b *testing.B) {
 	}
 }
 
+func BenchmarkWANTVRFDerivations(b *testing.B) {
+	log.Info("------------------- BENCHMARK TVRF HARDENED NODE DERIVATION OVER A WAN --------------------")
+	log.Infof("Simulated network: %+v, seed %d", wanLink, seed)
+
+	for _, param := range benchmarkParams {
+		runName := fmt.Sprintf("Run t=%d, n=%d", param.t, param.n)
+		b.Run(runName, func(b *testing.B) {
+			benchmarkTVRFDerivation(b, param.t, param.n, wanLink)
+		})
+	}
+}
+
 func BenchmarkStandardBIP32Derivation(b *testing.B) {
 	log.Info("------------------- BENCHMARK STANDARD BIP32 DERIVATION --------------------")
 
@@ -85,11 +111,11 @@ func BenchmarkStandardBIP32Derivation(b *testing.B) {
 	})
 }
 
-func benchmarkTVRFDerivation(b *testing.B, t, n uint32) {
+func benchmarkTVRFDerivation(b *testing.B, t, n uint32, link simnet.Link) {
 	devices := utils.CreateDevices(t, n)
 	ddhTvrf := tvrf.NewDDHTVRF(t, n, curve, sha256, optimizedTvrfCombination)
 	deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, reuseKeyPair)
-	deriv.SetNetworkLatency(netLatency)
+	deriv.SetNetwork(simnet.New(seed, link), timeout)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -21,6 +21,18 @@ type ThresholdDerivation interface {
 	DeriveHardenedChild(childIdx uint32) (*node.Node, error)
 }
 
+// SharedHardenedDerivation derives hardened children that remain shared among the devices, so that several hardened
+// levels can be derived one after the other without reconstructing any key.
+type SharedHardenedDerivation interface {
+	ThresholdDerivation
+
+	// DeriveSharedHardenedChild derives the shares of a hardened child of the current node on each of the devices.
+	DeriveSharedHardenedChild(childIdx uint32) ([]node.Device, error)
+
+	// WithDevices returns the derivation of the children of the node shared among the given devices.
+	WithDevices(devices []node.Device) SharedHardenedDerivation
+}
+
 type StandardDerivation interface {
 	// DeriveNonHardenedChild derives a non-hardened child from the current key.
 	DeriveNonHardenedChild(childIdx uint32) (*bip32.Key, error)
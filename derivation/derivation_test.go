@@ -2,13 +2,20 @@ package derivation_test
 
 import (
 	sha2562 "crypto/sha256"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/coinbase/kryptology/pkg/core/curves"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip32"
 
 	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/simnet"
 	"bip32_threshold_wallet/tvrf"
 	"bip32_threshold_wallet/utils"
 )
@@ -40,4 +47,246 @@ func TestNewTVRFDerivation(t *testing.T) {
 	require.NotNil(t, childNode2)
 
 	assert.Falsef(t, (*childNode1.PublicKey).Equal(*childNode2.PublicKey), "Public keys should be different")
+
+	xprv, err := childNode1.XPrv()
+	require.NoError(t, err)
+	parsed, err := bip32.B58Deserialize(xprv.String())
+	require.NoError(t, err)
+	assert.Equal(t, uint8(1), parsed.Depth)
+	assert.Equal(t, []byte{0x80, 0, 0, 1}, parsed.ChildNumber)
+
+	xpub, err := childNode1.XPub()
+	require.NoError(t, err)
+	assert.Equal(t, parsed.PublicKey().String(), xpub.String())
+}
+
+func TestNetworkDerivation(t *testing.T) {
+	devices := utils.CreateDevices(threshold, numParties)
+	ddhTvrf := tvrf.NewDDHTVRF(threshold, numParties, curve, sha256, true)
+	local := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+	expected, err := local.DeriveHardenedChild(1)
+	require.NoError(t, err)
+
+	// A lossy WAN, in which the first device combines the first t evaluations to arrive.
+	network := simnet.New(1, simnet.Link{
+		Latency: simnet.Normal{Mean: 20 * time.Millisecond, StdDev: 5 * time.Millisecond},
+		Jitter:  5 * time.Millisecond,
+		Loss:    0.2,
+	})
+	deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+	deriv.SetNetwork(network, time.Second)
+	for i := 0; i < 3; i++ {
+		child, err := deriv.DeriveHardenedChild(1)
+		require.NoError(t, err)
+		assert.Equal(t, xpub(t, expected), xpub(t, child))
+	}
+
+	t.Run("Invalid evaluations", func(t *testing.T) {
+		// One device sends a validly signed evaluation of another child, which the first device must not combine, and
+		// another one fails to send its evaluation.
+		network := faultyNetwork{
+			Network: simnet.New(1, simnet.Link{}),
+			tvrf:    ddhTvrf,
+			corrupt: map[uint32]*node.Device{devices[1].Identifier(): &devices[1]},
+			failing: map[uint32]bool{devices[2].Identifier(): true},
+		}
+		deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+		deriv.SetNetwork(network, time.Second)
+		child, err := deriv.DeriveHardenedChild(1)
+		require.NoError(t, err)
+		assert.Equal(t, xpub(t, expected), xpub(t, child))
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		network.Partition([]uint32{devices[0].Identifier()})
+		deriv.SetNetwork(network, 100*time.Millisecond)
+		_, err := deriv.DeriveHardenedChild(1)
+		assert.Error(t, err)
+	})
+}
+
+// faultyNetwork replaces the partial evaluations sent by the corrupt devices with their evaluations of another child
+// and fails to send the evaluations of the failing devices.
+type faultyNetwork struct {
+	derivation.Network
+	tvrf    *tvrf.DDHTVRF
+	corrupt map[uint32]*node.Device
+	failing map[uint32]bool
+}
+
+func (n faultyNetwork) Transport(id uint32) node.Transport {
+	transport := n.Network.Transport(id)
+	if d, ok := n.corrupt[id]; ok {
+		return corruptingTransport{Transport: transport, tvrf: n.tvrf, device: d}
+	}
+	if n.failing[id] {
+		return failingTransport{transport}
+	}
+	return transport
+}
+
+type failingTransport struct {
+	node.Transport
+}
+
+func (failingTransport) Send(uint32, *node.SignedMessage) error {
+	return errors.New("connection refused")
+}
+
+type corruptingTransport struct {
+	node.Transport
+	tvrf   *tvrf.DDHTVRF
+	device *node.Device
+}
+
+func (t corruptingTransport) Send(to uint32, m *node.SignedMessage) error {
+	eval, err := derivation.PartialEvaluation(curve, t.tvrf, t.device, 1000)
+	if err != nil {
+		return err
+	}
+	body, err := t.tvrf.MarshalPartialEvaluation(eval)
+	if err != nil {
+		return err
+	}
+	if m, err = t.device.SignMessage(m.Op, m.Session, m.Recipient, body); err != nil {
+		return err
+	}
+	return t.Transport.Send(to, m)
+}
+
+func TestSharedHardenedDerivation(t *testing.T) {
+	devices := utils.CreateDevices(threshold, numParties)
+	ddhTvrf := tvrf.NewDDHTVRF(threshold, numParties, curve, sha256, true)
+	deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+
+	children, err := deriv.DeriveSharedHardenedChild(1)
+	require.NoError(t, err)
+	require.Len(t, children, len(devices))
+	again, err := deriv.DeriveSharedHardenedChild(1)
+	require.NoError(t, err)
+	assert.True(t, (*children[0].PublicKey()).Equal(*again[0].PublicKey()))
+	assert.False(t, (*children[0].PublicKey()).Equal(*devices[0].PublicKey()))
+
+	// Any t devices reconstruct the child's key, which no single device holds.
+	key, err := node.ExportXPrv(children[numParties-threshold:])
+	require.NoError(t, err)
+	assert.Equal(t, (*children[0].PublicKey()).ToAffineCompressed(), key.PublicKey().Key)
+	assert.Equal(t, uint8(1), key.Depth)
+	assert.Equal(t, []byte{0x80, 0, 0, 1}, key.ChildNumber)
+
+	// Hardened levels are derived one after the other by the devices.
+	grandchildren, err := deriv.WithDevices(children).DeriveSharedHardenedChild(0)
+	require.NoError(t, err)
+	xpub, err := grandchildren[0].XPub()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(2), xpub.Depth)
+
+	// Refreshing keeps the children, while the shares held before the refresh can no longer derive them.
+	stale := make([]node.Device, len(devices))
+	copy(stale, devices)
+	require.NoError(t, node.RefreshDevices(devices))
+	_, err = deriv.WithDevices(stale).DeriveSharedHardenedChild(1)
+	assert.ErrorIs(t, err, node.ErrRetiredShare)
+	refreshed, err := deriv.WithDevices(devices).DeriveSharedHardenedChild(1)
+	require.NoError(t, err)
+	assert.True(t, (*refreshed[0].PublicKey()).Equal(*children[0].PublicKey()))
+}
+
+// The tweak of a hardened child is only known to parties that combined t partial evaluations, which together with the
+// secret key of the child reveals the secret key of the parent.
+func TestHardenedTweak(t *testing.T) {
+	devices := utils.CreateDevices(threshold, numParties)
+	ddhTvrf := tvrf.NewDDHTVRF(threshold, numParties, curve, sha256, true)
+	deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+	children, err := deriv.DeriveSharedHardenedChild(1)
+	require.NoError(t, err)
+
+	evals := make([]*tvrf.PartialEvaluation, threshold)
+	for i := range evals {
+		evals[i], err = derivation.PartialEvaluation(curve, ddhTvrf, &devices[i], 1)
+		require.NoError(t, err)
+	}
+	_, err = derivation.CombineEvaluations(ddhTvrf, evals[:threshold-1])
+	assert.Error(t, err, "fewer than t partial evaluations do not reveal the tweak")
+	combined, err := derivation.CombineEvaluations(ddhTvrf, evals)
+	require.NoError(t, err)
+
+	parent, err := node.ReconstructSecretKey(devices[:threshold])
+	require.NoError(t, err)
+	child, err := node.ReconstructSecretKey(children[:threshold])
+	require.NoError(t, err)
+	tweak, err := curve.Scalar.SetBigInt(derivation.HardenedTweak(combined).BigInt())
+	require.NoError(t, err)
+	assert.Equal(t, (*parent).Bytes(), (*child).Sub(tweak).Bytes())
+}
+
+func TestNonHardDerivation(t *testing.T) {
+	devices := utils.CreateDevices(threshold, numParties)
+	ddhTvrf := tvrf.NewDDHTVRF(threshold, numParties, curve, sha256, true)
+	deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+
+	children, err := deriv.DeriveNonHardenedChild(7)
+	require.NoError(t, err)
+	require.Len(t, children, len(devices))
+
+	// The shared child must match the standard BIP32 derivation of the reconstructed master key.
+	masterKey, err := node.ExportXPrv(devices)
+	require.NoError(t, err)
+	expected, err := masterKey.NewChildKey(7)
+	require.NoError(t, err)
+
+	childKey, err := node.ReconstructSecretKey(children[:threshold])
+	require.NoError(t, err)
+	assert.Equal(t, expected.Key, (*childKey).Bytes())
+	assert.Equal(t, expected.PublicKey().Key, (*children[0].PublicKey()).ToAffineCompressed())
+
+	_, err = deriv.DeriveNonHardenedChild(bip32.FirstHardenedChild)
+	assert.Error(t, err)
+}
+
+// The secret key of a hardened child is derived from the combined evaluation with SHA-512, so that the child of a
+// fixed sharing is fixed as well.
+func TestHardenedDerivationVector(t *testing.T) {
+	devices := fixedDevices(t)
+	ddhTvrf := tvrf.NewDDHTVRF(2, 3, curve, sha256, true)
+	deriv := derivation.NewTVRFDerivation(curve, devices, ddhTvrf, true)
+
+	child, err := deriv.DeriveHardenedChild(1)
+	require.NoError(t, err)
+	xprv, err := child.XPrv()
+	require.NoError(t, err)
+	assert.Equal(t, "xprv9um5JzoqcwCT5bV94Ghj15ABEjVoWNSZaRb8nDnwK29bggnMjNos4SosXNGY1Me4J5nVGtZjs1Nu7dUBz5eWJwLGggExNbkNzQrFciqfcry", xprv.String())
+}
+
+// fixedDevices creates the devices of a (2, 3) sharing of the secret key 1 along the polynomial 1 + 2x.
+func fixedDevices(t *testing.T) []node.Device {
+	k256, err := curve.ToEllipticCurve()
+	require.NoError(t, err)
+	field := curves.NewField(k256.Params().N)
+	commitments := make([]*curves.EcPoint, 2)
+	for i, coefficient := range []int64{1, 2} {
+		commitments[i], err = curves.NewScalarBaseMult(k256, big.NewInt(coefficient))
+		require.NoError(t, err)
+	}
+	pk, err := curve.Point.Set(commitments[0].X, commitments[0].Y)
+	require.NoError(t, err)
+	chainCode, err := node.NewMasterChainCode()
+	require.NoError(t, err)
+
+	devices := make([]node.Device, 3)
+	for i := range devices {
+		value := big.NewInt(int64(1 + 2*(i+1)))
+		pkShare, err := curves.NewScalarBaseMult(k256, value)
+		require.NoError(t, err)
+		share := &v1.ShamirShare{Identifier: uint32(i + 1), Value: field.NewElement(value)}
+		devices[i], _ = node.NewDevice(i, 2, 3, pkShare, share, &pk, commitments, 0, chainCode, nil)
+		require.NoError(t, devices[i].VerifyShare())
+	}
+	return devices
+}
+
+func xpub(t *testing.T, n *node.Node) string {
+	key, err := n.XPub()
+	require.NoError(t, err)
+	return key.String()
 }
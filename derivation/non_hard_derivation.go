@@ -10,7 +10,36 @@ type NonHardDerivation struct {
 	devices []node.Device
 }
 
+// NewNonHardDerivation creates a derivation of non-hardened children of the node shared among the given devices.
+func NewNonHardDerivation(devices []node.Device) NonHardDerivation {
+	return NonHardDerivation{devices: devices}
+}
+
+// DeriveNonHardenedChild derives the shares of the non-hardened child on each of the devices.
+// Since the tweak only depends on public information, the devices do not need to interact.
 func (nhd NonHardDerivation) DeriveNonHardenedChild(childIdx uint32) ([]node.Device, error) {
-	// Execute DPSS between nd.devices and the new devices
-	return nil, errors.New("not implemented")
+	if len(nhd.devices) == 0 {
+		return nil, errors.New("no devices to derive from")
+	}
+	if err := node.CheckDevices(nhd.devices); err != nil {
+		return nil, err
+	}
+
+	children := make([]node.Device, len(nhd.devices))
+	for i := range nhd.devices {
+		child, err := nhd.devices[i].DeriveNonHardenedChild(childIdx)
+		if err != nil {
+			return nil, err
+		}
+		children[i] = child
+	}
+
+	pk := children[0].PublicKey()
+	for _, child := range children[1:] {
+		if !(*child.PublicKey()).Equal(*pk) {
+			return nil, errors.New("devices derived different child public keys")
+		}
+	}
+
+	return children, nil
 }
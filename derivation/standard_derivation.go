@@ -26,6 +26,16 @@ func NewStandardBIP32Derivation() (error, *StandardBIP32Derivation) {
 	return nil, &StandardBIP32Derivation{masterKey: key}
 }
 
+// NewStandardBIP32DerivationFromKey creates a standard derivation from an existing extended private key, e.g. one
+// exported from a threshold setup by node.ExportXPrv.
+func NewStandardBIP32DerivationFromKey(key *bip32.Key) (error, *StandardBIP32Derivation) {
+	if key == nil || !key.IsPrivate {
+		return errors.New("extended private key required"), nil
+	}
+
+	return nil, &StandardBIP32Derivation{masterKey: key}
+}
+
 func (s *StandardBIP32Derivation) DeriveNonHardenedChild(childIdx uint32) (*bip32.Key, error) {
 	if childIdx >= bip32.FirstHardenedChild {
 		return nil, errors.New("invalid child index for non-hardened derivation")
@@ -1,12 +1,14 @@
 package derivation
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/binary"
-	"math/rand"
 	"runtime"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/coinbase/kryptology/pkg/core/curves"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -15,6 +17,9 @@ import (
 	"bip32_threshold_wallet/tvrf"
 )
 
+// evaluationOp is the operation partial evaluations are signed for when sent over a network.
+const evaluationOp = "evaluate"
+
 type TVRFDerivation struct {
 	curve   *curves.Curve
 	devices []node.Device
@@ -25,6 +30,17 @@ type TVRFDerivation struct {
 	// netLatency is used to simulate network latency in the derivation process when parties send their evaluations
 	// to the child node.
 	netLatency time.Duration
+
+	// network, if set, carries the evaluations of the devices to the first device, which combines them.
+	network Network
+	timeout time.Duration
+	roster  node.Roster
+}
+
+// Network connects the devices of a derivation, e.g. a node.MemoryNetwork or a simulated simnet.Network.
+type Network interface {
+	// Transport returns the transport of the device holding the share with the given identifier.
+	Transport(id uint32) node.Transport
 }
 
 // NewTVRFDerivation creates a new TVRF derivation instance.
@@ -46,54 +62,278 @@ func (td *TVRFDerivation) SetNetworkLatency(netLatency time.Duration) {
 	td.netLatency = netLatency
 }
 
+// SetNetwork sends the partial evaluations of hardened derivations over the network instead of simulating a fixed
+// network latency. The first device combines the evaluations as soon as t valid ones, including its own, have arrived
+// and fails if they do not arrive within the timeout. Invalid evaluations are dropped while waiting for the others.
+func (td *TVRFDerivation) SetNetwork(network Network, timeout time.Duration) {
+	td.network = network
+	td.timeout = timeout
+	td.roster = make(node.Roster, len(td.devices))
+	for i := range td.devices {
+		td.roster[td.devices[i].Identifier()] = td.devices[i].DeviceKey()
+	}
+}
+
 func (td *TVRFDerivation) DeriveNonHardenedChild(childIdx uint32) ([]node.Device, error) {
 	nonHardDerivation := NonHardDerivation{devices: td.devices}
 	return nonHardDerivation.DeriveNonHardenedChild(childIdx)
 }
 
 func (td *TVRFDerivation) DeriveHardenedChild(childIdx uint32) (*node.Node, error) {
-	childIdxBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(childIdxBytes, childIdx)
+	combinedEval, err := td.evaluate(childIdx)
+	if err != nil {
+		return nil, err
+	}
+	return hardenedChild(td.curve, &td.devices[0], childIdx, combinedEval)
+}
+
+// DeriveSharedHardenedChild derives the shares of the hardened child with the given index, without the hardened bit,
+// on each of the devices. The devices shift their shares by a tweak derived from the combined TVRF evaluation, see
+// node.Device.DeriveHardenedChild, so that the child's secret key is never reconstructed. The first device, which
+// combines the evaluations, and every device deriving the child learn the tweak.
+func (td *TVRFDerivation) DeriveSharedHardenedChild(childIdx uint32) ([]node.Device, error) {
+	if len(td.devices) == 0 {
+		return nil, errors.New("no devices to derive from")
+	}
+	combinedEval, err := td.evaluate(childIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]node.Device, len(td.devices))
+	for i := range td.devices {
+		if children[i], err = sharedHardenedChild(&td.devices[i], childIdx, combinedEval); err != nil {
+			return nil, err
+		}
+	}
+	return children, nil
+}
+
+// WithDevices returns the derivation of the children of the node shared among the given devices, e.g. of a child
+// obtained with DeriveSharedHardenedChild, using the same TVRF and network.
+func (td *TVRFDerivation) WithDevices(devices []node.Device) SharedHardenedDerivation {
+	child := *td
+	child.devices = devices
+	return &child
+}
+
+// evaluate evaluates the TVRF on the child index with all devices and returns the verified combined evaluation.
+func (td *TVRFDerivation) evaluate(childIdx uint32) (*tvrf.Evaluation, error) {
+	if err := node.CheckDevices(td.devices); err != nil {
+		return nil, err
+	}
+	if td.network != nil {
+		return td.evaluateOverNetwork(childIdx)
+	}
+	childIdxBytes := TVRFMessage(childIdx)
 
 	log.Trace("evaluating TVRF for all devices")
 	evals, err := td.parallelTVRFEval(childIdxBytes)
+	if err != nil {
+		return nil, err
+	}
 
 	// Simulate network latency, where all parties would send their evaluations in parallel to the child node.
 	time.Sleep(td.netLatency)
 
+	return CombineEvaluations(td.tvrf, evals)
+}
+
+// evaluateOverNetwork lets all devices send their partial evaluations as signed messages to the first device. The
+// evaluations are collected directly rather than in a node.Session, which would wait for the messages of all devices
+// instead of the first t valid ones.
+func (td *TVRFDerivation) evaluateOverNetwork(childIdx uint32) (*tvrf.Evaluation, error) {
+	codec, ok := td.tvrf.(evaluationCodec)
+	if !ok {
+		return nil, errors.Errorf("partial evaluations of %T cannot be sent over the network", td.tvrf)
+	}
+	session, err := node.NewSessionID()
+	if err != nil {
+		return nil, err
+	}
+	combiner := &td.devices[0]
+	inbox := td.network.Transport(combiner.Identifier())
+
+	log.Trace("evaluating TVRF for all devices")
+	errs := make(chan error, len(td.devices))
+	for i := range td.devices[1:] {
+		go func(d *node.Device) {
+			errs <- td.sendEvaluation(codec, d, childIdx, session, combiner.Identifier())
+		}(&td.devices[i+1])
+	}
+	own, err := PartialEvaluation(td.curve, td.tvrf, combiner, childIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	t := int(combiner.Threshold())
+	evals := []*tvrf.PartialEvaluation{own}
+	received := map[uint32]bool{combiner.Identifier(): true}
+	timeout := time.NewTimer(td.timeout)
+	defer timeout.Stop()
+	for len(evals) < t {
+		select {
+		case err := <-errs:
+			// A device failing to send its evaluation is like an evaluation lost on the network.
+			if err != nil {
+				log.Debugf("device failed to send its evaluation: %v", err)
+			}
+		case m := <-inbox.Receive():
+			eval, err := td.receiveEvaluation(codec, combiner, childIdx, session, m)
+			if err != nil {
+				log.Debugf("dropping evaluation: %v", err)
+				continue
+			}
+			if !received[m.Sender] {
+				received[m.Sender] = true
+				evals = append(evals, eval)
+			}
+		case <-timeout.C:
+			return nil, errors.Errorf("received %d of %d valid partial evaluations in time", len(evals), t)
+		}
+	}
+
+	return CombineEvaluations(td.tvrf, evals)
+}
+
+// evaluationCodec encodes partial evaluations to be sent over the network.
+type evaluationCodec interface {
+	MarshalPartialEvaluation(eval *tvrf.PartialEvaluation) ([]byte, error)
+	UnmarshalPartialEvaluation(m tvrf.Message, data []byte) (*tvrf.PartialEvaluation, error)
+}
+
+func (td *TVRFDerivation) sendEvaluation(codec evaluationCodec, d *node.Device, childIdx uint32, session []byte, to uint32) error {
+	eval, err := PartialEvaluation(td.curve, td.tvrf, d, childIdx)
+	if err != nil {
+		return err
+	}
+	body, err := codec.MarshalPartialEvaluation(eval)
+	if err != nil {
+		return err
+	}
+	m, err := d.SignMessage(evaluationOp, session, to, body)
+	if err != nil {
+		return err
+	}
+	return td.network.Transport(d.Identifier()).Send(to, m)
+}
+
+// receiveEvaluation verifies a message sent by sendEvaluation and decodes its partial evaluation. The evaluation is
+// verified against the public key share of the sender committed to by the sharing.
+func (td *TVRFDerivation) receiveEvaluation(codec evaluationCodec, combiner *node.Device, childIdx uint32, session []byte, m *node.SignedMessage) (*tvrf.PartialEvaluation, error) {
+	if m.Op != evaluationOp || !bytes.Equal(m.Session, session) {
+		return nil, errors.New("message of another derivation")
+	}
+	if m.Recipient != combiner.Identifier() {
+		return nil, errors.Errorf("evaluation of device %d is addressed to device %d", m.Sender, m.Recipient)
+	}
+	if err := td.roster.Verify(m); err != nil {
+		return nil, err
+	}
+	eval, err := codec.UnmarshalPartialEvaluation(TVRFMessage(childIdx), m.Body)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := combiner.PublicKeyShareOf(m.Sender)
+	if err != nil {
+		return nil, err
+	}
+	point, err := td.curve.Point.Set(pk.X, pk.Y)
+	if err != nil {
+		return nil, errors.Wrapf(err, "converting public key share of device %d", m.Sender)
+	}
+	eval.PubKeyShare = tvrf.PublicKeyShare{Idx: m.Sender, Value: &point}
+	if !td.tvrf.VerifyPartialEval(eval) {
+		return nil, errors.Errorf("invalid partial evaluation of device %d", m.Sender)
+	}
+	return eval, nil
+}
+
+// PartialEvaluation computes the device's partial TVRF evaluation for the hardened child with the given index, which
+// is sent to the party combining the evaluations with CombineSharedHardenedChild.
+func PartialEvaluation(curve *curves.Curve, t tvrf.TVRF, d *node.Device, childIdx uint32) (*tvrf.PartialEvaluation, error) {
+	return partialEvaluation(curve, t, d, TVRFMessage(childIdx))
+}
+
+// CombineSharedHardenedChild combines at least t partial evaluations for the hardened child with the given index and
+// derives the device's share of the child like DeriveSharedHardenedChild.
+func CombineSharedHardenedChild(t tvrf.TVRF, d *node.Device, childIdx uint32, evals []*tvrf.PartialEvaluation) (node.Device, error) {
+	combinedEval, err := CombineEvaluations(t, evals)
+	if err != nil {
+		return node.Device{}, err
+	}
+	return sharedHardenedChild(d, childIdx, combinedEval)
+}
+
+// CombineEvaluations combines at least t partial evaluations and verifies the combined evaluation.
+func CombineEvaluations(t tvrf.TVRF, evals []*tvrf.PartialEvaluation) (*tvrf.Evaluation, error) {
 	log.Trace("combining evaluations")
-	combinedEval, err := td.tvrf.Combine(evals)
+	combinedEval, err := t.Combine(evals)
 	if err != nil {
 		return nil, errors.Wrap(err, "combining evaluations")
 	}
 	log.Tracef("combined evaluation: %x", combinedEval.Eval.ToAffineCompressed())
 
 	log.Trace("verifying combined evaluation")
-	valid := td.tvrf.Verify(*combinedEval)
+	valid := t.Verify(*combinedEval)
 	if !valid {
 		return nil, errors.New("verification of combined evaluation failed")
 	}
+	return combinedEval, nil
+}
 
+// sharedHardenedChild derives the device's share of the hardened child from the combined evaluation.
+func sharedHardenedChild(d *node.Device, childIdx uint32, combinedEval *tvrf.Evaluation) (node.Device, error) {
+	return d.DeriveHardenedChild(childIdx, HardenedTweak(combinedEval), genChainCode(combinedEval))
+}
+
+// hardenedChild derives the key pair and the chain code of the hardened child from the combined evaluation.
+func hardenedChild(curve *curves.Curve, d *node.Device, childIdx uint32, combinedEval *tvrf.Evaluation) (*node.Node, error) {
 	log.Trace("generating ECDSA key pair for child node")
-	sk, pk := td.genECDSAKeyPair(combinedEval)
-	child := node.NewNode(childIdx, nil, sk, pk)
+	sk, pk, err := genECDSAKeyPair(curve, combinedEval)
+	if err != nil {
+		return nil, err
+	}
+	chainCode := genChainCode(combinedEval)
+	child, err := d.HardenedChild(childIdx, chainCode, sk, pk)
+	if err != nil {
+		return nil, err
+	}
 
 	return &child, nil
 }
 
+// TVRFMessage encodes the child index as the message the TVRF is evaluated on for the hardened child.
+func TVRFMessage(childIdx uint32) []byte {
+	childIdxBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(childIdxBytes, childIdx)
+	return childIdxBytes
+}
+
+func partialEvaluation(curve *curves.Curve, t tvrf.TVRF, d *node.Device, msg []byte) (*tvrf.PartialEvaluation, error) {
+	if err := d.CheckShare(); err != nil {
+		return nil, err
+	}
+	dSk, dPk := d.KeyPair()
+	err, sk, pk := tvrf.ShamirShareToKeyPair(curve, dSk, dPk)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting key pairs")
+	}
+
+	eval, err := t.PEval(msg, sk, *pk)
+	if err != nil {
+		return nil, errors.Wrap(err, "evaluation failed")
+	}
+	return eval, nil
+}
+
 func (td *TVRFDerivation) sequentialTVRFEval(childIdxBytes []byte) ([]*tvrf.PartialEvaluation, error) {
 	evals := make([]*tvrf.PartialEvaluation, len(td.devices))
 
-	for i, d := range td.devices {
-		dSk, dPk := d.KeyPair()
-		err, sk, pk := tvrf.ShamirShareToKeyPair(td.curve, dSk, dPk)
-		if err != nil {
-			return nil, errors.Wrap(err, "converting key pairs")
-		}
-
-		eval, err := td.tvrf.PEval(childIdxBytes, sk, *pk)
+	for i := range td.devices {
+		eval, err := partialEvaluation(td.curve, td.tvrf, &td.devices[i], childIdxBytes)
 		if err != nil {
-			return nil, errors.Wrap(err, "evaluation failed")
+			return nil, err
 		}
 		evals[i] = eval
 	}
@@ -111,16 +351,9 @@ func (td *TVRFDerivation) parallelTVRFEval(childIdxBytes []byte) ([]*tvrf.Partia
 	for i := 0; i < numCPU; i++ {
 		go func() {
 			for d := range devicesChan {
-				dSk, dPk := d.KeyPair()
-				err, sk, pk := tvrf.ShamirShareToKeyPair(td.curve, dSk, dPk)
+				eval, err := partialEvaluation(td.curve, td.tvrf, &d, childIdxBytes)
 				if err != nil {
-					errorsChan <- errors.Wrap(err, "converting key pairs")
-					return
-				}
-
-				eval, err := td.tvrf.PEval(childIdxBytes, sk, *pk)
-				if err != nil {
-					errorsChan <- errors.Wrap(err, "evaluation failed")
+					errorsChan <- err
 					return
 				}
 				evalsChan <- eval
@@ -147,16 +380,36 @@ func (td *TVRFDerivation) parallelTVRFEval(childIdxBytes []byte) ([]*tvrf.Partia
 
 }
 
-func (td *TVRFDerivation) genECDSAKeyPair(combinedEval *tvrf.Evaluation) (*curves.Scalar, *curves.Point) {
+// genChainCode derives the chain code of the hardened child from the combined evaluation, domain separated from the
+// randomness of the key pair.
+func genChainCode(combinedEval *tvrf.Evaluation) []byte {
+	seed := combinedEval.Eval.ToAffineUncompressed()
+	chainCode := sha256.Sum256(append([]byte("BIP32 threshold wallet chain code"), seed...))
+	return chainCode[:]
+}
+
+// genECDSAKeyPair derives the key pair of the hardened child from the combined evaluation. The secret key is a wide
+// hash of the evaluation reduced modulo the group order, which is uniformly distributed up to a negligible bias.
+func genECDSAKeyPair(curve *curves.Curve, combinedEval *tvrf.Evaluation) (*curves.Scalar, *curves.Point, error) {
 	seed := combinedEval.Eval.ToAffineUncompressed()
-	// TODO: More secure way of getting the randomness seed from the evaluation than this?
-	hash := sha256.Sum256(seed)
-	seedInt := binary.BigEndian.Uint64(hash[:8])
-	src := rand.NewSource(int64(seedInt))
-	rng := rand.New(src)
+	hash := sha512.Sum512(append([]byte("BIP32 threshold wallet secret key"), seed...))
+	sk, err := curve.Scalar.SetBytesWide(hash[:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "deriving secret key")
+	}
+	if sk.IsZero() {
+		return nil, nil, node.ErrInvalidChild
+	}
+	pk := curve.ScalarBaseMult(sk)
 
-	sk := td.curve.Scalar.Random(rng)
-	pk := td.curve.ScalarBaseMult(sk)
+	return &sk, &pk, nil
+}
 
-	return &sk, &pk
+// HardenedTweak derives the tweak of the shares of a hardened child derived with DeriveSharedHardenedChild from the
+// combined evaluation, domain separated from the chain code and the secret key of DeriveHardenedChild. Whoever knows
+// the combined evaluation and the secret key of the child can compute the secret key of the parent.
+func HardenedTweak(combinedEval *tvrf.Evaluation) *curves.Element {
+	seed := combinedEval.Eval.ToAffineUncompressed()
+	hash := sha512.Sum512(append([]byte("BIP32 threshold wallet hardened tweak"), seed...))
+	return curves.NewField(btcec.S256().N).ReducedElementFromBytes(hash[:])
 }
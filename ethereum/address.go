@@ -0,0 +1,107 @@
+package ethereum
+
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+	"golang.org/x/crypto/sha3"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/wallet"
+)
+
+// CoinType is the SLIP-44 coin type of Ether.
+const CoinType = 60
+
+// DerivationPath returns the path m/44'/60'/0'/0/index of the account with the given index, as used by common wallets.
+func DerivationPath(index uint32) []uint32 {
+	return []uint32{
+		bip32.FirstHardenedChild + 44,
+		bip32.FirstHardenedChild + CoinType,
+		bip32.FirstHardenedChild,
+		0,
+		index,
+	}
+}
+
+// AccountAddress returns the address of the given index on the external chain of a BIP44 account of a wallet created
+// for CoinType, i.e. of the key at m/44'/60'/account'/0/index.
+func AccountAddress(a *wallet.Account, index uint32) (Address, error) {
+	if a.Purpose != wallet.BIP44 || a.CoinType != CoinType {
+		return Address{}, errors.Errorf("account %s is no Ethereum account", a.Path())
+	}
+	pk, err := a.PublicKey(wallet.ExternalChain, index)
+	if err != nil {
+		return Address{}, err
+	}
+	return PublicKeyToAddress(pk)
+}
+
+// Address is an Ethereum account address, the last 20 bytes of the Keccak-256 hash of the uncompressed public key.
+type Address [20]byte
+
+// PublicKeyToAddress computes the address of the public key.
+func PublicKeyToAddress(pk node.PublicKey) (Address, error) {
+	if pk == nil || (*pk).IsIdentity() {
+		return Address{}, errors.New("invalid public key")
+	}
+	var addr Address
+	copy(addr[:], keccak256((*pk).ToAffineUncompressed()[1:])[12:])
+	return addr, nil
+}
+
+// ParseAddress parses a hex encoded address. If it contains both lower and upper case letters, its EIP-55 checksum is
+// verified, while all lower and all upper case addresses carry no checksum.
+func ParseAddress(s string) (Address, error) {
+	if len(s) == 42 && (s[:2] == "0x" || s[:2] == "0X") {
+		s = s[2:]
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 20 {
+		return Address{}, errors.Errorf("invalid address %q", s)
+	}
+
+	var addr Address
+	copy(addr[:], b)
+	hasLower, hasUpper := false, false
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'f':
+			hasLower = true
+		case c >= 'A' && c <= 'F':
+			hasUpper = true
+		}
+	}
+	if hasLower && hasUpper && addr.String()[2:] != s {
+		return Address{}, errors.New("invalid address checksum")
+	}
+	return addr, nil
+}
+
+// String returns the EIP-55 checksummed hex encoding of the address.
+func (a Address) String() string {
+	lower := hex.EncodeToString(a[:])
+	hash := keccak256([]byte(lower))
+
+	out := []byte(lower)
+	for i, c := range out {
+		// Letters are upper case if the corresponding nibble of the hash is at least 8.
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(out)
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
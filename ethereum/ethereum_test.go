@@ -0,0 +1,106 @@
+package ethereum_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/ethereum"
+	"bip32_threshold_wallet/internal/testutil"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestAddressChecksum(t *testing.T) {
+	for _, s := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+	} {
+		addr, err := ethereum.ParseAddress(s)
+		require.NoError(t, err)
+		assert.Equal(t, s, addr.String())
+	}
+
+	_, err := ethereum.ParseAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD")
+	assert.Error(t, err)
+
+	// Addresses in a single case carry no checksum.
+	for _, s := range []string{"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED"} {
+		addr, err := ethereum.ParseAddress(s)
+		require.NoError(t, err)
+		assert.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", addr.String())
+	}
+}
+
+// The example of EIP-155.
+func TestSignLegacyTx(t *testing.T) {
+	sk := bytes.Repeat([]byte{0x46}, 32)
+	pk, err := curves.K256().Point.FromAffineCompressed(bip32KeyFor(sk).PublicKey().Key)
+	require.NoError(t, err)
+
+	sender, err := ethereum.PublicKeyToAddress(&pk)
+	require.NoError(t, err)
+	assert.Equal(t, "0x9d8A62f656a8d1615C1294fd71e9CFb3E4855A4F", sender.String())
+
+	to, err := ethereum.ParseAddress("0x3535353535353535353535353535353535353535")
+	require.NoError(t, err)
+	value, _ := new(big.Int).SetString("1000000000000000000", 10)
+	tx := &ethereum.LegacyTx{
+		Nonce:    9,
+		GasPrice: big.NewInt(20000000000),
+		Gas:      21000,
+		To:       &to,
+		Value:    value,
+		ChainID:  big.NewInt(1),
+	}
+	assert.Equal(t, "daf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53", hex.EncodeToString(tx.SigningHash()))
+
+	sig, raw, err := tx.Sign(&pk, signing.KeySigner(bip32KeyFor(sk)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(37), sig.V.Int64())
+	assert.Equal(t, "f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83", hex.EncodeToString(raw))
+}
+
+func TestThresholdSignDynamicFeeTx(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	params, decryptKeys := testutil.CreateECDSAParams(3)
+	path := []uint32{0, 3}
+
+	_, pk, _, err := devices[0].PathTweak(path)
+	require.NoError(t, err)
+	sender, err := ethereum.PublicKeyToAddress(pk)
+	require.NoError(t, err)
+
+	tx := &ethereum.DynamicFeeTx{
+		ChainID:   big.NewInt(11155111),
+		Nonce:     1,
+		GasTipCap: big.NewInt(1000000000),
+		GasFeeCap: big.NewInt(30000000000),
+		Gas:       50000,
+		To:        &sender,
+		Value:     big.NewInt(12345),
+		AccessList: []ethereum.AccessTuple{
+			{Address: sender, StorageKeys: [][32]byte{{1}}},
+		},
+	}
+	sig, raw, err := tx.Sign(pk, signing.ThresholdSigner(devices[:2], decryptKeys, params, path))
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x02), raw[0])
+	assert.True(t, sig.S.Cmp(new(big.Int).Rsh(btcec.S256().N, 1)) <= 0, "s must be normalized")
+
+	recovered, err := ethereum.RecoverAddress(tx.SigningHash(), sig.R, sig.S, int(sig.V.Int64()))
+	require.NoError(t, err)
+	assert.Equal(t, sender, recovered)
+}
+
+func bip32KeyFor(sk []byte) *bip32.Key {
+	return &bip32.Key{Key: sk, IsPrivate: true, Version: bip32.PrivateWalletVersion}
+}
@@ -0,0 +1,62 @@
+package ethereum
+
+import (
+	"encoding/binary"
+	"math/big"
+)
+
+// A minimal recursive length prefix encoder, covering the types used in transactions.
+
+// rlpList is an RLP list of already encoded items.
+type rlpList [][]byte
+
+// rlpBytes encodes a byte string.
+func rlpBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(rlpHeader(0x80, len(b)), b...)
+}
+
+// rlpUint encodes an unsigned integer as big-endian byte string without leading zeros.
+func rlpUint(x uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, x)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return rlpBytes(b)
+}
+
+// rlpBigInt encodes a non-negative big integer, where nil encodes zero.
+func rlpBigInt(x *big.Int) []byte {
+	if x == nil {
+		return rlpBytes(nil)
+	}
+	return rlpBytes(x.Bytes())
+}
+
+// encode concatenates the items and prefixes them with the list header.
+func (l rlpList) encode() []byte {
+	size := 0
+	for _, item := range l {
+		size += len(item)
+	}
+	out := rlpHeader(0xc0, size)
+	for _, item := range l {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func rlpHeader(offset byte, size int) []byte {
+	if size < 56 {
+		return []byte{offset + byte(size)}
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(size))
+	for b[0] == 0 {
+		b = b[1:]
+	}
+	return append([]byte{offset + 55 + byte(len(b))}, b...)
+}
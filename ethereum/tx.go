@@ -0,0 +1,173 @@
+package ethereum
+
+import (
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+)
+
+// Signature is a recoverable signature, where V is the recovery id or the value derived from it, depending on the
+// transaction type.
+type Signature struct {
+	V *big.Int
+	R *big.Int
+	S *big.Int
+}
+
+// AccessTuple is an entry of the access list of an EIP-2930 or EIP-1559 transaction.
+type AccessTuple struct {
+	Address     Address
+	StorageKeys [][32]byte
+}
+
+// LegacyTx is a legacy transaction. If ChainID is set, it is signed with replay protection following EIP-155.
+type LegacyTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	To       *Address // Nil for contract creation.
+	Value    *big.Int
+	Data     []byte
+	ChainID  *big.Int
+}
+
+// DynamicFeeTx is an EIP-1559 transaction.
+type DynamicFeeTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int // Max priority fee per gas.
+	GasFeeCap  *big.Int // Max fee per gas.
+	Gas        uint64
+	To         *Address
+	Value      *big.Int
+	Data       []byte
+	AccessList []AccessTuple
+}
+
+const dynamicFeeTxType = 0x02
+
+func (tx *LegacyTx) fields() rlpList {
+	return rlpList{
+		rlpUint(tx.Nonce),
+		rlpBigInt(tx.GasPrice),
+		rlpUint(tx.Gas),
+		rlpAddress(tx.To),
+		rlpBigInt(tx.Value),
+		rlpBytes(tx.Data),
+	}
+}
+
+// SigningHash returns the digest to sign.
+func (tx *LegacyTx) SigningHash() []byte {
+	fields := tx.fields()
+	if tx.ChainID != nil {
+		fields = append(fields, rlpBigInt(tx.ChainID), rlpUint(0), rlpUint(0))
+	}
+	return keccak256(fields.encode())
+}
+
+// Encode returns the raw signed transaction.
+func (tx *LegacyTx) Encode(sig *Signature) []byte {
+	return append(tx.fields(), rlpBigInt(sig.V), rlpBigInt(sig.R), rlpBigInt(sig.S)).encode()
+}
+
+// Sign signs the transaction for the given public key and returns the signature and the raw signed transaction.
+// V is 27 + recovery id, or chainId*2 + 35 + recovery id with EIP-155.
+func (tx *LegacyTx) Sign(pk node.PublicKey, signer signing.DigestSigner) (*Signature, []byte, error) {
+	sig, recID, err := signRecoverable(tx.SigningHash(), pk, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tx.ChainID != nil {
+		sig.V = new(big.Int).Mul(tx.ChainID, big.NewInt(2))
+		sig.V.Add(sig.V, big.NewInt(int64(35+recID)))
+	} else {
+		sig.V = big.NewInt(int64(27 + recID))
+	}
+	return sig, tx.Encode(sig), nil
+}
+
+func (tx *DynamicFeeTx) fields() rlpList {
+	accessList := make(rlpList, len(tx.AccessList))
+	for i, tuple := range tx.AccessList {
+		keys := make(rlpList, len(tuple.StorageKeys))
+		for j, key := range tuple.StorageKeys {
+			keys[j] = rlpBytes(key[:])
+		}
+		accessList[i] = rlpList{rlpBytes(tuple.Address[:]), keys.encode()}.encode()
+	}
+
+	return rlpList{
+		rlpBigInt(tx.ChainID),
+		rlpUint(tx.Nonce),
+		rlpBigInt(tx.GasTipCap),
+		rlpBigInt(tx.GasFeeCap),
+		rlpUint(tx.Gas),
+		rlpAddress(tx.To),
+		rlpBigInt(tx.Value),
+		rlpBytes(tx.Data),
+		accessList.encode(),
+	}
+}
+
+// SigningHash returns the digest to sign, keccak256(0x02 || rlp(fields)).
+func (tx *DynamicFeeTx) SigningHash() []byte {
+	return keccak256([]byte{dynamicFeeTxType}, tx.fields().encode())
+}
+
+// Encode returns the raw signed transaction, 0x02 || rlp(fields || yParity, r, s).
+func (tx *DynamicFeeTx) Encode(sig *Signature) []byte {
+	encoded := append(tx.fields(), rlpBigInt(sig.V), rlpBigInt(sig.R), rlpBigInt(sig.S)).encode()
+	return append([]byte{dynamicFeeTxType}, encoded...)
+}
+
+// Sign signs the transaction for the given public key and returns the signature, whose V is the recovery id, and the
+// raw signed transaction.
+func (tx *DynamicFeeTx) Sign(pk node.PublicKey, signer signing.DigestSigner) (*Signature, []byte, error) {
+	if tx.ChainID == nil {
+		return nil, nil, errors.New("EIP-1559 transactions require a chain id")
+	}
+	sig, recID, err := signRecoverable(tx.SigningHash(), pk, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig.V = big.NewInt(int64(recID))
+	return sig, tx.Encode(sig), nil
+}
+
+// signRecoverable signs the digest with s normalized as required by EIP-2 and returns the signature together with its
+// recovery id.
+func signRecoverable(digest []byte, pk node.PublicKey, signer signing.DigestSigner) (*Signature, int, error) {
+	r, s, recID, err := signing.SignRecoverable(digest, pk, signer)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &Signature{R: r, S: s}, recID, nil
+}
+
+// RecoverAddress recovers the address of the signer from a signature of the digest with the given recovery id.
+func RecoverAddress(digest []byte, r, s *big.Int, recID int) (Address, error) {
+	compact := make([]byte, 65)
+	compact[0] = byte(27 + recID)
+	r.FillBytes(compact[1:33])
+	s.FillBytes(compact[33:])
+
+	recovered, _, err := btcec.RecoverCompact(btcec.S256(), compact, digest)
+	if err != nil {
+		return Address{}, errors.Wrap(err, "recovering public key")
+	}
+	var addr Address
+	copy(addr[:], keccak256(recovered.SerializeUncompressed()[1:])[12:])
+	return addr, nil
+}
+
+func rlpAddress(a *Address) []byte {
+	if a == nil {
+		return rlpBytes(nil)
+	}
+	return rlpBytes(a[:])
+}
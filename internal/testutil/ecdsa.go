@@ -0,0 +1,53 @@
+// Package testutil provides fixtures for tests and benchmarks, which must not be used by production code.
+package testutil
+
+import (
+	"math/big"
+
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/coinbase/kryptology/pkg/tecdsa/gg20/dealer"
+
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+// Fixed proof parameters and safe primes taken from the kryptology test suite, since generating them takes minutes.
+var (
+	ecdsaProofParams = &dealer.ProofParams{
+		N:  utils.B10("135817986946410153263607521492868157288929876347703239389804036854326452848342067707805833332721355089496671444901101084429868705550525577068432132709786157994652561102559125256427177197007418406633665154772412807319781659630513167839812152507439439445572264448924538846645935065905728327076331348468251587961"),
+		H1: utils.B10("130372793360787914947629694846841279927281520987029701609177523587189885120190605946568222485341643012763305061268138793179515860485547361500345083617939280336315872961605437911597699438598556875524679018909165548046362772751058504008161659270331468227764192850055032058007664070200355866555886402826731196521"),
+		H2: utils.B10("44244046835929503435200723089247234648450309906417041731862368762294548874401406999952605461193318451278897748111402857920811242015075045913904246368542432908791195758912278843108225743582704689703680577207804641185952235173475863508072754204128218500376538767731592009803034641269409627751217232043111126391"),
+	}
+	ecdsaPrimes = []*big.Int{
+		utils.B10("186141419611617071752010179586510154515933389116254425631491755419216243670159714804545944298892950871169229878325987039840135057969555324774918895952900547869933648175107076399993833724447909579697857041081987997463765989497319509683575289675966710007879762972723174353568113668226442698275449371212397561567"),
+		utils.B10("94210786053667323206442523040419729883258172350738703980637961803118626748668924192069593010365236618255120977661397310932923345291377692570649198560048403943687994859423283474169530971418656709749020402756179383990602363122039939937953514870699284906666247063852187255623958659551404494107714695311474384687"),
+		utils.B10("130291226847076770981564372061529572170236135412763130013877155698259035960569046218348763182598589633420963942796327547969527085797839549642610021986391589746295634536750785366034581957858065740296991986002552598751827526181747791647357767502200771965093659353354985289411489453223546075843993686648576029043"),
+		utils.B10("172938910323633442195852028319756134734590277522945546987913328782597284762767185925315797321999389252040294991952361905020940252121762387957669654615602135429944435719699091344247805645764550860505536884031064967454028383404046221898300153428182409080298694828920944094158777327533157774919783417586902830043"),
+		utils.B10("135841191929788643010555393808775051922265083622266098277752143441294911675705272940799534437169053045878247274810449617960047255023823301284034559807472662111224710158898548617194658983006262996831617082584649612602010680423107108651221824216065228161009680618243402116924511141821829055830713600437589058643"),
+		utils.B10("179677777376220950493907657233669314916823596507009854134559513388779535023958212632715646194917807302098015450071151245496651913873851032302340489007561121851068326577148680474495447007833318066335149850926605897908761267606415610900931306044455332084757793630487163583451178807470499389106913845684353833379"),
+	}
+)
+
+// CreateECDSAParams creates threshold ECDSA parameters with Paillier keys for the devices with identifiers 1, ..., n
+// from fixed primes. Every device gets its own pair of primes, so that no two moduli share a factor. It panics if there
+// are not enough primes for n devices.
+func CreateECDSAParams(n uint32) (*signing.ECDSAParams, map[uint32]*paillier.SecretKey) {
+	if int(n) > len(ecdsaPrimes)/2 {
+		panic("not enough fixed primes for the Paillier keys of the devices")
+	}
+
+	params := &signing.ECDSAParams{
+		ProofParams: ecdsaProofParams,
+		EncryptKeys: make(map[uint32]*paillier.PublicKey, n),
+	}
+	decryptKeys := make(map[uint32]*paillier.SecretKey, n)
+	for id := uint32(1); id <= n; id++ {
+		sk, err := paillier.NewSecretKey(ecdsaPrimes[2*id-2], ecdsaPrimes[2*id-1])
+		if err != nil {
+			panic(err)
+		}
+		decryptKeys[id] = sk
+		params.EncryptKeys[id] = &sk.PublicKey
+	}
+	return params, decryptKeys
+}
@@ -0,0 +1,108 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/address"
+)
+
+// BIP137 compact signatures: a base64 encoded recoverable ECDSA signature of the double SHA-256 of the message prefixed
+// with the magic "Bitcoin Signed Message:\n", whose header byte encodes the recovery id and the address type.
+
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// Header byte offsets of the address types, added to 27 + recovery id.
+var bip137Headers = map[address.Type]byte{
+	address.P2PKH:      4, // Compressed public key, 0 for uncompressed ones.
+	address.P2SHP2WPKH: 8,
+	address.P2WPKH:     12,
+}
+
+// Hash returns the digest signed in BIP137 signatures of the message.
+func Hash(msg []byte) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, messageMagic)
+	_ = wire.WriteVarBytes(&buf, 0, msg)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// SignBIP137 produces the BIP137 signature of the message for the address of the given type of the key, which is one
+// of P2PKH, P2SH-P2WPKH and P2WPKH.
+func SignBIP137(k *Key, t address.Type, msg []byte) (string, error) {
+	header, ok := bip137Headers[t]
+	if !ok {
+		return "", errors.Errorf("BIP137 does not support %s addresses", t)
+	}
+
+	sig, recID, err := k.signCompact(Hash(msg))
+	if err != nil {
+		return "", err
+	}
+	compact := make([]byte, 65)
+	compact[0] = 27 + byte(recID) + header
+	sig.R.FillBytes(compact[1:33])
+	sig.S.FillBytes(compact[33:])
+	return base64.StdEncoding.EncodeToString(compact), nil
+}
+
+// VerifyBIP137 verifies the BIP137 signature of the message by the given address. Signatures of segwit addresses with
+// the header of compressed P2PKH ones, as produced by wallets predating BIP137, are accepted as well.
+func VerifyBIP137(addr string, msg []byte, sig string, params *chaincfg.Params) error {
+	compact, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+	if len(compact) != 65 || compact[0] < 27 || compact[0] > 42 {
+		return errors.New("invalid signature")
+	}
+	recID := (compact[0] - 27) & 3
+	flag := (compact[0] - 27) &^ 3
+
+	// RecoverCompact only knows the headers of P2PKH addresses.
+	normalized := append([]byte{27 + recID}, compact[1:]...)
+	if flag != 0 {
+		normalized[0] += 4
+	}
+	pk, _, err := btcec.RecoverCompact(btcec.S256(), normalized, Hash(msg))
+	if err != nil {
+		return errors.Wrap(err, "recovering public key")
+	}
+
+	if flag == 0 {
+		uncompressed, err := btcutil.NewAddressPubKey(pk.SerializeUncompressed(), params)
+		if err != nil {
+			return err
+		}
+		if uncompressed.AddressPubKeyHash().EncodeAddress() != addr {
+			return errors.New("signature does not belong to the address")
+		}
+		return nil
+	}
+
+	point, err := curves.K256().Point.FromAffineCompressed(pk.SerializeCompressed())
+	if err != nil {
+		return err
+	}
+	for _, t := range []address.Type{address.P2PKH, address.P2SHP2WPKH, address.P2WPKH} {
+		if flag != bip137Headers[t] && flag != bip137Headers[address.P2PKH] {
+			continue
+		}
+		encoded, err := address.Encode(&point, t, params)
+		if err != nil {
+			return err
+		}
+		if encoded == addr {
+			return nil
+		}
+	}
+	return errors.New("signature does not belong to the address")
+}
@@ -0,0 +1,239 @@
+package message
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/signing"
+)
+
+// BIP322 simple signatures: the witness of the virtual to_sign transaction spending the output of the virtual to_spend
+// transaction, which commits to the message and pays to the address.
+
+const (
+	sigHashDefault = 0x00 // BIP341 SIGHASH_DEFAULT.
+	maxWitnessItem = 520
+)
+
+// BIP322Hash returns the tagged hash of the message committed to in the to_spend transaction.
+func BIP322Hash(msg []byte) []byte {
+	return signing.TaggedHash("BIP0322-signed-message", msg)
+}
+
+// SignBIP322 produces the BIP322 simple signature of the message for the address of the given type of the key, which
+// is P2WPKH or P2TR.
+func SignBIP322(k *Key, t address.Type, msg []byte, params *chaincfg.Params) (string, error) {
+	addr, err := address.Encode(k.publicKey, t, params)
+	if err != nil {
+		return "", err
+	}
+	pkScript, err := address.PkScript(addr, params)
+	if err != nil {
+		return "", err
+	}
+	toSpend, err := toSpendTx(pkScript, msg)
+	if err != nil {
+		return "", err
+	}
+	toSign := toSignTx(toSpend)
+
+	var witness wire.TxWitness
+	switch t {
+	case address.P2WPKH:
+		digest, err := txscript.CalcWitnessSigHash(pkScript, txscript.NewTxSigHashes(toSign), txscript.SigHashAll, toSign, 0, 0)
+		if err != nil {
+			return "", err
+		}
+		sig, _, err := k.signCompact(digest)
+		if err != nil {
+			return "", err
+		}
+		witness = wire.TxWitness{
+			append(sig.Serialize(), byte(txscript.SigHashAll)),
+			(*k.publicKey).ToAffineCompressed(),
+		}
+
+	case address.P2TR:
+		key, err := k.bip340()
+		if err != nil {
+			return "", err
+		}
+		if err := key.TaprootTweak(nil); err != nil {
+			return "", err
+		}
+		sig, err := k.schnorr(key, taprootSigHash(toSign, toSpend.TxOut[0], sigHashDefault))
+		if err != nil {
+			return "", err
+		}
+		witness = wire.TxWitness{sig}
+
+	default:
+		return "", errors.Errorf("BIP322 simple signatures of %s addresses are not supported", t)
+	}
+
+	var buf bytes.Buffer
+	if err := writeWitness(&buf, witness); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// VerifyBIP322 verifies the BIP322 simple signature of the message by the given address. Segwit v0 outputs are
+// validated with the script interpreter, taproot outputs with a key path spend using SIGHASH_DEFAULT or SIGHASH_ALL.
+func VerifyBIP322(addr string, msg []byte, sig string, params *chaincfg.Params) error {
+	pkScript, err := address.PkScript(addr, params)
+	if err != nil {
+		return err
+	}
+	encoded, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+	r := bytes.NewReader(encoded)
+	witness, err := readWitness(r)
+	if err != nil {
+		return errors.Wrap(err, "decoding witness")
+	}
+	if r.Len() != 0 {
+		return errors.New("trailing data after witness")
+	}
+
+	toSpend, err := toSpendTx(pkScript, msg)
+	if err != nil {
+		return err
+	}
+	toSign := toSignTx(toSpend)
+	toSign.TxIn[0].Witness = witness
+
+	if len(pkScript) == 34 && pkScript[0] == txscript.OP_1 && pkScript[1] == txscript.OP_DATA_32 {
+		return verifyTaprootKeySpend(pkScript[2:], toSign, toSpend.TxOut[0])
+	}
+
+	vm, err := txscript.NewEngine(pkScript, toSign, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(toSign), 0)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(vm.Execute(), "signature does not belong to the address")
+}
+
+// verifyTaprootKeySpend verifies the witness of the to_sign transaction as key path spend of the output key.
+func verifyTaprootKeySpend(outputKey []byte, toSign *wire.MsgTx, prevOut *wire.TxOut) error {
+	witness := toSign.TxIn[0].Witness
+	if len(witness) != 1 {
+		return errors.New("only key path spends without annex are supported")
+	}
+	sig := witness[0]
+	hashType := byte(sigHashDefault)
+	switch len(sig) {
+	case 64:
+	case 65:
+		hashType = sig[64]
+		if hashType != byte(txscript.SigHashAll) {
+			return errors.Errorf("unsupported signature hash type %#x", hashType)
+		}
+		sig = sig[:64]
+	default:
+		return errors.New("invalid signature length")
+	}
+
+	if !signing.VerifySchnorr(outputKey, taprootSigHash(toSign, prevOut, hashType), sig) {
+		return errors.New("signature does not belong to the address")
+	}
+	return nil
+}
+
+// toSpendTx builds the virtual transaction committing to the message, whose only output pays to the given script.
+func toSpendTx(pkScript, msg []byte) (*wire.MsgTx, error) {
+	scriptSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(BIP322Hash(msg)).Script()
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx(0)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: 0xffffffff},
+		SignatureScript:  scriptSig,
+		Sequence:         0,
+	})
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+	return tx, nil
+}
+
+// toSignTx builds the unsigned virtual transaction spending the output of to_spend.
+func toSignTx(toSpend *wire.MsgTx) *wire.MsgTx {
+	tx := wire.NewMsgTx(0)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash(), Index: 0},
+		Sequence:         0,
+	})
+	tx.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return tx
+}
+
+// taprootSigHash computes the BIP341 signature hash of a key path spend of the first input for SIGHASH_DEFAULT or
+// SIGHASH_ALL, which the btcd version in use does not implement. Only single-input transactions are supported, which
+// is all BIP322 needs.
+func taprootSigHash(tx *wire.MsgTx, prevOut *wire.TxOut, hashType byte) []byte {
+	var prevouts, amounts, scripts, sequences, outputs bytes.Buffer
+	for _, in := range tx.TxIn {
+		prevouts.Write(in.PreviousOutPoint.Hash[:])
+		_ = binary.Write(&prevouts, binary.LittleEndian, in.PreviousOutPoint.Index)
+		_ = binary.Write(&sequences, binary.LittleEndian, in.Sequence)
+	}
+	_ = binary.Write(&amounts, binary.LittleEndian, prevOut.Value)
+	_ = wire.WriteVarBytes(&scripts, 0, prevOut.PkScript)
+	for _, out := range tx.TxOut {
+		_ = wire.WriteTxOut(&outputs, 0, 0, out)
+	}
+
+	var msg bytes.Buffer
+	msg.WriteByte(0x00) // Epoch.
+	msg.WriteByte(hashType)
+	_ = binary.Write(&msg, binary.LittleEndian, tx.Version)
+	_ = binary.Write(&msg, binary.LittleEndian, tx.LockTime)
+	for _, field := range []*bytes.Buffer{&prevouts, &amounts, &scripts, &sequences, &outputs} {
+		h := sha256.Sum256(field.Bytes())
+		msg.Write(h[:])
+	}
+	msg.WriteByte(0x00) // Key path spend without annex.
+	_ = binary.Write(&msg, binary.LittleEndian, uint32(0))
+
+	return signing.TaggedHash("TapSighash", msg.Bytes())
+}
+
+func writeWitness(w io.Writer, witness wire.TxWitness) error {
+	if err := wire.WriteVarInt(w, 0, uint64(len(witness))); err != nil {
+		return err
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(w, 0, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWitness(r io.Reader) (wire.TxWitness, error) {
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || n > 100 {
+		return nil, errors.Errorf("invalid number of witness items %d", n)
+	}
+	witness := make(wire.TxWitness, n)
+	for i := range witness {
+		if witness[i], err = wire.ReadVarBytes(r, 0, maxWitnessItem, "witness item"); err != nil {
+			return nil, err
+		}
+	}
+	return witness, nil
+}
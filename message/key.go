@@ -0,0 +1,92 @@
+package message
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/wallet"
+)
+
+// Key is a key in the derivation tree of a committee that messages are signed with, either by the devices with
+// threshold signing or locally with an extended private key.
+type Key struct {
+	publicKey node.PublicKey
+	ecdsa     signing.DigestSigner
+	bip340    func() (*signing.SchnorrKey, error)
+	schnorr   func(key *signing.SchnorrKey, msg []byte) ([]byte, error)
+}
+
+// ThresholdKey returns the key of the descendant at the non-hardened relative path below the devices' node. ECDSA
+// signatures are produced with GG20 and Schnorr signatures with FROST, both tweaking the shares at signing time.
+func ThresholdKey(devices []node.Device, decryptKeys map[uint32]*paillier.SecretKey, params *signing.ECDSAParams, path []uint32) (*Key, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices given")
+	}
+	_, pk, _, err := devices[0].PathTweak(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		publicKey: pk,
+		ecdsa:     signing.ThresholdSigner(devices, decryptKeys, params, path),
+		bip340: func() (*signing.SchnorrKey, error) {
+			return signing.NewBIP340KeyForPath(&devices[0], path)
+		},
+		schnorr: func(key *signing.SchnorrKey, msg []byte) ([]byte, error) {
+			return signing.SignSchnorr(devices, key, msg)
+		},
+	}, nil
+}
+
+// LocalKey returns the key of an extended private key, e.g. one exported with node.ExportXPrv.
+func LocalKey(key *bip32.Key) (*Key, error) {
+	if !key.IsPrivate {
+		return nil, errors.New("extended private key required")
+	}
+	point, err := curves.K256().Point.FromAffineCompressed(key.PublicKey().Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key")
+	}
+	pk := node.PublicKey(&point)
+
+	return &Key{
+		publicKey: pk,
+		ecdsa:     signing.KeySigner(key),
+		bip340: func() (*signing.SchnorrKey, error) {
+			return signing.NewBIP340Key(pk), nil
+		},
+		schnorr: func(k *signing.SchnorrKey, msg []byte) ([]byte, error) {
+			return signing.SignSchnorrWithKey(key.Key, k, msg)
+		},
+	}, nil
+}
+
+// WalletKey returns the key at a path of the form purpose'/coin'/account'/... below one of the wallet's accounts. The
+// first t devices sign with their shares of the account, tweaking them at signing time like ThresholdKey.
+func WalletKey(w *wallet.Wallet, decryptKeys map[uint32]*paillier.SecretKey, params *signing.ECDSAParams, path []uint32) (*Key, error) {
+	devices, rest, err := w.DevicesForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return ThresholdKey(devices[:devices[0].Threshold()], decryptKeys, params, rest)
+}
+
+// PublicKey returns the public key.
+func (k *Key) PublicKey() node.PublicKey {
+	return k.publicKey
+}
+
+// signCompact produces a low-S ECDSA signature of the digest and returns it together with its recovery id.
+func (k *Key) signCompact(digest []byte) (*btcec.Signature, int, error) {
+	r, s, recID, err := signing.SignRecoverable(digest, k.publicKey, k.ecdsa)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &btcec.Signature{R: r, S: s}, recID, nil
+}
@@ -0,0 +1,134 @@
+package message_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/internal/testutil"
+	"bip32_threshold_wallet/message"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/utils"
+	"bip32_threshold_wallet/wallet"
+)
+
+// The test vectors of BIP322.
+func TestBIP322Vectors(t *testing.T) {
+	assert.Equal(t, "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1", hex.EncodeToString(message.BIP322Hash([]byte(""))))
+	assert.Equal(t, "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a", hex.EncodeToString(message.BIP322Hash([]byte("Hello World"))))
+
+	params := &chaincfg.MainNetParams
+	for _, v := range []struct {
+		addr, msg, sig string
+	}{
+		{"bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l", "", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{"bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l", "Hello World", "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{"bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3", "Hello World", "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ=="},
+	} {
+		assert.NoError(t, message.VerifyBIP322(v.addr, []byte(v.msg), v.sig, params), "%s %q", v.addr, v.msg)
+		assert.Error(t, message.VerifyBIP322(v.addr, []byte(v.msg+"!"), v.sig, params), "%s %q", v.addr, v.msg)
+	}
+}
+
+// Signatures produced by btcd's compact signing must verify.
+func TestVerifyBIP137Compact(t *testing.T) {
+	seed := sha256.Sum256([]byte("message signing test key"))
+	sk, _ := btcec.PrivKeyFromBytes(btcec.S256(), seed[:])
+	msg := []byte("proof of reserves")
+	params := &chaincfg.MainNetParams
+
+	for _, compressed := range []bool{true, false} {
+		compact, err := btcec.SignCompact(btcec.S256(), sk, message.Hash(msg), compressed)
+		require.NoError(t, err)
+		serialized := sk.PubKey().SerializeUncompressed()
+		if compressed {
+			serialized = sk.PubKey().SerializeCompressed()
+		}
+		addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(serialized), params)
+		require.NoError(t, err)
+
+		sig := base64.StdEncoding.EncodeToString(compact)
+		assert.NoError(t, message.VerifyBIP137(addr.EncodeAddress(), msg, sig, params))
+		assert.Error(t, message.VerifyBIP137(addr.EncodeAddress(), []byte("other"), sig, params))
+	}
+}
+
+func TestSignThreshold(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	ecdsaParams, decryptKeys := testutil.CreateECDSAParams(3)
+	params := &chaincfg.TestNet3Params
+	msg := []byte("proof of reserves")
+
+	key, err := message.ThresholdKey(devices[1:], decryptKeys, ecdsaParams, []uint32{0, 7})
+	require.NoError(t, err)
+	other, err := message.ThresholdKey(devices[1:], decryptKeys, ecdsaParams, []uint32{0, 8})
+	require.NoError(t, err)
+
+	t.Run("BIP137", func(t *testing.T) {
+		for _, typ := range []address.Type{address.P2PKH, address.P2SHP2WPKH, address.P2WPKH} {
+			sig, err := message.SignBIP137(key, typ, msg)
+			require.NoError(t, err)
+			addr, err := address.Encode(key.PublicKey(), typ, params)
+			require.NoError(t, err)
+			otherAddr, err := address.Encode(other.PublicKey(), typ, params)
+			require.NoError(t, err)
+
+			assert.NoError(t, message.VerifyBIP137(addr, msg, sig, params), typ.String())
+			assert.Error(t, message.VerifyBIP137(otherAddr, msg, sig, params), typ.String())
+		}
+		_, err := message.SignBIP137(key, address.P2TR, msg)
+		assert.Error(t, err)
+	})
+
+	t.Run("BIP322", func(t *testing.T) {
+		for _, typ := range []address.Type{address.P2WPKH, address.P2TR} {
+			sig, err := message.SignBIP322(key, typ, msg, params)
+			require.NoError(t, err)
+			addr, err := address.Encode(key.PublicKey(), typ, params)
+			require.NoError(t, err)
+			otherAddr, err := address.Encode(other.PublicKey(), typ, params)
+			require.NoError(t, err)
+
+			assert.NoError(t, message.VerifyBIP322(addr, msg, sig, params), typ.String())
+			assert.Error(t, message.VerifyBIP322(addr, []byte("other"), sig, params), typ.String())
+			assert.Error(t, message.VerifyBIP322(otherAddr, msg, sig, params), typ.String())
+		}
+	})
+}
+
+func TestSignWalletKey(t *testing.T) {
+	curve := curves.K256()
+	devices := utils.CreateDevices(2, 3)
+	deriv := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(2, 3, curve, sha256.New(), true), true)
+	w, err := wallet.NewWallet(&deriv, 1, "")
+	require.NoError(t, err)
+	ecdsaParams, decryptKeys := testutil.CreateECDSAParams(3)
+	params := &chaincfg.TestNet3Params
+	msg := []byte("proof of reserves")
+
+	account, err := w.CreateAccount(wallet.BIP86, 0)
+	require.NoError(t, err)
+	addr, err := account.Address(wallet.ExternalChain, 3, params)
+	require.NoError(t, err)
+
+	h := bip32.FirstHardenedChild
+	key, err := message.WalletKey(w, decryptKeys, ecdsaParams, []uint32{h + 86, h + 1, h, wallet.ExternalChain, 3})
+	require.NoError(t, err)
+	sig, err := message.SignBIP322(key, address.P2TR, msg, params)
+	require.NoError(t, err)
+	assert.NoError(t, message.VerifyBIP322(addr, msg, sig, params))
+
+	_, err = message.WalletKey(w, decryptKeys, ecdsaParams, []uint32{h + 86, h + 2, h})
+	assert.Error(t, err, "keys of other coin types must be rejected")
+}
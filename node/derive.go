@@ -0,0 +1,137 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+)
+
+// ErrInvalidChild is returned if the key of a child is invalid, which happens with a probability below 2^-127. Following
+// BIP32, the child must be skipped and the next index be used instead.
+var ErrInvalidChild = errors.New("derived key is invalid, proceed with the next index")
+
+// DeriveNonHardenedChild derives the device's share of the non-hardened child with the given index.
+// Following BIP32, (IL, IR) = HMAC-SHA512(c, ser_P(pk) || ser_32(i)) is computed from public information only. The
+// share is then shifted by IL using RandSk, so that the child shares a secret key sk + IL with chain code IR.
+func (d *Device) DeriveNonHardenedChild(childIdx uint32) (Device, error) {
+	if childIdx >= bip32.FirstHardenedChild {
+		return Device{}, errors.New("invalid child index for non-hardened derivation")
+	}
+
+	il, ir, err := nonHardenedTweak(d.publicKeyGlobal, d.state.chainCode, childIdx)
+	if err != nil {
+		return Device{}, err
+	}
+	return d.deriveChild(childIdx, il, ir)
+}
+
+// DeriveHardenedChild derives the device's share of the hardened child with the given index, without the hardened
+// bit, from a tweak and a chain code the committee obtained by evaluating a TVRF on the index. Like for non-hardened
+// children, the share is shifted by the tweak using RandSk, so that the child shares the secret key sk + tweak and no
+// device ever holds the child's secret key.
+// Computing the combined TVRF evaluation, which the tweak is derived from, requires the partial evaluations of t
+// devices. However, the party combining them and every device deriving the child learn the tweak in the clear. Unlike
+// in BIP32, these parties can thus compute the parent's secret key from the secret key of a hardened child, while
+// parties knowing fewer than t partial evaluations cannot.
+func (d *Device) DeriveHardenedChild(childIdx uint32, tweak *curves.Element, chainCode []byte) (Device, error) {
+	if childIdx >= bip32.FirstHardenedChild {
+		return Device{}, errors.New("invalid child index for hardened derivation")
+	}
+	if len(chainCode) != 32 {
+		return Device{}, errors.New("invalid chain code")
+	}
+	return d.deriveChild(childIdx+bip32.FirstHardenedChild, tweak, chainCode)
+}
+
+// deriveChild shifts the device's share by the tweak and returns it as the share of the child with the given BIP32
+// index and chain code.
+func (d *Device) deriveChild(childIdx uint32, tweak *curves.Element, chainCode []byte) (Device, error) {
+	if err := d.CheckShare(); err != nil {
+		return Device{}, err
+	}
+	if d.state.depth == maxDepth {
+		return Device{}, errors.New("maximum depth of the derivation tree reached")
+	}
+	parentFingerprint, err := fingerprint(d.publicKeyGlobal)
+	if err != nil {
+		return Device{}, err
+	}
+
+	child := *d
+	if err := child.RandSk(tweak); err != nil {
+		return Device{}, errors.Wrap(err, "shifting key share")
+	}
+	if (*child.publicKeyGlobal).IsIdentity() {
+		return Device{}, ErrInvalidChild
+	}
+	child.state = State{
+		nodeIdx:           childIdx,
+		chainCode:         chainCode,
+		depth:             d.state.depth + 1,
+		parentFingerprint: parentFingerprint,
+	}
+
+	return child, nil
+}
+
+// nonHardenedTweak computes the BIP32 tweak IL and the child chain code IR of a non-hardened child.
+func nonHardenedTweak(pk PublicKey, chainCode []byte, childIdx uint32) (*curves.Element, []byte, error) {
+	if len(chainCode) != 32 {
+		return nil, nil, errors.New("invalid chain code")
+	}
+
+	idxBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(idxBytes, childIdx)
+
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write((*pk).ToAffineCompressed())
+	mac.Write(idxBytes)
+	intermediary := mac.Sum(nil)
+
+	ilInt := new(big.Int).SetBytes(intermediary[:32])
+	field := curves.NewField(curve.Params().N)
+	if !field.IsValid(ilInt) {
+		return nil, nil, ErrInvalidChild
+	}
+
+	return field.NewElement(ilInt), intermediary[32:], nil
+}
+
+// NonHardenedPathTweak computes the cumulative BIP32 tweak of the non-hardened relative path below the node with the
+// given public key and chain code, i.e. the scalar that has to be added to the node's secret key to obtain the secret
+// key of the descendant. It also returns the descendant's public key and chain code.
+// This allows signing for descendants without deriving and storing their shares.
+func NonHardenedPathTweak(pk PublicKey, chainCode []byte, path []uint32) (*curves.Element, PublicKey, []byte, error) {
+	tweak := curves.NewField(curve.Params().N).Zero()
+	for _, childIdx := range path {
+		if childIdx >= bip32.FirstHardenedChild {
+			return nil, nil, nil, errors.New("path must only contain non-hardened indices")
+		}
+
+		il, ir, err := nonHardenedTweak(pk, chainCode, childIdx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if pk, err = shiftPublicKey(pk, il); err != nil {
+			return nil, nil, nil, err
+		}
+		if (*pk).IsIdentity() {
+			return nil, nil, nil, ErrInvalidChild
+		}
+		tweak = tweak.Add(il)
+		chainCode = ir
+	}
+
+	return tweak, pk, chainCode, nil
+}
+
+// PathTweak computes the cumulative tweak of the non-hardened relative path below the device's node, see
+// NonHardenedPathTweak.
+func (d *Device) PathTweak(path []uint32) (*curves.Element, PublicKey, []byte, error) {
+	return NonHardenedPathTweak(d.publicKeyGlobal, d.state.chainCode, path)
+}
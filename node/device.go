@@ -1,11 +1,12 @@
 package node
 
 import (
-	"math/big"
+	"encoding/binary"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/coinbase/kryptology/pkg/core/curves"
 	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/pkg/errors"
 	"go.dedis.ch/dela/dkg/pedersen/types"
 	"go.dedis.ch/dela/mino"
 	"go.dedis.ch/dela/serde"
@@ -29,14 +30,17 @@ type Device struct {
 	n               uint32
 	secretKeyShare  SecretKeyShare
 	publicKeyShare  PublicKeyShare
-	publicKeyGlobal PublicKey // Global public key
+	publicKeyGlobal PublicKey         // Global public key
+	commitments     []*curves.EcPoint // Feldman commitments to the coefficients of the sharing polynomial.
+	epoch           uint64            // Refresh epoch of the key share.
 
 	mino    mino.Mino
 	factory serde.Factory
 	privkey kyber.Scalar
 }
 
-func NewDevice(idx int, pk PublicKeyShare, sk SecretKeyShare, pkG PublicKey, index uint32, ch []byte, m mino.Mino) (Device, kyber.Point) {
+// NewDevice creates the device with index idx of a (t, n) threshold sharing with the given Feldman commitments.
+func NewDevice(idx int, t, n uint32, pk PublicKeyShare, sk SecretKeyShare, pkG PublicKey, commitments []*curves.EcPoint, index uint32, ch []byte, m mino.Mino) (Device, kyber.Point) {
 	var factory serde.Factory
 	if m != nil {
 		factory = types.NewMessageFactory(m.GetAddressFactory())
@@ -46,16 +50,20 @@ func NewDevice(idx int, pk PublicKeyShare, sk SecretKeyShare, pkG PublicKey, ind
 	pubkey := suite.Point().Mul(privkey, nil)
 
 	state := State{
-		nodeIdx:   index,
-		chainCode: ch,
+		nodeIdx:           index,
+		chainCode:         ch,
+		parentFingerprint: make([]byte, 4),
 	}
 
 	return Device{
 		state:           state,
 		deviceIdx:       idx,
+		t:               t,
+		n:               n,
 		secretKeyShare:  sk,
 		publicKeyShare:  pk,
 		publicKeyGlobal: pkG,
+		commitments:     commitments,
 		privkey:         privkey,
 		mino:            m,
 		factory:         factory,
@@ -63,32 +71,54 @@ func NewDevice(idx int, pk PublicKeyShare, sk SecretKeyShare, pkG PublicKey, ind
 }
 
 // RandSk randomizes the device's secret key share using the given randomness rho.
-// TODO: Need to check whether rho and the a_i values should be curves.Element or curves.Scalar.
-// Shamir shares can be safely transformed to scalars using: curve.Scalar.SetBytes(secretKeyShare.Bytes())
-func (d *Device) RandSk(rho curves.Element) *v1.ShamirShare {
-	field := curves.NewField(curve.Params().N)
-
-	indexElement := field.NewElement(big.NewInt(int64(d.deviceIdx)))
-	rhoPrime := computeCoefficient(rho, int(d.t), field) // The last coefficient.
-	for i := int(d.t) - 1; i >= 0; i-- {
-		var aki *curves.Element
-		// Since F(x) = rho + a_1*x + ... + a_{t-1}*x^{t-1} + a_t*x^t
-		if i == 0 {
-			aki = rho.Clone()
-		} else {
-			aki = computeCoefficient(rho, i, field)
-		}
-		// rho' = rho + ak_i^i * deviceIdx^iExp
-		rhoPrime = rhoPrime.Mul(indexElement).Add(aki)
+// The share is shifted by F(i), where F(x) = rho + a_1*x + ... + a_{t-1}*x^{t-1} and a_k = H(rho || k), so that all
+// devices shift their shares along the same polynomial without further interaction. Consequently, the shared secret
+// key is shifted by rho and the global public key by rho*G. The public key share and the Feldman commitments are
+// updated accordingly, which lets anyone knowing rho verify the new share.
+func (d *Device) RandSk(rho *curves.Element) error {
+	if d.t == 0 {
+		return errors.New("threshold of the device is not set")
 	}
 
-	// sk_i' = sk_i + rho_i'
-	skPrime := d.secretKeyShare.Value.Clone().Add(rhoPrime)
+	id := d.secretKeyShare.Identifier
+	coeffs := randPolynomial(rho, d.t)
+	rhoPrime := evalPolynomial(coeffs, id)
 
-	return &v1.ShamirShare{
-		Identifier: uint32(d.deviceIdx),
-		Value:      skPrime,
+	// sk_i' = sk_i + F(i)
+	skPrime := &v1.ShamirShare{
+		Identifier: id,
+		Value:      d.secretKeyShare.Value.Add(rhoPrime),
 	}
+
+	// pk_i' = pk_i + F(i)*G
+	pkPrime, err := shiftPublicKeyShare(d.publicKeyShare, rhoPrime)
+	if err != nil {
+		return errors.Wrap(err, "updating public key share")
+	}
+
+	// pk' = pk + rho*G
+	pkG, err := shiftPublicKey(d.publicKeyGlobal, rho)
+	if err != nil {
+		return errors.Wrap(err, "updating global public key")
+	}
+
+	// C_k' = C_k + a_k*G
+	commitments, err := shiftCommitments(d.commitments, coeffs)
+	if err != nil {
+		return errors.Wrap(err, "updating commitments")
+	}
+
+	updated := *d
+	updated.secretKeyShare = skPrime
+	updated.publicKeyShare = pkPrime
+	updated.publicKeyGlobal = pkG
+	updated.commitments = commitments
+	if err := updated.VerifyShare(); err != nil {
+		return err
+	}
+	*d = updated
+
+	return nil
 }
 
 // KeyPair returns the device's key pair.
@@ -97,13 +127,69 @@ func (d *Device) KeyPair() (SecretKeyShare, PublicKeyShare) {
 	return d.secretKeyShare, d.publicKeyShare
 }
 
-// Computes ak_i = H(rho || i)
-func computeCoefficient(rho curves.Element, index int, field *curves.Field) *curves.Element {
-	akiBytes := sha3.Sum256(append(rho.Bytes(), []byte{byte(index)}...))
-	curve := curves.K256()
-	scalar, err := curve.Scalar.SetBytes(akiBytes[:])
-	println(scalar)
-	println(err)
+// PublicKey returns the global public key of the node shared among the devices.
+func (d *Device) PublicKey() PublicKey {
+	return d.publicKeyGlobal
+}
+
+// ChainCode returns the chain code of the node shared among the devices.
+func (d *Device) ChainCode() []byte {
+	return d.state.chainCode
+}
 
-	return field.ElementFromBytes(akiBytes[:])
+// Identifier returns the identifier of the device's share, i.e. the x-coordinate of the share.
+func (d *Device) Identifier() uint32 {
+	return d.secretKeyShare.Identifier
+}
+
+// Threshold returns the number of devices needed to use the shared key.
+func (d *Device) Threshold() uint32 {
+	return d.t
+}
+
+// Parties returns the number of devices sharing the key.
+func (d *Device) Parties() uint32 {
+	return d.n
+}
+
+// randPolynomial returns the coefficients of the rerandomization polynomial F defined by rho.
+func randPolynomial(rho *curves.Element, t uint32) []*curves.Element {
+	coeffs := make([]*curves.Element, t)
+	coeffs[0] = rho
+	for k := uint32(1); k < t; k++ {
+		coeffs[k] = computeCoefficient(rho, k)
+	}
+	return coeffs
+}
+
+// Computes ak_i = H(rho || i) reduced modulo the group order.
+func computeCoefficient(rho *curves.Element, index uint32) *curves.Element {
+	idxBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(idxBytes, index)
+
+	rhoBytes := make([]byte, 32)
+	rho.BigInt().FillBytes(rhoBytes)
+
+	akiBytes := sha3.Sum256(append(rhoBytes, idxBytes...))
+	return rho.Field().ReducedElementFromBytes(akiBytes[:])
+}
+
+// shiftPublicKeyShare returns pk + delta*G.
+func shiftPublicKeyShare(pk PublicKeyShare, delta *curves.Element) (PublicKeyShare, error) {
+	deltaG, err := curves.NewScalarBaseMult(curve, delta.BigInt())
+	if err != nil {
+		return nil, err
+	}
+	return (*curves.EcPoint)(pk).Add(deltaG)
+}
+
+// shiftPublicKey returns pk + delta*G.
+func shiftPublicKey(pk PublicKey, delta *curves.Element) (PublicKey, error) {
+	k256 := curves.K256()
+	deltaScalar, err := k256.Scalar.SetBigInt(delta.BigInt())
+	if err != nil {
+		return nil, err
+	}
+	shifted := (*pk).Add(k256.ScalarBaseMult(deltaScalar))
+	return &shifted, nil
 }
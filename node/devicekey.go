@@ -0,0 +1,43 @@
+package node
+
+import (
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/encrypt/ecies"
+)
+
+// DeviceKey returns the public Ed25519 key of the device, which identifies the device towards the other devices and
+// which messages to the device are encrypted to.
+func (d *Device) DeviceKey() kyber.Point {
+	return suite.Point().Mul(d.privkey, nil)
+}
+
+// UnmarshalDeviceKey decodes a public device key as encoded by its MarshalBinary method.
+func UnmarshalDeviceKey(data []byte) (kyber.Point, error) {
+	pubkey := suite.Point()
+	if err := pubkey.UnmarshalBinary(data); err != nil {
+		return nil, errors.Wrap(err, "decoding device key")
+	}
+	return pubkey, nil
+}
+
+// EncryptTo encrypts the message to the device with the given public device key using ECIES.
+func EncryptTo(pubkey kyber.Point, msg []byte) ([]byte, error) {
+	return ecies.Encrypt(suite, pubkey, msg, sha256.New)
+}
+
+// Decrypt decrypts a message encrypted to the device with EncryptTo.
+func (d *Device) Decrypt(ciphertext []byte) ([]byte, error) {
+	return decrypt(d.privkey, ciphertext)
+}
+
+// decrypt decrypts a message encrypted with EncryptTo to the public key of the given private device key.
+func decrypt(privkey kyber.Scalar, ciphertext []byte) ([]byte, error) {
+	msg, err := ecies.Decrypt(suite, privkey, ciphertext, sha256.New)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting message")
+	}
+	return msg, nil
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.dedis.ch/dela/crypto"
+	"go.dedis.ch/dela/crypto/ed25519"
+	"go.dedis.ch/dela/dkg/pedersen"
+	"go.dedis.ch/dela/dkg/pedersen/types"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/kyber/v3"
+)
+
+// DKG runs dela's Pedersen DKG on the overlay of a device with the device key as the long-term key of the device, so
+// that the DKG is set up with the collective authority of the device keys instead of keys of its own.
+type DKG struct {
+	rpc mino.RPC
+}
+
+// ListenDKG registers the DKG handler of the device on its overlay. It must be called on every device participating in
+// a DKG before the DKG is set up.
+func (d *Device) ListenDKG() (*DKG, error) {
+	if d.mino == nil {
+		return nil, errors.Errorf("device %d has no overlay", d.deviceIdx)
+	}
+	rpc, err := d.mino.CreateRPC("dkg", pedersen.NewHandler(d.privkey, d.mino.GetAddress()), d.factory)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating DKG RPC")
+	}
+	return &DKG{rpc: rpc}, nil
+}
+
+// Setup runs the DKG among the devices of the authority with the given threshold and returns the distributed public
+// key, which all devices must agree on.
+func (g *DKG) Setup(ctx context.Context, ca crypto.CollectiveAuthority, threshold int) (kyber.Point, error) {
+	if threshold < 1 || threshold > ca.Len() {
+		return nil, errors.Errorf("threshold %d is not between 1 and %d", threshold, ca.Len())
+	}
+
+	addrs := make([]mino.Address, 0, ca.Len())
+	pubkeys := make([]kyber.Point, 0, ca.Len())
+	addrIter, pubkeyIter := ca.AddressIterator(), ca.PublicKeyIterator()
+	for addrIter.HasNext() && pubkeyIter.HasNext() {
+		addrs = append(addrs, addrIter.GetNext())
+		pubkey, ok := pubkeyIter.GetNext().(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("public key of %s is not an Ed25519 key", addrs[len(addrs)-1])
+		}
+		pubkeys = append(pubkeys, pubkey.GetPoint())
+	}
+
+	sender, receiver, err := g.rpc.Stream(ctx, ca)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening DKG stream")
+	}
+	if err := <-sender.Send(types.NewStart(threshold, addrs, pubkeys), addrs...); err != nil {
+		return nil, errors.Wrap(err, "starting DKG")
+	}
+
+	var distKey kyber.Point
+	for range addrs {
+		addr, msg, err := receiver.Recv(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "waiting for DKG")
+		}
+		done, ok := msg.(types.StartDone)
+		if !ok {
+			return nil, errors.Errorf("unexpected DKG message of type %T from %s", msg, addr)
+		}
+		if distKey != nil && !distKey.Equal(done.GetPublicKey()) {
+			return nil, errors.Errorf("%s computed another distributed public key", addr)
+		}
+		distKey = done.GetPublicKey()
+	}
+	return distKey, nil
+}
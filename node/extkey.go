@@ -0,0 +1,105 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// maxDepth is the maximum depth of a node, as BIP32 serializes the depth in a single byte.
+const maxDepth = 255
+
+// XPub returns the BIP32 extended public key of the node shared among the devices. Its String method yields the
+// Base58Check encoding, which bip32.B58Deserialize parses.
+func (d *Device) XPub() (*bip32.Key, error) {
+	if d.publicKeyGlobal == nil {
+		return nil, errors.New("device has no public key")
+	}
+	return d.state.extendedKey((*d.publicKeyGlobal).ToAffineCompressed(), false, false)
+}
+
+// Depth returns the depth of the node shared among the devices in the derivation tree.
+func (d *Device) Depth() uint8 {
+	return d.state.depth
+}
+
+// HardenedChild creates the node resulting from the hardened derivation of the child with the given index of the node
+// shared among the devices.
+func (d *Device) HardenedChild(childIdx uint32, chainCode []byte, sk SecretKey, pk PublicKey) (Node, error) {
+	if d.state.depth == maxDepth {
+		return Node{}, errors.New("maximum depth of the derivation tree reached")
+	}
+	parentFingerprint, err := fingerprint(d.publicKeyGlobal)
+	if err != nil {
+		return Node{}, err
+	}
+
+	child := NewNode(childIdx, chainCode, sk, pk)
+	child.state.depth = d.state.depth + 1
+	child.state.parentFingerprint = parentFingerprint
+	return child, nil
+}
+
+// XPub returns the BIP32 extended public key of the hardened node.
+func (n *Node) XPub() (*bip32.Key, error) {
+	if n.PublicKey == nil {
+		return nil, errors.New("node has no public key")
+	}
+	return n.state.extendedKey((*n.PublicKey).ToAffineCompressed(), false, true)
+}
+
+// XPrv returns the BIP32 extended private key of the hardened node.
+func (n *Node) XPrv() (*bip32.Key, error) {
+	if n.secretKey == nil {
+		return nil, errors.New("node has no secret key")
+	}
+	return n.state.extendedKey((*n.secretKey).Bytes(), true, true)
+}
+
+// extendedKey serializes the state together with the given key, i.e. the 32-byte secret key or the compressed public
+// key. The child number of hardened nodes has the hardened bit set.
+func (s State) extendedKey(key []byte, private, hardened bool) (*bip32.Key, error) {
+	if len(s.chainCode) != 32 {
+		return nil, errors.New("node has no valid chain code")
+	}
+
+	childNumber := make([]byte, 4)
+	if hardened {
+		binary.BigEndian.PutUint32(childNumber, s.nodeIdx|bip32.FirstHardenedChild)
+	} else {
+		binary.BigEndian.PutUint32(childNumber, s.nodeIdx)
+	}
+	parentFingerprint := s.parentFingerprint
+	if parentFingerprint == nil {
+		parentFingerprint = make([]byte, 4)
+	}
+
+	version := bip32.PublicWalletVersion
+	if private {
+		version = bip32.PrivateWalletVersion
+	}
+
+	return &bip32.Key{
+		Version:     version,
+		Depth:       s.depth,
+		ChildNumber: childNumber,
+		FingerPrint: append([]byte(nil), parentFingerprint...),
+		ChainCode:   append([]byte(nil), s.chainCode...),
+		Key:         key,
+		IsPrivate:   private,
+	}, nil
+}
+
+// fingerprint computes the first 4 bytes of HASH160 of the compressed public key, which identifies a node as parent.
+func fingerprint(pk PublicKey) ([]byte, error) {
+	if pk == nil {
+		return nil, errors.New("missing public key")
+	}
+	sha := sha256.Sum256((*pk).ToAffineCompressed())
+	h := ripemd160.New()
+	h.Write(sha[:])
+	return h.Sum(nil)[:4], nil
+}
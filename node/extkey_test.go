@@ -0,0 +1,47 @@
+package node_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestXPub(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	master, err := node.ExportXPrv(devices)
+	require.NoError(t, err)
+
+	xpub, err := devices[0].XPub()
+	require.NoError(t, err)
+	assert.Equal(t, master.PublicKey().String(), xpub.String())
+
+	// Descendants must serialize exactly like go-bip32 keys, including depth and parent fingerprint.
+	expected := master
+	for _, idx := range []uint32{7, 0, 42} {
+		for i := range devices {
+			devices[i], err = devices[i].DeriveNonHardenedChild(idx)
+			require.NoError(t, err)
+		}
+		expected, err = expected.NewChildKey(idx)
+		require.NoError(t, err)
+
+		xpub, err = devices[1].XPub()
+		require.NoError(t, err)
+		assert.Equal(t, expected.PublicKey().String(), xpub.String())
+
+		xprv, err := node.ExportXPrv(devices[1:])
+		require.NoError(t, err)
+		assert.Equal(t, expected.String(), xprv.String())
+	}
+	assert.Equal(t, uint8(3), devices[0].Depth())
+
+	parsed, err := bip32.B58Deserialize(xpub.String())
+	require.NoError(t, err)
+	assert.False(t, parsed.IsPrivate)
+	assert.Equal(t, (*devices[2].PublicKey()).ToAffineCompressed(), parsed.Key)
+}
@@ -6,14 +6,16 @@ import (
 	"encoding/hex"
 
 	"github.com/coinbase/kryptology/pkg/core/curves"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
 	"github.com/coinbase/kryptology/pkg/tecdsa/gg20/dealer"
 )
 
 const seed = "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc7a1c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542"
 
 // GenSharedKey generates a threshold shared secret key.
-// It outputs n public key shares, n secret key shares, and the global public key.
-func GenSharedKey(t uint32, n uint32) (map[uint32]*dealer.PublicShare, map[uint32]*dealer.Share, *curves.Point) {
+// It outputs n public key shares, n secret key shares, the global public key, and the Feldman commitments to the
+// coefficients of the sharing polynomial, which allow every device to verify its share.
+func GenSharedKey(t uint32, n uint32) (map[uint32]*dealer.PublicShare, map[uint32]*dealer.Share, *curves.Point, []*curves.EcPoint) {
 	k256, err := curves.K256().ToEllipticCurve()
 	if err != nil {
 		panic(err)
@@ -23,11 +25,27 @@ func GenSharedKey(t uint32, n uint32) (map[uint32]*dealer.PublicShare, map[uint3
 	if err != nil {
 		panic(err)
 	}
-	pkEc, sharesMap, err := dealer.NewDealerShares(k256, t, n, secret)
+	feldman, err := v1.NewFeldman(t, n, k256)
 	if err != nil {
 		panic(err)
 	}
-	pk, err := curves.K256().Point.Set(pkEc.X, pkEc.Y)
+	commitments, shares, err := feldman.Split(secret.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	sharesMap := make(map[uint32]*dealer.Share, n)
+	for _, s := range shares {
+		publicShare, err := curves.NewScalarBaseMult(k256, s.Value.BigInt())
+		if err != nil {
+			panic(err)
+		}
+		sharesMap[s.Identifier] = &dealer.Share{
+			ShamirShare: s,
+			Point:       publicShare,
+		}
+	}
+	pk, err := curves.K256().Point.Set(commitments[0].X, commitments[0].Y)
 	if err != nil {
 		panic(err)
 	}
@@ -41,7 +59,7 @@ func GenSharedKey(t uint32, n uint32) (map[uint32]*dealer.PublicShare, map[uint3
 	//	fmt.Printf("Share: %x\n", sharesMap[i].Bytes())
 	//}
 
-	return pubSharesMap, sharesMap, &pk
+	return pubSharesMap, sharesMap, &pk, commitments
 }
 
 func NewMasterChainCode() ([]byte, error) {
@@ -0,0 +1,369 @@
+package node
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/pkg/errors"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/kyber/v3"
+	"golang.org/x/crypto/argon2"
+)
+
+// KeystoreVersion is the version of the keystore format written by SaveKeystore.
+const KeystoreVersion = 2
+
+const keystoreKDF = "argon2id"
+
+// ErrWrongPassword is returned if a keystore cannot be decrypted, either because the password is wrong or because the
+// keystore has been tampered with.
+var ErrWrongPassword = errors.New("wrong password or corrupted keystore")
+
+// KDFParams are the Argon2id parameters used to derive the encryption key of a keystore from the password.
+type KDFParams struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // In KiB.
+	Threads uint8  `json:"threads"`
+}
+
+// DefaultKDFParams are the recommended Argon2id parameters of RFC 9106 for memory-constrained environments.
+var DefaultKDFParams = KDFParams{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// Bounds of the key derivation parameters read from a keystore, so that a crafted keystore cannot exhaust the memory or
+// the time of the device before the password is checked. The parameters may be at most four times DefaultKDFParams.
+const (
+	minSaltSize   = 16
+	maxSaltSize   = 64
+	maxKDFTime    = 4 * 3
+	maxKDFMemory  = 4 * 64 * 1024
+	maxKDFThreads = 4 * 4
+)
+
+// keystoreHeader is the unencrypted part of a keystore. It is authenticated as additional data of AES-GCM.
+type keystoreHeader struct {
+	Version   uint32    `json:"version"`
+	KDF       string    `json:"kdf"`
+	KDFParams KDFParams `json:"kdfParams"`
+	Nonce     []byte    `json:"nonce"`
+}
+
+type keystoreFile struct {
+	keystoreHeader
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// deviceRecord is the plaintext of a keystore.
+type deviceRecord struct {
+	DeviceIdx         int      `json:"deviceIdx"`
+	T                 uint32   `json:"t"`
+	N                 uint32   `json:"n"`
+	Identifier        uint32   `json:"identifier"`
+	SecretKeyShare    []byte   `json:"secretKeyShare"`
+	PublicKeyShare    []byte   `json:"publicKeyShare"`
+	PublicKeyGlobal   []byte   `json:"publicKeyGlobal"`
+	Commitments       [][]byte `json:"commitments"`
+	Epoch             uint64   `json:"epoch"`
+	NodeIdx           uint32   `json:"nodeIdx"`
+	ChainCode         []byte   `json:"chainCode"`
+	Depth             uint8    `json:"depth"`
+	ParentFingerprint []byte   `json:"parentFingerprint"`
+	PrivKey           []byte   `json:"privkey"`
+}
+
+// keystoreMigrations upgrade the plaintext of a keystore of the version given as key to the next version.
+// Whenever the format changes, KeystoreVersion is increased and a migration from the previous version is added here.
+var keystoreMigrations = map[uint32]func(plaintext []byte) ([]byte, error){
+	// Version 1 did not store the depth and the parent fingerprint, which are set to those of a master node.
+	1: func(plaintext []byte) ([]byte, error) {
+		var record map[string]interface{}
+		if err := json.Unmarshal(plaintext, &record); err != nil {
+			return nil, err
+		}
+		record["depth"] = 0
+		record["parentFingerprint"] = make([]byte, 4)
+		return json.Marshal(record)
+	},
+}
+
+// MarshalKeystore encrypts the device's key material with a key derived from the password using the given Argon2id
+// parameters. A fresh salt is used if none is given.
+func (d *Device) MarshalKeystore(password []byte, params KDFParams) ([]byte, error) {
+	record, err := d.record()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding device")
+	}
+	defer wipeBytes(plaintext)
+
+	if len(params.Salt) == 0 {
+		params.Salt = make([]byte, 16)
+		if _, err := rand.Read(params.Salt); err != nil {
+			return nil, errors.Wrap(err, "sampling salt")
+		}
+	}
+	header := keystoreHeader{
+		Version:   KeystoreVersion,
+		KDF:       keystoreKDF,
+		KDFParams: params,
+	}
+
+	aead, err := newKeystoreAEAD(password, params)
+	if err != nil {
+		return nil, err
+	}
+	header.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(header.Nonce); err != nil {
+		return nil, errors.Wrap(err, "sampling nonce")
+	}
+	ad, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(keystoreFile{
+		keystoreHeader: header,
+		Ciphertext:     aead.Seal(nil, header.Nonce, plaintext, ad),
+	}, "", "  ")
+}
+
+// UnmarshalKeystore decrypts a keystore, migrates it to the current version if needed, and restores the device.
+// The restored share is checked using VerifyShare.
+func UnmarshalKeystore(data []byte, password []byte, m mino.Mino) (Device, kyber.Point, error) {
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Device{}, nil, errors.Wrap(err, "decoding keystore")
+	}
+	if file.Version == 0 || file.Version > KeystoreVersion {
+		return Device{}, nil, errors.Errorf("unsupported keystore version %d", file.Version)
+	}
+	if file.KDF != keystoreKDF {
+		return Device{}, nil, errors.Errorf("unsupported key derivation function %q", file.KDF)
+	}
+
+	aead, err := newKeystoreAEAD(password, file.KDFParams)
+	if err != nil {
+		return Device{}, nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return Device{}, nil, errors.New("invalid nonce")
+	}
+	ad, err := json.Marshal(file.keystoreHeader)
+	if err != nil {
+		return Device{}, nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, ad)
+	if err != nil {
+		return Device{}, nil, ErrWrongPassword
+	}
+
+	for v := file.Version; v < KeystoreVersion; v++ {
+		migrate, ok := keystoreMigrations[v]
+		if !ok {
+			return Device{}, nil, errors.Errorf("no migration from keystore version %d", v)
+		}
+		migrated, err := migrate(plaintext)
+		wipeBytes(plaintext)
+		if err != nil {
+			return Device{}, nil, errors.Wrapf(err, "migrating keystore from version %d", v)
+		}
+		plaintext = migrated
+	}
+	defer wipeBytes(plaintext)
+
+	var record deviceRecord
+	if err := json.Unmarshal(plaintext, &record); err != nil {
+		return Device{}, nil, errors.Wrap(err, "decoding device")
+	}
+	defer wipeBytes(record.SecretKeyShare)
+	defer wipeBytes(record.PrivKey)
+
+	return record.device(m)
+}
+
+// SaveKeystore writes the encrypted keystore of the device to the given path, readable by the owner only.
+func (d *Device) SaveKeystore(path string, password []byte, params KDFParams) error {
+	data, err := d.MarshalKeystore(password, params)
+	if err != nil {
+		return err
+	}
+
+	return WriteSecretFile(path, data)
+}
+
+// LoadKeystore reads and decrypts the keystore at the given path.
+func LoadKeystore(path string, password []byte, m mino.Mino) (Device, kyber.Point, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Device{}, nil, errors.Wrap(err, "reading keystore")
+	}
+	return UnmarshalKeystore(data, password, m)
+}
+
+// StorageKey derives a 32-byte key for encrypting further secret state of the device at rest, e.g. presignatures.
+// The key is bound to the device key, which is stored in the keystore, and separated by the given label.
+func (d *Device) StorageKey(label string) ([]byte, error) {
+	privkey, err := d.privkey.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding device key")
+	}
+	defer wipeBytes(privkey)
+
+	mac := hmac.New(sha256.New, privkey)
+	mac.Write([]byte(label))
+	return mac.Sum(nil), nil
+}
+
+// WriteSecretFile writes secret data to the given path, readable by the owner only. The data is written to a temporary
+// file first, so that an existing file is never left half-written.
+func WriteSecretFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "creating file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "setting file permissions")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "writing file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "writing file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "writing file")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), path), "replacing file")
+}
+
+func newKeystoreAEAD(password []byte, params KDFParams) (cipher.AEAD, error) {
+	if len(params.Salt) < minSaltSize || len(params.Salt) > maxSaltSize {
+		return nil, errors.Errorf("salt has %d bytes, expected %d to %d", len(params.Salt), minSaltSize, maxSaltSize)
+	}
+	if params.Time == 0 || params.Time > maxKDFTime || params.Memory == 0 || params.Memory > maxKDFMemory ||
+		params.Threads == 0 || params.Threads > maxKDFThreads {
+		return nil, errors.Errorf("invalid key derivation parameters: time %d, memory %d KiB, %d threads",
+			params.Time, params.Memory, params.Threads)
+	}
+
+	key := argon2.IDKey(password, params.Salt, params.Time, params.Memory, params.Threads, 32)
+	defer wipeBytes(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (d *Device) record() (*deviceRecord, error) {
+	if err := d.CheckShare(); err != nil {
+		return nil, err
+	}
+	if d.publicKeyShare == nil || d.publicKeyGlobal == nil {
+		return nil, errors.Errorf("device %d has no key share", d.deviceIdx)
+	}
+
+	privkey, err := d.privkey.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding device key")
+	}
+	commitments := make([][]byte, len(d.commitments))
+	for k, c := range d.commitments {
+		commitments[k] = c.Bytes()
+	}
+	sk := make([]byte, 32)
+	d.secretKeyShare.Value.BigInt().FillBytes(sk)
+
+	return &deviceRecord{
+		DeviceIdx:         d.deviceIdx,
+		T:                 d.t,
+		N:                 d.n,
+		Identifier:        d.secretKeyShare.Identifier,
+		SecretKeyShare:    sk,
+		PublicKeyShare:    (*curves.EcPoint)(d.publicKeyShare).Bytes(),
+		PublicKeyGlobal:   (*d.publicKeyGlobal).ToAffineCompressed(),
+		Commitments:       commitments,
+		Epoch:             d.epoch,
+		NodeIdx:           d.state.nodeIdx,
+		ChainCode:         d.state.chainCode,
+		Depth:             d.state.depth,
+		ParentFingerprint: d.state.parentFingerprint,
+		PrivKey:           privkey,
+	}, nil
+}
+
+func (r *deviceRecord) device(m mino.Mino) (Device, kyber.Point, error) {
+	field := curves.NewField(curve.Params().N)
+	skInt := new(big.Int).SetBytes(r.SecretKeyShare)
+	if !field.IsValid(skInt) {
+		return Device{}, nil, errors.New("invalid secret key share")
+	}
+	sk := &v1.ShamirShare{
+		Identifier: r.Identifier,
+		Value:      field.NewElement(skInt),
+	}
+
+	pk, err := curves.PointFromBytesUncompressed(curve, r.PublicKeyShare)
+	if err != nil {
+		return Device{}, nil, errors.Wrap(err, "decoding public key share")
+	}
+	pkG, err := curves.K256().Point.FromAffineCompressed(r.PublicKeyGlobal)
+	if err != nil {
+		return Device{}, nil, errors.Wrap(err, "decoding global public key")
+	}
+	commitments := make([]*curves.EcPoint, len(r.Commitments))
+	for k, c := range r.Commitments {
+		if commitments[k], err = curves.PointFromBytesUncompressed(curve, c); err != nil {
+			return Device{}, nil, errors.Wrapf(err, "decoding commitment %d", k)
+		}
+	}
+
+	privkey := suite.Scalar()
+	if err := privkey.UnmarshalBinary(r.PrivKey); err != nil {
+		return Device{}, nil, errors.Wrap(err, "decoding device key")
+	}
+
+	device, _ := NewDevice(r.DeviceIdx, r.T, r.N, pk, sk, &pkG, commitments, r.NodeIdx, r.ChainCode, m)
+	device.privkey = privkey
+	device.epoch = r.Epoch
+	device.state.depth = r.Depth
+	if len(r.ParentFingerprint) != 4 {
+		return Device{}, nil, errors.New("invalid parent fingerprint")
+	}
+	device.state.parentFingerprint = r.ParentFingerprint
+	if err := device.VerifyShare(); err != nil {
+		return Device{}, nil, err
+	}
+
+	return device, suite.Point().Mul(privkey, nil), nil
+}
+
+// wipeBytes overwrites key material that is no longer needed.
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,120 @@
+package node_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+// Cheap parameters to keep the tests fast.
+var testKDFParams = node.KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+func TestKeystore(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	require.NoError(t, node.RefreshDevices(devices))
+	password := []byte("correct horse battery staple")
+
+	path := filepath.Join(t.TempDir(), "device0.json")
+	require.NoError(t, devices[0].SaveKeystore(path, password, testKDFParams))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	loaded, _, err := node.LoadKeystore(path, password, nil)
+	require.NoError(t, err)
+	assert.Equal(t, devices[0].Epoch(), loaded.Epoch())
+	assert.True(t, (*loaded.PublicKey()).Equal(*devices[0].PublicKey()))
+
+	sk, err := node.ReconstructSecretKey([]node.Device{loaded, devices[1]})
+	require.NoError(t, err)
+	assert.NotNil(t, sk)
+
+	t.Run("Wrong password", func(t *testing.T) {
+		_, _, err := node.LoadKeystore(path, []byte("wrong"), nil)
+		assert.ErrorIs(t, err, node.ErrWrongPassword)
+	})
+
+	t.Run("Tampered header", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var file map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &file))
+		file["kdfParams"].(map[string]interface{})["time"] = 2
+		data, err = json.Marshal(file)
+		require.NoError(t, err)
+
+		_, _, err = node.UnmarshalKeystore(data, password, nil)
+		assert.ErrorIs(t, err, node.ErrWrongPassword)
+	})
+
+	t.Run("Excessive key derivation parameters", func(t *testing.T) {
+		for _, params := range []node.KDFParams{
+			{Time: 1, Memory: 1 << 30, Threads: 1},
+			{Time: 1 << 20, Memory: 1024, Threads: 1},
+			{Time: 1, Memory: 1024, Threads: 255},
+			{Salt: make([]byte, 4), Time: 1, Memory: 1024, Threads: 1},
+		} {
+			data, err := devices[1].MarshalKeystore(password, testKDFParams)
+			require.NoError(t, err)
+
+			var file map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &file))
+			kdf := file["kdfParams"].(map[string]interface{})
+			kdf["time"], kdf["memory"], kdf["threads"] = params.Time, params.Memory, params.Threads
+			if params.Salt != nil {
+				kdf["salt"] = params.Salt
+			}
+			data, err = json.Marshal(file)
+			require.NoError(t, err)
+
+			_, _, err = node.UnmarshalKeystore(data, password, nil)
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, node.ErrWrongPassword)
+		}
+	})
+
+	t.Run("Unsupported version", func(t *testing.T) {
+		data, err := devices[1].MarshalKeystore(password, testKDFParams)
+		require.NoError(t, err)
+
+		var file map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &file))
+		file["version"] = node.KeystoreVersion + 1
+		data, err = json.Marshal(file)
+		require.NoError(t, err)
+
+		_, _, err = node.UnmarshalKeystore(data, password, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestKeystoreMigration(t *testing.T) {
+	// A keystore of version 1, which did not store the depth and the parent fingerprint.
+	device, _, err := node.LoadKeystore(filepath.Join("testdata", "keystore-v1.json"), []byte("correct horse battery staple"), nil)
+	require.NoError(t, err)
+
+	pk, err := hex.DecodeString("03b7d21399baeb210543ee949fd58144fcf07ca807808bf1c3cfa62820c419a25a")
+	require.NoError(t, err)
+	chainCode, err := hex.DecodeString("54c2c8a105e42ece6b32811180d9cdc8a3af7c1bbc6f5a00532bf570a40c8ff2")
+	require.NoError(t, err)
+	assert.Equal(t, pk, (*device.PublicKey()).ToAffineCompressed())
+	assert.Equal(t, chainCode, device.ChainCode())
+	assert.Equal(t, uint32(1), device.Identifier())
+	assert.Equal(t, uint32(2), device.Threshold())
+	assert.Equal(t, uint64(1), device.Epoch())
+	assert.Equal(t, uint8(0), device.Depth())
+
+	xpub, err := device.XPub()
+	require.NoError(t, err)
+	assert.Equal(t, make([]byte, 4), xpub.FingerPrint)
+}
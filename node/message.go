@@ -0,0 +1,164 @@
+package node
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+)
+
+// messageDomain separates signatures on protocol messages from other uses of the device keys.
+const messageDomain = "bip32-threshold-wallet/message/v1"
+
+// SessionSize is the size of session identifiers and message nonces in bytes.
+const SessionSize = 16
+
+// SignedMessage is a protocol message exchanged between devices, such as a derivation request, a partial evaluation
+// or a refresh deal. It is signed with the Ed25519 device key of its sender and bound to the protocol session it
+// belongs to and to its recipient. The nonce and the time let receivers reject replayed messages.
+type SignedMessage struct {
+	Op        string `json:"op"`              // Protocol step the message belongs to.
+	Sender    uint32 `json:"sender"`          // Share identifier of the sending device.
+	Session   []byte `json:"session"`         // Identifier of the protocol session chosen by the coordinating device.
+	Round     uint32 `json:"round,omitempty"` // Round of the session for protocols run with a Session.
+	Recipient uint32 `json:"recipient"`       // Share identifier of the addressed device or Broadcast.
+	Nonce     []byte `json:"nonce"`
+	Time      int64  `json:"time"` // Creation time in nanoseconds since the Unix epoch.
+	Body      []byte `json:"body,omitempty"`
+	Signature []byte `json:"signature"`
+}
+
+// NewSessionID returns a random session identifier.
+func NewSessionID() ([]byte, error) {
+	session := make([]byte, SessionSize)
+	if _, err := rand.Read(session); err != nil {
+		return nil, errors.Wrap(err, "sampling session identifier")
+	}
+	return session, nil
+}
+
+// SignMessage creates a message of the given session for the device holding the share with the given identifier, or
+// for all devices if it is Broadcast, with a fresh nonce and signs it with the device key.
+func (d *Device) SignMessage(op string, session []byte, recipient uint32, body []byte) (*SignedMessage, error) {
+	return d.SignRoundMessage(op, session, 0, recipient, body)
+}
+
+// SignRoundMessage creates a message of the given round of a session, see SignMessage.
+func (d *Device) SignRoundMessage(op string, session []byte, round, recipient uint32, body []byte) (*SignedMessage, error) {
+	if len(session) != SessionSize {
+		return nil, errors.Errorf("session identifier has %d bytes, expected %d", len(session), SessionSize)
+	}
+	nonce := make([]byte, SessionSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "sampling nonce")
+	}
+
+	m := &SignedMessage{
+		Op:        op,
+		Sender:    d.Identifier(),
+		Session:   session,
+		Round:     round,
+		Recipient: recipient,
+		Nonce:     nonce,
+		Time:      time.Now().UnixNano(),
+		Body:      body,
+	}
+	sig, err := schnorr.Sign(suite, d.privkey, m.signedData())
+	if err != nil {
+		return nil, errors.Wrap(err, "signing message")
+	}
+	m.Signature = sig
+	return m, nil
+}
+
+// signedData returns the unambiguous encoding of all fields of the message but the signature.
+func (m *SignedMessage) signedData() []byte {
+	var buf bytes.Buffer
+	write := func(field []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	write([]byte(messageDomain))
+	write([]byte(m.Op))
+	binary.Write(&buf, binary.BigEndian, m.Sender)
+	write(m.Session)
+	binary.Write(&buf, binary.BigEndian, m.Round)
+	binary.Write(&buf, binary.BigEndian, m.Recipient)
+	write(m.Nonce)
+	binary.Write(&buf, binary.BigEndian, m.Time)
+	write(m.Body)
+	return buf.Bytes()
+}
+
+// VerifyWith verifies the signature of the message against the given device key.
+func (m *SignedMessage) VerifyWith(deviceKey kyber.Point) error {
+	if len(m.Session) != SessionSize || len(m.Nonce) != SessionSize {
+		return errors.Errorf("%s message of device %d has an invalid session identifier or nonce", m.Op, m.Sender)
+	}
+	pubkey, err := deviceKey.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "encoding device key")
+	}
+	if err := schnorr.VerifyWithChecks(suite, pubkey, m.signedData(), m.Signature); err != nil {
+		return errors.Wrapf(err, "verifying %s message of device %d", m.Op, m.Sender)
+	}
+	return nil
+}
+
+// Roster maps the share identifiers of the devices of a committee to their public device keys.
+type Roster map[uint32]kyber.Point
+
+// Verify checks that the sender of the message is in the roster and that the message is signed with its device key.
+func (r Roster) Verify(m *SignedMessage) error {
+	deviceKey, ok := r[m.Sender]
+	if !ok {
+		return errors.Errorf("device %d is not in the roster", m.Sender)
+	}
+	return m.VerifyWith(deviceKey)
+}
+
+// ReplayGuard rejects messages that have been seen before or that are too old to be checked. It remembers the nonces
+// of the messages within a window around the current time, so that its memory is bounded by the message rate.
+type ReplayGuard struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+// NewReplayGuard creates a replay guard accepting messages whose time differs by at most the window from the time
+// they are checked at. The window bounds the tolerated clock skew and delay between devices.
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Check records the message and fails if it is outside the window or if it has been recorded before. Messages should
+// only be checked once their signature has been verified.
+func (g *ReplayGuard) Check(m *SignedMessage, now time.Time) error {
+	sent := time.Unix(0, m.Time)
+	if sent.Before(now.Add(-g.window)) || sent.After(now.Add(g.window)) {
+		return errors.Errorf("%s message of device %d is outside the replay window", m.Op, m.Sender)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, t := range g.seen {
+		if t.Before(now.Add(-g.window)) {
+			delete(g.seen, key)
+		}
+	}
+	key := string(binary.BigEndian.AppendUint32(nil, m.Sender)) + string(m.Session) + string(m.Nonce)
+	if _, ok := g.seen[key]; ok {
+		return errors.Errorf("%s message of device %d in session %s is replayed", m.Op, m.Sender, hex.EncodeToString(m.Session))
+	}
+	g.seen[key] = sent
+	return nil
+}
@@ -0,0 +1,61 @@
+package node_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestSignedMessages(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	roster := node.Roster{}
+	for i := range devices[:2] {
+		roster[devices[i].Identifier()] = devices[i].DeviceKey()
+	}
+	session, err := node.NewSessionID()
+	require.NoError(t, err)
+
+	m, err := devices[0].SignMessage("evaluate", session, devices[1].Identifier(), []byte("body"))
+	require.NoError(t, err)
+	assert.NoError(t, roster.Verify(m))
+
+	for name, tamper := range map[string]func(m *node.SignedMessage){
+		"operation": func(m *node.SignedMessage) { m.Op = "sign" },
+		"sender":    func(m *node.SignedMessage) { m.Sender = devices[1].Identifier() },
+		"session":   func(m *node.SignedMessage) { m.Session = make([]byte, node.SessionSize) },
+		"round":     func(m *node.SignedMessage) { m.Round = 1 },
+		"recipient": func(m *node.SignedMessage) { m.Recipient = devices[2].Identifier() },
+		"time":      func(m *node.SignedMessage) { m.Time++ },
+		"body":      func(m *node.SignedMessage) { m.Body = []byte("other") },
+	} {
+		t.Run("Reject tampered "+name, func(t *testing.T) {
+			tampered := *m
+			tamper(&tampered)
+			assert.Error(t, roster.Verify(&tampered))
+		})
+	}
+
+	t.Run("Reject devices outside the roster", func(t *testing.T) {
+		m, err := devices[2].SignMessage("evaluate", session, devices[1].Identifier(), nil)
+		require.NoError(t, err)
+		assert.Error(t, roster.Verify(m))
+		assert.NoError(t, m.VerifyWith(devices[2].DeviceKey()))
+	})
+
+	t.Run("Reject replays", func(t *testing.T) {
+		guard := node.NewReplayGuard(time.Minute)
+		now := time.Now()
+		require.NoError(t, guard.Check(m, now))
+		assert.Error(t, guard.Check(m, now))
+
+		other, err := devices[0].SignMessage("evaluate", session, devices[1].Identifier(), []byte("body"))
+		require.NoError(t, err)
+		assert.Error(t, guard.Check(other, now.Add(2*time.Minute)), "message is outside the window")
+		assert.NoError(t, guard.Check(other, now))
+	})
+}
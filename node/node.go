@@ -14,15 +14,18 @@ type Node struct {
 }
 
 type State struct {
-	nodeIdx   uint32 // Index of the node in the derivation tree.
-	chainCode []byte
+	nodeIdx           uint32 // Index of the node in the derivation tree, with the hardened bit only for devices.
+	chainCode         []byte
+	depth             uint8  // Depth of the node in the derivation tree, 0 for the master node.
+	parentFingerprint []byte // First 4 bytes of HASH160 of the parent's public key, zero for the master node.
 }
 
 func NewNode(index uint32, chainCode []byte, sk SecretKey, pk PublicKey) Node {
 	return Node{
 		state: State{
-			nodeIdx:   index,
-			chainCode: chainCode,
+			nodeIdx:           index,
+			chainCode:         chainCode,
+			parentFingerprint: make([]byte, 4),
 		},
 		secretKey: sk,
 		PublicKey: pk,
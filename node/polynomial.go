@@ -0,0 +1,77 @@
+package node
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+)
+
+// randomPolynomial samples a polynomial of the given degree with the given constant term.
+func randomPolynomial(constant *curves.Element, degree uint32) ([]*curves.Element, error) {
+	field := constant.Field()
+	coeffs := make([]*curves.Element, degree+1)
+	coeffs[0] = constant
+	for k := uint32(1); k <= degree; k++ {
+		c, err := field.RandomElement(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		coeffs[k] = c
+	}
+	return coeffs, nil
+}
+
+// evalPolynomial evaluates the polynomial with the given coefficients at x.
+func evalPolynomial(coeffs []*curves.Element, x uint32) *curves.Element {
+	field := coeffs[0].Field()
+	xElem := field.NewElement(big.NewInt(int64(x)))
+
+	result := field.Zero()
+	for k := len(coeffs) - 1; k >= 0; k-- {
+		result = result.Mul(xElem).Add(coeffs[k])
+	}
+	return result
+}
+
+// commitPolynomial computes the Feldman commitments a_k*G to the given coefficients.
+func commitPolynomial(coeffs []*curves.Element) ([]*curves.EcPoint, error) {
+	commitments := make([]*curves.EcPoint, len(coeffs))
+	for k, c := range coeffs {
+		p, err := curves.NewScalarBaseMult(curve, c.BigInt())
+		if err != nil {
+			return nil, errors.Wrapf(err, "committing to coefficient %d", k)
+		}
+		commitments[k] = p
+	}
+	return commitments, nil
+}
+
+// evalCommitments computes sum_k C_k * x^(k+offset), i.e. the commitment to the polynomial's evaluation at x.
+// The offset allows omitting the commitments to the constant and the low-order coefficients, i.e. the first offset
+// coefficients, if they are known to be zero.
+func evalCommitments(commitments []*curves.EcPoint, x uint32, offset int) (*curves.EcPoint, error) {
+	n := curve.Params().N
+	xInt := big.NewInt(int64(x))
+
+	var result *curves.EcPoint
+	for k, c := range commitments {
+		exp := new(big.Int).Exp(xInt, big.NewInt(int64(k+offset)), n)
+		term, err := c.ScalarMult(exp)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = term
+			continue
+		}
+		if result, err = result.Add(term); err != nil {
+			return nil, err
+		}
+	}
+	if result == nil {
+		return nil, errors.New("no commitments to evaluate")
+	}
+	return result, nil
+}
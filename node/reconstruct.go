@@ -0,0 +1,112 @@
+package node
+
+import (
+	"math/big"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+)
+
+// ReconstructSecretKey recovers the secret key shared among the given devices.
+// Every share is checked against the device's public key share before interpolation and the recovered key is checked
+// against the global public key, so that at least t honest devices are needed for the reconstruction to succeed.
+// It is meant as an offline emergency procedure when the threshold setup has to be retired.
+func ReconstructSecretKey(devices []Device) (SecretKey, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices given")
+	}
+
+	pkG := devices[0].publicKeyGlobal
+	shares := make([]*v1.ShamirShare, 0, len(devices))
+	seen := make(map[uint32]bool)
+	for _, d := range devices {
+		if err := d.CheckShare(); err != nil {
+			return nil, err
+		}
+		if d.publicKeyShare == nil {
+			return nil, errors.Errorf("device %d has no key share", d.deviceIdx)
+		}
+		if !(*d.publicKeyGlobal).Equal(*pkG) {
+			return nil, errors.Errorf("device %d uses a different global public key", d.deviceIdx)
+		}
+
+		share := (*v1.ShamirShare)(d.secretKeyShare)
+		if seen[share.Identifier] {
+			return nil, errors.Errorf("duplicate share with identifier %d", share.Identifier)
+		}
+		seen[share.Identifier] = true
+
+		if err := verifyShareAgainstPublicShare(share, d.publicKeyShare); err != nil {
+			return nil, errors.Wrapf(err, "device %d", d.deviceIdx)
+		}
+		shares = append(shares, share)
+	}
+
+	secret, err := interpolateAtZero(shares)
+	if err != nil {
+		return nil, errors.Wrap(err, "interpolating shares")
+	}
+
+	sk, err := curves.K256().Scalar.SetBigInt(secret.BigInt())
+	if err != nil {
+		return nil, errors.Wrap(err, "converting secret to scalar")
+	}
+	pk := curves.K256().ScalarBaseMult(sk)
+	if !pk.Equal(*pkG) {
+		return nil, errors.New("reconstructed secret key does not match the global public key")
+	}
+
+	return &sk, nil
+}
+
+// ExportXPrv reconstructs the secret key shared among the given devices and returns it as a BIP32 extended private
+// key, which can for instance be used with derivation.NewStandardBIP32DerivationFromKey.
+func ExportXPrv(devices []Device) (*bip32.Key, error) {
+	sk, err := ReconstructSecretKey(devices)
+	if err != nil {
+		return nil, err
+	}
+	return devices[0].state.extendedKey((*sk).Bytes(), true, false)
+}
+
+// verifyShareAgainstPublicShare checks that share*G equals the public key share.
+func verifyShareAgainstPublicShare(share *v1.ShamirShare, pk PublicKeyShare) error {
+	expected, err := curves.NewScalarBaseMult(curve, share.Value.BigInt())
+	if err != nil {
+		return errors.Wrap(err, "computing public key share")
+	}
+	if !expected.Equals((*curves.EcPoint)(pk)) {
+		return errors.Errorf("secret key share %d does not match its public key share", share.Identifier)
+	}
+	return nil
+}
+
+// interpolateAtZero computes the Lagrange interpolation of the given shares at x = 0.
+func interpolateAtZero(shares []*v1.ShamirShare) (*curves.Element, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares to interpolate")
+	}
+
+	field := shares[0].Value.Field()
+	result := field.Zero()
+	for i, si := range shares {
+		xi := field.NewElement(big.NewInt(int64(si.Identifier)))
+		basis := field.One()
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			xj := field.NewElement(big.NewInt(int64(sj.Identifier)))
+			denom := xj.Sub(xi)
+			if denom.IsEqual(field.Zero()) {
+				return nil, errors.New("invalid share identifiers")
+			}
+			basis = basis.Mul(xj.Div(denom))
+		}
+		result = result.Add(si.Value.Mul(basis))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,38 @@
+package node_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestExportXPrv(t *testing.T) {
+	devices := utils.CreateDevices(3, 5)
+
+	t.Run("Threshold many devices", func(t *testing.T) {
+		key, err := node.ExportXPrv(devices[1:4])
+		require.NoError(t, err)
+
+		pkG := devices[0].PublicKey()
+		assert.Equal(t, (*pkG).ToAffineCompressed(), key.PublicKey().Key)
+
+		parsed, err := bip32.B58Deserialize(key.B58Serialize())
+		require.NoError(t, err)
+		assert.Equal(t, key.Key, parsed.Key)
+	})
+
+	t.Run("Not enough devices", func(t *testing.T) {
+		_, err := node.ExportXPrv(devices[:2])
+		assert.Error(t, err)
+	})
+
+	t.Run("Duplicate devices", func(t *testing.T) {
+		_, err := node.ReconstructSecretKey([]node.Device{devices[0], devices[0], devices[1]})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,382 @@
+package node
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/pkg/errors"
+	"go.dedis.ch/kyber/v3"
+)
+
+// ErrRetiredShare is returned if a device's secret key share has been replaced by a refresh or a resharing and wiped.
+// Copies of a device alias its share, so that copies made before see the share as retired as well.
+var ErrRetiredShare = errors.New("secret key share has been retired by a refresh or a resharing")
+
+// RefreshDeal is the zero-sharing a device deals to all devices in one refresh epoch.
+// The constant term of the dealt polynomial is zero by construction, since the dealer only commits to the
+// coefficients a_1, ..., a_{t-1}. Adding the dealt shares thus re-randomizes the sharing but keeps the shared key.
+type RefreshDeal struct {
+	Epoch       uint64
+	Dealer      uint32
+	Commitments []*curves.EcPoint // Feldman commitments a_k*G for k = 1, ..., t-1.
+	Shares      map[uint32]*v1.ShamirShare
+}
+
+// Epoch returns the refresh epoch the device's share belongs to.
+func (d *Device) Epoch() uint64 {
+	return d.epoch
+}
+
+// NewRefreshDeal deals a fresh zero-sharing to the devices with the given share identifiers.
+func (d *Device) NewRefreshDeal(identifiers []uint32) (*RefreshDeal, error) {
+	if d.t == 0 {
+		return nil, errors.New("threshold of the device is not set")
+	}
+	if err := d.CheckShare(); err != nil {
+		return nil, err
+	}
+
+	field := curves.NewField(curve.Params().N)
+	coeffs, err := randomPolynomial(field.Zero(), d.t-1)
+	if err != nil {
+		return nil, errors.Wrap(err, "sampling zero polynomial")
+	}
+	commitments, err := commitPolynomial(coeffs[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make(map[uint32]*v1.ShamirShare, len(identifiers))
+	for _, id := range identifiers {
+		shares[id] = &v1.ShamirShare{
+			Identifier: id,
+			Value:      evalPolynomial(coeffs, id),
+		}
+	}
+
+	return &RefreshDeal{
+		Epoch:       d.epoch,
+		Dealer:      d.secretKeyShare.Identifier,
+		Commitments: commitments,
+		Shares:      shares,
+	}, nil
+}
+
+// ApplyRefresh verifies the deals of the current epoch and adds the dealt shares to the device's share. The public key
+// share and the Feldman commitments are updated using the dealt commitments, while the global public key stays
+// unchanged. Afterwards, the device moves to the next epoch with a newly allocated share and the previous share is
+// wiped, so that copies of the device made before the refresh fail with ErrRetiredShare instead of using it.
+func (d *Device) ApplyRefresh(deals []*RefreshDeal) error {
+	if len(deals) == 0 {
+		return errors.New("no refresh deals given")
+	}
+	if err := d.CheckShare(); err != nil {
+		return err
+	}
+
+	id := d.secretKeyShare.Identifier
+	delta := curves.NewField(curve.Params().N).Zero()
+	var deltaPk *curves.EcPoint
+	dealers := make(map[uint32]bool, len(deals))
+	commitments := make([]*curves.EcPoint, len(d.commitments))
+	copy(commitments, d.commitments)
+
+	for _, deal := range deals {
+		if deal.Epoch != d.epoch {
+			return errors.Errorf("deal of device %d is for epoch %d, device is in epoch %d", deal.Dealer, deal.Epoch, d.epoch)
+		}
+		if dealers[deal.Dealer] {
+			return errors.Errorf("duplicate deal of device %d", deal.Dealer)
+		}
+		dealers[deal.Dealer] = true
+
+		if len(deal.Commitments) != int(d.t)-1 {
+			return errors.Errorf("deal of device %d has %d commitments, expected %d", deal.Dealer, len(deal.Commitments), d.t-1)
+		}
+		share, ok := deal.Shares[id]
+		if !ok || share.Identifier != id {
+			return errors.Errorf("deal of device %d contains no share for device %d", deal.Dealer, id)
+		}
+
+		// Without commitments, i.e. for t = 1, the only zero polynomial is the constant zero.
+		if len(deal.Commitments) == 0 {
+			if share.Value.BigInt().Sign() != 0 {
+				return errors.Errorf("share dealt by device %d is invalid", deal.Dealer)
+			}
+			continue
+		}
+
+		expected, err := evalCommitments(deal.Commitments, id, 1)
+		if err != nil {
+			return errors.Wrapf(err, "evaluating commitments of device %d", deal.Dealer)
+		}
+		if err := verifyShareAgainstPublicShare(share, expected); err != nil {
+			return errors.Wrapf(err, "share dealt by device %d is invalid", deal.Dealer)
+		}
+
+		delta = delta.Add(share.Value)
+		if deltaPk, err = addEcPoints(deltaPk, expected); err != nil {
+			return err
+		}
+		for k, c := range deal.Commitments {
+			if commitments[k+1], err = commitments[k+1].Add(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	skPrime := &v1.ShamirShare{
+		Identifier: id,
+		Value:      d.secretKeyShare.Value.Add(delta),
+	}
+	updated := *d
+	updated.secretKeyShare = skPrime
+	updated.commitments = commitments
+	if deltaPk != nil {
+		var err error
+		if updated.publicKeyShare, err = (*curves.EcPoint)(d.publicKeyShare).Add(deltaPk); err != nil {
+			return errors.Wrap(err, "updating public key share")
+		}
+	}
+	if err := updated.VerifyShare(); err != nil {
+		return err
+	}
+
+	previous := d.secretKeyShare
+	*d = updated
+	d.epoch++
+	wipeShare(previous)
+
+	return nil
+}
+
+// RefreshDevices runs one epoch of the proactive refresh among all devices holding a share of the key.
+func RefreshDevices(devices []Device) error {
+	if len(devices) == 0 {
+		return errors.New("no devices given")
+	}
+
+	if err := CheckDevices(devices); err != nil {
+		return err
+	}
+	identifiers := make([]uint32, len(devices))
+	for i, d := range devices {
+		identifiers[i] = d.secretKeyShare.Identifier
+	}
+
+	deals := make([]*RefreshDeal, len(devices))
+	for i := range devices {
+		deal, err := devices[i].NewRefreshDeal(identifiers)
+		if err != nil {
+			return errors.Wrapf(err, "device %d", devices[i].deviceIdx)
+		}
+		deals[i] = deal
+	}
+
+	for i := range devices {
+		if err := devices[i].ApplyRefresh(deals); err != nil {
+			return errors.Wrapf(err, "refreshing device %d", devices[i].deviceIdx)
+		}
+	}
+
+	return nil
+}
+
+// wipeShare overwrites the value of a share that has been replaced. All copies of a device holding the share see it
+// as retired afterwards, see CheckShare.
+func wipeShare(share SecretKeyShare) {
+	share.Value.Value.SetInt64(0)
+}
+
+// CheckShare checks that the device holds a secret key share which has not been retired, see ErrRetiredShare.
+func (d *Device) CheckShare() error {
+	if d.secretKeyShare == nil || d.secretKeyShare.Value == nil || d.secretKeyShare.Value.Value == nil {
+		return errors.Errorf("device %d has no key share", d.deviceIdx)
+	}
+	// A share is zero only with negligible probability unless it has been wiped.
+	if d.secretKeyShare.Value.Value.Sign() == 0 {
+		return errors.Wrapf(ErrRetiredShare, "device %d", d.deviceIdx)
+	}
+	return nil
+}
+
+// CheckDevices checks the shares of the devices with CheckShare and that all devices are in the same refresh epoch, so
+// that a device left behind by a refresh is rejected instead of producing invalid evaluations or signatures.
+func CheckDevices(devices []Device) error {
+	var epoch uint64
+	for i := range devices {
+		if err := devices[i].CheckShare(); err != nil {
+			return err
+		}
+		if devices[i].epoch > epoch {
+			epoch = devices[i].epoch
+		}
+	}
+	for i := range devices {
+		if devices[i].epoch != epoch {
+			return errors.Errorf("device %d is in epoch %d, behind the other devices in epoch %d", devices[i].deviceIdx, devices[i].epoch, epoch)
+		}
+	}
+	return nil
+}
+
+// SealedRefreshDeal is a refresh deal to be sent over the network, in which the share of each recipient is encrypted
+// to the recipient's device key, so that the deal can be relayed by any device.
+type SealedRefreshDeal struct {
+	Epoch       uint64            `json:"epoch"`
+	Dealer      uint32            `json:"dealer"`
+	Commitments [][]byte          `json:"commitments"`
+	Shares      map[uint32][]byte `json:"shares"`
+}
+
+// Seal encrypts the share of each device to the given device key of its identifier.
+func (deal *RefreshDeal) Seal(deviceKeys map[uint32]kyber.Point) (*SealedRefreshDeal, error) {
+	sealed := &SealedRefreshDeal{
+		Epoch:       deal.Epoch,
+		Dealer:      deal.Dealer,
+		Commitments: make([][]byte, len(deal.Commitments)),
+		Shares:      make(map[uint32][]byte, len(deal.Shares)),
+	}
+	for k, c := range deal.Commitments {
+		sealed.Commitments[k] = c.Bytes()
+	}
+	var err error
+	if sealed.Shares, err = sealShares(deal.Shares, deviceKeys); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// sealShares encrypts each share to the given device key of its identifier.
+func sealShares(shares map[uint32]*v1.ShamirShare, deviceKeys map[uint32]kyber.Point) (map[uint32][]byte, error) {
+	sealed := make(map[uint32][]byte, len(shares))
+	for id, share := range shares {
+		pubkey, ok := deviceKeys[id]
+		if !ok {
+			return nil, errors.Errorf("no device key of device %d", id)
+		}
+		value := make([]byte, 32)
+		share.Value.BigInt().FillBytes(value)
+		var err error
+		sealed[id], err = EncryptTo(pubkey, value)
+		wipeBytes(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encrypting share of device %d", id)
+		}
+	}
+	return sealed, nil
+}
+
+// openShare decrypts the share with the given identifier of a sealed deal of the dealer with the private device key.
+func openShare(privkey kyber.Scalar, id, dealer uint32, shares map[uint32][]byte) (*v1.ShamirShare, error) {
+	ciphertext, ok := shares[id]
+	if !ok {
+		return nil, errors.Errorf("deal of device %d contains no share for device %d", dealer, id)
+	}
+	value, err := decrypt(privkey, ciphertext)
+	if err != nil {
+		return nil, errors.Wrapf(err, "share dealt by device %d", dealer)
+	}
+	defer wipeBytes(value)
+
+	field := curves.NewField(curve.Params().N)
+	v := new(big.Int).SetBytes(value)
+	if len(value) != 32 || !field.IsValid(v) {
+		return nil, errors.Errorf("share dealt by device %d is invalid", dealer)
+	}
+	return &v1.ShamirShare{Identifier: id, Value: field.NewElement(v)}, nil
+}
+
+// openCommitments decodes the Feldman commitments of a sealed deal of the dealer.
+func openCommitments(dealer uint32, sealed [][]byte) ([]*curves.EcPoint, error) {
+	commitments := make([]*curves.EcPoint, len(sealed))
+	for k, c := range sealed {
+		var err error
+		if commitments[k], err = curves.PointFromBytesUncompressed(curve, c); err != nil {
+			return nil, errors.Wrapf(err, "decoding commitment %d of device %d", k, dealer)
+		}
+	}
+	return commitments, nil
+}
+
+// OpenRefreshDeal decrypts the device's share of a sealed deal. The returned deal only contains the device's share and
+// is verified by ApplyRefresh.
+func (d *Device) OpenRefreshDeal(sealed *SealedRefreshDeal) (*RefreshDeal, error) {
+	id := d.secretKeyShare.Identifier
+	share, err := openShare(d.privkey, id, sealed.Dealer, sealed.Shares)
+	if err != nil {
+		return nil, err
+	}
+	commitments, err := openCommitments(sealed.Dealer, sealed.Commitments)
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshDeal{
+		Epoch:       sealed.Epoch,
+		Dealer:      sealed.Dealer,
+		Commitments: commitments,
+		Shares:      map[uint32]*v1.ShamirShare{id: share},
+	}, nil
+}
+
+// RefreshProtocol runs one epoch of the proactive refresh for a device as a session protocol. In its single round,
+// each device broadcasts its deal with the shares sealed to the device keys of the roster and then applies the deals
+// of all devices. All devices holding a share of the key must participate.
+type RefreshProtocol struct {
+	device       *Device
+	participants []uint32
+	roster       Roster
+	deal         *RefreshDeal
+}
+
+// NewRefreshProtocol creates the refresh protocol of the device among the participants with the device keys of the
+// roster.
+func NewRefreshProtocol(d *Device, participants []uint32, roster Roster) *RefreshProtocol {
+	return &RefreshProtocol{
+		device:       d,
+		participants: participants,
+		roster:       roster,
+	}
+}
+
+// Start implements Protocol.
+func (p *RefreshProtocol) Start() ([]Outgoing, error) {
+	if uint32(len(p.participants)) != p.device.n {
+		return nil, errors.Errorf("%d of %d devices participate in the refresh", len(p.participants), p.device.n)
+	}
+	var err error
+	if p.deal, err = p.device.NewRefreshDeal(p.participants); err != nil {
+		return nil, err
+	}
+	sealed, err := p.deal.Seal(p.roster)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding deal")
+	}
+	return []Outgoing{{To: Broadcast, Body: body}}, nil
+}
+
+// Advance implements Protocol.
+func (p *RefreshProtocol) Advance(_ uint32, received map[uint32][]byte) ([]Outgoing, bool, error) {
+	deals := []*RefreshDeal{p.deal}
+	for sender, body := range received {
+		var sealed SealedRefreshDeal
+		if err := json.Unmarshal(body, &sealed); err != nil {
+			return nil, false, errors.Wrapf(err, "decoding deal of device %d", sender)
+		}
+		if sealed.Dealer != sender {
+			return nil, false, errors.Errorf("deal of device %d is sent by device %d", sealed.Dealer, sender)
+		}
+		deal, err := p.device.OpenRefreshDeal(&sealed)
+		if err != nil {
+			return nil, false, err
+		}
+		deals = append(deals, deal)
+	}
+	return nil, true, p.device.ApplyRefresh(deals)
+}
@@ -0,0 +1,108 @@
+package node_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestRefreshDevices(t *testing.T) {
+	devices := utils.CreateDevices(3, 5)
+	pk := devices[0].PublicKey()
+	oldSk, _ := devices[0].KeyPair()
+	oldValue := oldSk.Value.Clone()
+	copies := make([]node.Device, len(devices))
+	copy(copies, devices)
+	backup, err := devices[0].MarshalKeystore([]byte("password"), testKDFParams)
+	require.NoError(t, err)
+
+	require.NoError(t, node.RefreshDevices(devices))
+
+	newSk, _ := devices[0].KeyPair()
+	assert.False(t, newSk.Value.IsEqual(oldValue), "share should be re-randomized")
+	require.NoError(t, node.CheckDevices(devices))
+
+	// Copies made before the refresh hold the wiped share and can no longer be used.
+	assert.ErrorIs(t, copies[0].CheckShare(), node.ErrRetiredShare)
+	_, err = copies[0].DeriveNonHardenedChild(0)
+	assert.ErrorIs(t, err, node.ErrRetiredShare)
+	_, err = node.ReconstructSecretKey(copies[:3])
+	assert.ErrorIs(t, err, node.ErrRetiredShare)
+	assert.ErrorIs(t, node.RefreshDevices(copies), node.ErrRetiredShare)
+	assert.Error(t, copies[0].SaveKeystore(filepath.Join(t.TempDir(), "device.json"), []byte("password"), testKDFParams))
+
+	// A device restored from a keystore written before the refresh is behind the other devices.
+	restored, _, err := node.UnmarshalKeystore(backup, []byte("password"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), restored.Epoch())
+	assert.Error(t, node.CheckDevices([]node.Device{restored, devices[1], devices[2]}))
+
+	// A device left out of a refresh is behind the other devices.
+	behind := utils.CreateDevices(3, 5)
+	require.NoError(t, node.RefreshDevices(behind[1:]))
+	assert.Error(t, node.CheckDevices(behind))
+	assert.Error(t, node.RefreshDevices(behind))
+	for _, d := range devices {
+		assert.Equal(t, uint64(1), d.Epoch())
+		assert.True(t, (*d.PublicKey()).Equal(*pk), "public key should be unchanged")
+	}
+
+	sk, err := node.ReconstructSecretKey(devices[1:4])
+	require.NoError(t, err)
+	assert.NotNil(t, sk)
+
+	t.Run("Reject deals of other epochs", func(t *testing.T) {
+		deal, err := devices[0].NewRefreshDeal([]uint32{1, 2, 3, 4, 5})
+		require.NoError(t, err)
+		deal.Epoch = 0
+		assert.Error(t, devices[1].ApplyRefresh([]*node.RefreshDeal{deal}))
+	})
+
+	t.Run("Reject invalid deals", func(t *testing.T) {
+		deal, err := devices[0].NewRefreshDeal([]uint32{1, 2, 3, 4, 5})
+		require.NoError(t, err)
+		deal.Shares[2].Value = deal.Shares[2].Value.Add(deal.Shares[3].Value)
+		assert.Error(t, devices[1].ApplyRefresh([]*node.RefreshDeal{deal}))
+	})
+
+	t.Run("Sealed deals", func(t *testing.T) {
+		ids := []uint32{1, 2, 3, 4, 5}
+		keys := make(map[uint32]kyber.Point, len(devices))
+		for i := range devices {
+			keys[devices[i].Identifier()] = devices[i].DeviceKey()
+		}
+		sealed := make([]*node.SealedRefreshDeal, len(devices))
+		for i := range devices {
+			deal, err := devices[i].NewRefreshDeal(ids)
+			require.NoError(t, err)
+			sealed[i], err = deal.Seal(keys)
+			require.NoError(t, err)
+		}
+
+		// A device cannot open the share of another device.
+		sealed[0].Shares[1], sealed[0].Shares[2] = sealed[0].Shares[2], sealed[0].Shares[1]
+		_, err := devices[0].OpenRefreshDeal(sealed[0])
+		assert.Error(t, err)
+		sealed[0].Shares[1], sealed[0].Shares[2] = sealed[0].Shares[2], sealed[0].Shares[1]
+
+		for i := range devices {
+			deals := make([]*node.RefreshDeal, len(sealed))
+			for k := range sealed {
+				deals[k], err = devices[i].OpenRefreshDeal(sealed[k])
+				require.NoError(t, err)
+			}
+			require.NoError(t, devices[i].ApplyRefresh(deals))
+			assert.Equal(t, uint64(2), devices[i].Epoch())
+		}
+		sk, err := node.ReconstructSecretKey(devices[:3])
+		require.NoError(t, err)
+		assert.True(t, curves.K256().ScalarBaseMult(*sk).Equal(*pk), "public key should be unchanged")
+	})
+}
@@ -0,0 +1,140 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+// RhoCommitment is a device's commitment to its contribution to the jointly sampled randomness rho.
+type RhoCommitment struct {
+	Identifier uint32
+	Commitment []byte
+}
+
+// RhoOpening opens a RhoCommitment.
+type RhoOpening struct {
+	Identifier uint32
+	Value      []byte
+	Salt       []byte
+}
+
+// CommitRho samples the device's contribution to rho and commits to it.
+// The commitment is broadcast first and the opening only once all commitments have been received, so that no device
+// can bias rho after having seen the contributions of the others.
+func (d *Device) CommitRho() (*RhoCommitment, *RhoOpening, error) {
+	field := curves.NewField(curve.Params().N)
+	value, err := field.RandomElement(rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "sampling contribution")
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, errors.Wrap(err, "sampling salt")
+	}
+
+	valueBytes := make([]byte, 32)
+	value.BigInt().FillBytes(valueBytes)
+
+	opening := &RhoOpening{
+		Identifier: d.secretKeyShare.Identifier,
+		Value:      valueBytes,
+		Salt:       salt,
+	}
+	commitment := &RhoCommitment{
+		Identifier: opening.Identifier,
+		Commitment: opening.commitment(),
+	}
+
+	return commitment, opening, nil
+}
+
+// CombineRho checks the openings against the commitments and returns the sum of all contributions.
+func CombineRho(commitments []*RhoCommitment, openings []*RhoOpening) (*curves.Element, error) {
+	if len(commitments) == 0 || len(commitments) != len(openings) {
+		return nil, errors.New("need exactly one opening per commitment")
+	}
+
+	committed := make(map[uint32][]byte, len(commitments))
+	for _, c := range commitments {
+		if _, ok := committed[c.Identifier]; ok {
+			return nil, errors.Errorf("duplicate commitment of device %d", c.Identifier)
+		}
+		committed[c.Identifier] = c.Commitment
+	}
+
+	field := curves.NewField(curve.Params().N)
+	rho := field.Zero()
+	for _, o := range openings {
+		c, ok := committed[o.Identifier]
+		if !ok {
+			return nil, errors.Errorf("missing commitment of device %d", o.Identifier)
+		}
+		if !hmac.Equal(c, o.commitment()) {
+			return nil, errors.Errorf("opening of device %d does not match its commitment", o.Identifier)
+		}
+		delete(committed, o.Identifier)
+
+		rho = rho.Add(field.ReducedElementFromBytes(o.Value))
+	}
+
+	return rho, nil
+}
+
+// RerandomizeDevices samples rho for the given devices and rerandomizes their shares with it. It is an in-process
+// helper for committees run by a single process, e.g. in tests: as it commits to and opens the contributions of all
+// devices itself, no device samples its contribution independently of the caller. Devices run by different parties
+// exchange the commitments and openings of CommitRho over the network instead and call CombineRho and RandSk
+// themselves. It returns rho, so that the shift of the global public key by rho*G can be verified.
+func RerandomizeDevices(devices []Device) (*curves.Element, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices given")
+	}
+
+	commitments := make([]*RhoCommitment, len(devices))
+	openings := make([]*RhoOpening, len(devices))
+	for i := range devices {
+		c, o, err := devices[i].CommitRho()
+		if err != nil {
+			return nil, errors.Wrapf(err, "device %d", devices[i].deviceIdx)
+		}
+		commitments[i] = c
+		openings[i] = o
+	}
+
+	rho, err := CombineRho(commitments, openings)
+	if err != nil {
+		return nil, errors.Wrap(err, "combining rho")
+	}
+
+	for i := range devices {
+		if err := devices[i].RandSk(rho); err != nil {
+			return nil, errors.Wrapf(err, "rerandomizing device %d", devices[i].deviceIdx)
+		}
+	}
+
+	pkG := devices[0].publicKeyGlobal
+	for _, d := range devices[1:] {
+		if !(*d.publicKeyGlobal).Equal(*pkG) {
+			return nil, errors.New("devices disagree on the rerandomized public key")
+		}
+	}
+
+	return rho, nil
+}
+
+func (o *RhoOpening) commitment() []byte {
+	idBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(idBytes, o.Identifier)
+
+	h := sha3.New256()
+	h.Write(idBytes)
+	h.Write(o.Value)
+	h.Write(o.Salt)
+	return h.Sum(nil)
+}
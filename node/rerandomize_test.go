@@ -0,0 +1,49 @@
+package node_test
+
+import (
+	"testing"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestRerandomizeDevices(t *testing.T) {
+	devices := utils.CreateDevices(3, 5)
+	pk := devices[0].PublicKey()
+
+	rho, err := node.RerandomizeDevices(devices)
+	require.NoError(t, err)
+
+	rhoScalar, err := curves.K256().Scalar.SetBigInt(rho.BigInt())
+	require.NoError(t, err)
+	expected := (*pk).Add(curves.K256().ScalarBaseMult(rhoScalar))
+	for _, d := range devices {
+		assert.True(t, (*d.PublicKey()).Equal(expected), "public key should be shifted by rho*G")
+	}
+
+	// Any t of the rerandomized shares reconstruct the shifted key.
+	_, err = node.ReconstructSecretKey(devices[:3])
+	require.NoError(t, err)
+	_, err = node.ReconstructSecretKey(devices[2:])
+	require.NoError(t, err)
+}
+
+func TestCombineRho(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+
+	c1, o1, err := devices[0].CommitRho()
+	require.NoError(t, err)
+	c2, o2, err := devices[1].CommitRho()
+	require.NoError(t, err)
+
+	_, err = node.CombineRho([]*node.RhoCommitment{c1, c2}, []*node.RhoOpening{o1, o2})
+	require.NoError(t, err)
+
+	o2.Value[0] ^= 1
+	_, err = node.CombineRho([]*node.RhoCommitment{c1, c2}, []*node.RhoOpening{o1, o2})
+	assert.Error(t, err, "tampered opening should be rejected")
+}
@@ -0,0 +1,383 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/pkg/errors"
+	"go.dedis.ch/dela/mino"
+	"go.dedis.ch/kyber/v3"
+)
+
+// opReshare is the operation resharing deals are signed for.
+const opReshare = "reshare"
+
+// ReshareSession holds the public information of moving a shared key from an old committee of devices to a new
+// committee with threshold NewT and NewN devices.
+type ReshareSession struct {
+	ID              []byte                    // Session identifier the deals are signed for.
+	Dealers         []uint32                  // Share identifiers of the participating old devices.
+	OldPublicShares map[uint32]PublicKeyShare // Public key shares of the participating old devices.
+	OldDeviceKeys   Roster                    // Device keys of the participating old devices, which sign the deals.
+	PublicKey       PublicKey
+	NewT            uint32
+	NewN            uint32
+	NewIdentifiers  []uint32
+	NewDeviceKeys   Roster // Device keys of the new devices, which the deals are sealed to.
+
+	state State
+	epoch uint64
+}
+
+// ReshareDeal is the sharing of an old device's Lagrange-weighted share dealt to the new committee.
+type ReshareDeal struct {
+	Dealer      uint32
+	Commitments []*curves.EcPoint // Feldman commitments a_k*G for k = 0, ..., t'-1.
+	Shares      map[uint32]*v1.ShamirShare
+}
+
+// SealedReshareDeal is a resharing deal to be sent over the network, in which the share of each new device is
+// encrypted to the device key of the new device, like in a SealedRefreshDeal.
+type SealedReshareDeal struct {
+	Dealer      uint32            `json:"dealer"`
+	Commitments [][]byte          `json:"commitments"`
+	Shares      map[uint32][]byte `json:"shares"`
+}
+
+// ReshareRecipient is a device of the new committee before it holds its share. Its device key is generated up front,
+// so that the deals can be sealed to it, and becomes the device key of the new device.
+type ReshareRecipient struct {
+	identifier uint32
+	privkey    kyber.Scalar
+}
+
+// NewReshareRecipient creates the recipient of the new device with the given share identifier and a fresh device key.
+func NewReshareRecipient(id uint32) *ReshareRecipient {
+	return &ReshareRecipient{
+		identifier: id,
+		privkey:    suite.Scalar().Pick(suite.RandomStream()),
+	}
+}
+
+// Identifier returns the share identifier of the new device.
+func (r *ReshareRecipient) Identifier() uint32 {
+	return r.identifier
+}
+
+// DeviceKey returns the public device key of the new device.
+func (r *ReshareRecipient) DeviceKey() kyber.Point {
+	return suite.Point().Mul(r.privkey, nil)
+}
+
+// NewReshareSession sets up the resharing of the key shared among the given old devices to a new committee with
+// threshold newT, whose devices have the given device keys, see NewReshareRecipient, and the share identifiers 1, ...,
+// n'. At least t of the old devices must participate.
+func NewReshareSession(old []Device, newT uint32, newDeviceKeys Roster) (*ReshareSession, error) {
+	if len(old) == 0 {
+		return nil, errors.New("no old devices given")
+	}
+	newN := uint32(len(newDeviceKeys))
+	if newT == 0 || newN < newT {
+		return nil, errors.Errorf("invalid threshold parameters (%d, %d)", newT, newN)
+	}
+	if uint32(len(old)) < old[0].t {
+		return nil, errors.Errorf("need at least %d old devices, got %d", old[0].t, len(old))
+	}
+	id, err := NewSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ReshareSession{
+		ID:              id,
+		Dealers:         make([]uint32, len(old)),
+		OldPublicShares: make(map[uint32]PublicKeyShare, len(old)),
+		OldDeviceKeys:   make(Roster, len(old)),
+		PublicKey:       old[0].publicKeyGlobal,
+		NewT:            newT,
+		NewN:            newN,
+		NewIdentifiers:  make([]uint32, newN),
+		NewDeviceKeys:   newDeviceKeys,
+		state:           old[0].state,
+		epoch:           old[0].epoch,
+	}
+	for i, d := range old {
+		id := d.secretKeyShare.Identifier
+		if _, ok := s.OldPublicShares[id]; ok {
+			return nil, errors.Errorf("duplicate old device with identifier %d", id)
+		}
+		if !(*d.publicKeyGlobal).Equal(*s.PublicKey) || d.epoch != s.epoch {
+			return nil, errors.Errorf("old device %d does not share the same key", d.deviceIdx)
+		}
+		s.Dealers[i] = id
+		s.OldPublicShares[id] = d.publicKeyShare
+		s.OldDeviceKeys[id] = d.DeviceKey()
+	}
+	for i := range s.NewIdentifiers {
+		s.NewIdentifiers[i] = uint32(i) + 1
+		if _, ok := newDeviceKeys[s.NewIdentifiers[i]]; !ok {
+			return nil, errors.Errorf("no device key of new device %d", s.NewIdentifiers[i])
+		}
+	}
+
+	return s, nil
+}
+
+// Reshare deals the device's Lagrange-weighted share lambda_j*s_j to the new committee using a random polynomial of
+// degree t'-1, so that the sum of all deals is a sharing of the old secret key.
+func (d *Device) Reshare(s *ReshareSession) (*ReshareDeal, error) {
+	if err := d.CheckShare(); err != nil {
+		return nil, err
+	}
+	id := d.secretKeyShare.Identifier
+	if _, ok := s.OldPublicShares[id]; !ok {
+		return nil, errors.Errorf("device %d is not a dealer of the session", d.deviceIdx)
+	}
+
+	lambda := lagrangeCoefficient(id, s.Dealers)
+	coeffs, err := randomPolynomial(d.secretKeyShare.Value.Mul(lambda), s.NewT-1)
+	if err != nil {
+		return nil, errors.Wrap(err, "sampling polynomial")
+	}
+	commitments, err := commitPolynomial(coeffs)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make(map[uint32]*v1.ShamirShare, len(s.NewIdentifiers))
+	for _, newId := range s.NewIdentifiers {
+		shares[newId] = &v1.ShamirShare{
+			Identifier: newId,
+			Value:      evalPolynomial(coeffs, newId),
+		}
+	}
+
+	return &ReshareDeal{
+		Dealer:      id,
+		Commitments: commitments,
+		Shares:      shares,
+	}, nil
+}
+
+// DealReshare deals the device's share to the new committee like Reshare, seals the shares to the device keys of the
+// new devices and signs the sealed deal for the session, so that it can be sent to the new devices over the network.
+func (d *Device) DealReshare(s *ReshareSession) (*SignedMessage, error) {
+	deal, err := d.Reshare(s)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := deal.Seal(s.NewDeviceKeys)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding deal")
+	}
+	return d.SignMessage(opReshare, s.ID, Broadcast, body)
+}
+
+// Seal encrypts the share of each new device to the given device key of its identifier.
+func (deal *ReshareDeal) Seal(deviceKeys map[uint32]kyber.Point) (*SealedReshareDeal, error) {
+	sealed := &SealedReshareDeal{
+		Dealer:      deal.Dealer,
+		Commitments: make([][]byte, len(deal.Commitments)),
+	}
+	for k, c := range deal.Commitments {
+		sealed.Commitments[k] = c.Bytes()
+	}
+	var err error
+	if sealed.Shares, err = sealShares(deal.Shares, deviceKeys); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// OpenReshareDeal verifies that the message is a deal of the session signed by an old device and decrypts the
+// recipient's share. The returned deal only contains the recipient's share and is verified by NewDevice.
+func (r *ReshareRecipient) OpenReshareDeal(s *ReshareSession, m *SignedMessage) (*ReshareDeal, error) {
+	if m.Op != opReshare || !bytes.Equal(m.Session, s.ID) || m.Recipient != Broadcast {
+		return nil, errors.Errorf("message of device %d is not a deal of the resharing session", m.Sender)
+	}
+	if err := s.OldDeviceKeys.Verify(m); err != nil {
+		return nil, err
+	}
+	var sealed SealedReshareDeal
+	if err := json.Unmarshal(m.Body, &sealed); err != nil {
+		return nil, errors.Wrapf(err, "decoding deal of device %d", m.Sender)
+	}
+	if sealed.Dealer != m.Sender {
+		return nil, errors.Errorf("deal of device %d is signed by device %d", sealed.Dealer, m.Sender)
+	}
+
+	share, err := openShare(r.privkey, r.identifier, sealed.Dealer, sealed.Shares)
+	if err != nil {
+		return nil, err
+	}
+	commitments, err := openCommitments(sealed.Dealer, sealed.Commitments)
+	if err != nil {
+		return nil, err
+	}
+	return &ReshareDeal{
+		Dealer:      sealed.Dealer,
+		Commitments: commitments,
+		Shares:      map[uint32]*v1.ShamirShare{r.identifier: share},
+	}, nil
+}
+
+// NewDevice verifies the deals of all dealers and creates the recipient's device from them, keeping the recipient's
+// device key. The new device continues in the epoch following the one of the old committee.
+func (r *ReshareRecipient) NewDevice(s *ReshareSession, deals []*ReshareDeal, m mino.Mino) (Device, error) {
+	id := r.identifier
+	if key, ok := s.NewDeviceKeys[id]; !ok || !key.Equal(r.DeviceKey()) {
+		return Device{}, errors.Errorf("device key of new device %d is not part of the session", id)
+	}
+	if len(deals) != len(s.Dealers) {
+		return Device{}, errors.Errorf("expected %d deals, got %d", len(s.Dealers), len(deals))
+	}
+
+	sk := curves.NewField(curve.Params().N).Zero()
+	var pk *curves.EcPoint
+	commitments := make([]*curves.EcPoint, s.NewT)
+	seen := make(map[uint32]bool, len(deals))
+
+	for _, deal := range deals {
+		oldPk, ok := s.OldPublicShares[deal.Dealer]
+		if !ok || seen[deal.Dealer] {
+			return Device{}, errors.Errorf("unexpected deal of device %d", deal.Dealer)
+		}
+		seen[deal.Dealer] = true
+
+		if len(deal.Commitments) != int(s.NewT) {
+			return Device{}, errors.Errorf("deal of device %d has %d commitments, expected %d", deal.Dealer, len(deal.Commitments), s.NewT)
+		}
+
+		// The constant term must be the dealer's Lagrange-weighted share, i.e. C_0 = lambda_j*pk_j.
+		lambda := lagrangeCoefficient(deal.Dealer, s.Dealers)
+		weightedPk, err := (*curves.EcPoint)(oldPk).ScalarMult(lambda.BigInt())
+		if err != nil {
+			return Device{}, err
+		}
+		if !weightedPk.Equals(deal.Commitments[0]) {
+			return Device{}, errors.Errorf("deal of device %d does not match its public key share", deal.Dealer)
+		}
+
+		share, ok := deal.Shares[id]
+		if !ok || share.Identifier != id {
+			return Device{}, errors.Errorf("deal of device %d contains no share for identifier %d", deal.Dealer, id)
+		}
+		expected, err := evalCommitments(deal.Commitments, id, 0)
+		if err != nil {
+			return Device{}, err
+		}
+		if err := verifyShareAgainstPublicShare(share, expected); err != nil {
+			return Device{}, errors.Wrapf(err, "share dealt by device %d is invalid", deal.Dealer)
+		}
+
+		sk = sk.Add(share.Value)
+		if pk, err = addEcPoints(pk, expected); err != nil {
+			return Device{}, err
+		}
+		for k, c := range deal.Commitments {
+			if commitments[k], err = addEcPoints(commitments[k], c); err != nil {
+				return Device{}, err
+			}
+		}
+	}
+
+	expectedPkG, err := curves.K256().Point.Set(commitments[0].X, commitments[0].Y)
+	if err != nil {
+		return Device{}, err
+	}
+	if !expectedPkG.Equal(*s.PublicKey) {
+		return Device{}, errors.New("deals do not share the old public key")
+	}
+
+	share := &v1.ShamirShare{Identifier: id, Value: sk}
+	device, _ := NewDevice(int(id)-1, s.NewT, s.NewN, pk, share, s.PublicKey, commitments, s.state.nodeIdx, s.state.chainCode, m)
+	device.state = s.state
+	device.epoch = s.epoch + 1
+	device.privkey = r.privkey
+	if err := device.VerifyShare(); err != nil {
+		return Device{}, err
+	}
+
+	return device, nil
+}
+
+// ReshareDevices moves the key shared among the given old devices to a new committee of newN devices with threshold
+// newT. The old devices send their deals as signed messages with the shares sealed to the device keys of the new
+// devices. Once all new devices have been created, the shares of the old devices are retired, so that the old sharing
+// can no longer be used. Old devices not taking part in the resharing must be retired by their holders.
+func ReshareDevices(old []Device, newT, newN uint32) ([]Device, []kyber.Point, error) {
+	recipients := make([]*ReshareRecipient, newN)
+	pubkeys := make([]kyber.Point, newN)
+	keys := make(Roster, newN)
+	for i := range recipients {
+		recipients[i] = NewReshareRecipient(uint32(i) + 1)
+		pubkeys[i] = recipients[i].DeviceKey()
+		keys[recipients[i].Identifier()] = pubkeys[i]
+	}
+	s, err := NewReshareSession(old, newT, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msgs := make([]*SignedMessage, len(old))
+	for i := range old {
+		if msgs[i], err = old[i].DealReshare(s); err != nil {
+			return nil, nil, errors.Wrapf(err, "device %d", old[i].deviceIdx)
+		}
+	}
+
+	devices := make([]Device, newN)
+	for i, r := range recipients {
+		deals := make([]*ReshareDeal, len(msgs))
+		for k, m := range msgs {
+			if deals[k], err = r.OpenReshareDeal(s, m); err != nil {
+				return nil, nil, errors.Wrapf(err, "new device %d", i)
+			}
+		}
+		if devices[i], err = r.NewDevice(s, deals, nil); err != nil {
+			return nil, nil, errors.Wrapf(err, "new device %d", i)
+		}
+	}
+
+	for i := range old {
+		old[i].Retire()
+	}
+	return devices, pubkeys, nil
+}
+
+// Retire wipes the device's share once the key has been moved to a new committee. Copies of the device see the share
+// as retired as well, see ErrRetiredShare.
+func (d *Device) Retire() {
+	wipeShare(d.secretKeyShare)
+}
+
+// lagrangeCoefficient computes the Lagrange coefficient of id at 0 with respect to the given identifiers.
+func lagrangeCoefficient(id uint32, identifiers []uint32) *curves.Element {
+	field := curves.NewField(curve.Params().N)
+	x := field.NewElement(big.NewInt(int64(id)))
+
+	lambda := field.One()
+	for _, k := range identifiers {
+		if k == id {
+			continue
+		}
+		xk := field.NewElement(big.NewInt(int64(k)))
+		lambda = lambda.Mul(xk.Div(xk.Sub(x)))
+	}
+	return lambda
+}
+
+// addEcPoints returns a + b, treating a nil a as the neutral element.
+func addEcPoints(a, b *curves.EcPoint) (*curves.EcPoint, error) {
+	if a == nil {
+		return b, nil
+	}
+	return a.Add(b)
+}
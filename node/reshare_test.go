@@ -0,0 +1,90 @@
+package node_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestReshareDevices(t *testing.T) {
+	old := utils.CreateDevices(2, 3)
+	pk := old[0].PublicKey()
+	sk, err := node.ReconstructSecretKey(old)
+	require.NoError(t, err)
+
+	devices, pubkeys, err := node.ReshareDevices(old[:2], 4, 6)
+	require.NoError(t, err)
+	require.Len(t, devices, 6)
+	require.Len(t, pubkeys, 6)
+
+	for i, d := range devices {
+		assert.Equal(t, uint32(4), d.Threshold())
+		assert.True(t, (*d.PublicKey()).Equal(*pk), "public key should be unchanged")
+		assert.True(t, d.DeviceKey().Equal(pubkeys[i]))
+	}
+
+	resharedSk, err := node.ReconstructSecretKey(devices[2:])
+	require.NoError(t, err)
+	assert.Equal(t, (*sk).Bytes(), (*resharedSk).Bytes())
+
+	_, err = node.ReconstructSecretKey(devices[:3])
+	assert.Error(t, err, "three devices should no longer suffice")
+
+	// The shares of the old committee are retired.
+	_, err = node.ReconstructSecretKey(old[:2])
+	assert.ErrorIs(t, err, node.ErrRetiredShare)
+
+	_, _, err = node.ReshareDevices(devices[:3], 2, 3)
+	assert.Error(t, err, "resharing needs at least t old devices")
+}
+
+func TestReshareDeals(t *testing.T) {
+	old := utils.CreateDevices(2, 3)
+	recipients := make([]*node.ReshareRecipient, 4)
+	keys := node.Roster{}
+	for i := range recipients {
+		recipients[i] = node.NewReshareRecipient(uint32(i) + 1)
+		keys[recipients[i].Identifier()] = recipients[i].DeviceKey()
+	}
+	s, err := node.NewReshareSession(old, 2, keys)
+	require.NoError(t, err)
+
+	t.Run("Reject invalid deals", func(t *testing.T) {
+		deals := make([]*node.ReshareDeal, len(old))
+		for i := range old {
+			deals[i], err = old[i].Reshare(s)
+			require.NoError(t, err)
+		}
+		deals[1].Shares[1].Value = deals[1].Shares[1].Value.Add(deals[1].Shares[2].Value)
+
+		_, err = recipients[0].NewDevice(s, deals, nil)
+		assert.Error(t, err)
+		_, err = recipients[1].NewDevice(s, deals, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Sealed deals", func(t *testing.T) {
+		msg, err := old[0].DealReshare(s)
+		require.NoError(t, err)
+		_, err = recipients[0].OpenReshareDeal(s, msg)
+		assert.NoError(t, err)
+
+		// Only the new device holding the share can open it.
+		_, err = node.NewReshareRecipient(1).OpenReshareDeal(s, msg)
+		assert.Error(t, err)
+
+		// Deals must be signed by the old device for the session.
+		forged := *msg
+		forged.Sender = old[1].Identifier()
+		_, err = recipients[0].OpenReshareDeal(s, &forged)
+		assert.Error(t, err)
+		other, err := node.NewReshareSession(old, 2, keys)
+		require.NoError(t, err)
+		_, err = recipients[0].OpenReshareDeal(other, msg)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,253 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Broadcast addresses an outgoing message to all other participants of a session.
+const Broadcast uint32 = 0
+
+// Outgoing is a message a protocol sends in a round.
+type Outgoing struct {
+	To   uint32 // Share identifier of the recipient or Broadcast.
+	Body []byte
+}
+
+// Protocol is a protocol between the devices of a session written as a state machine of rounds. In every round, each
+// participant sends exactly one message to every other participant, either broadcast or addressed to it, and the
+// protocol advances once the messages of all other participants in the round have been received.
+type Protocol interface {
+	// Start returns the messages of the first round.
+	Start() ([]Outgoing, error)
+	// Advance processes the messages of the other participants in the round, keyed by their share identifiers, and
+	// returns the messages of the next round or done once the protocol has finished.
+	Advance(round uint32, received map[uint32][]byte) (out []Outgoing, done bool, err error)
+}
+
+// SessionConfig configures a session.
+type SessionConfig struct {
+	Op           string        // Name of the protocol, which all messages of the session are signed for.
+	ID           []byte        // Session identifier agreed on by the participants, see NewSessionID.
+	Participants []uint32      // Share identifiers of the participating devices, including the device itself.
+	Roster       Roster        // Device keys the messages of the participants are verified against.
+	Timeout      time.Duration // Maximum duration of a round, unlimited if zero.
+	Guard        *ReplayGuard  // Optional replay guard shared by the sessions of the device.
+}
+
+// TimeoutError is returned by Session.Run if the messages of a round do not arrive in time.
+type TimeoutError struct {
+	Round   uint32
+	Missing []uint32 // Share identifiers of the devices whose messages are missing.
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("round %d timed out waiting for devices %v", e.Round, e.Missing)
+}
+
+// Session runs a protocol for a device. It signs the outgoing messages of each round and verifies the incoming ones,
+// buffers messages of the next round that arrive early, drops late, duplicate and out-of-order messages and detects
+// rounds that time out. A transport must only be used by one session at a time.
+type Session struct {
+	device    *Device
+	transport Transport
+	cfg       SessionConfig
+	others    []uint32 // Sorted share identifiers of the other participants.
+
+	round    uint32
+	received map[uint32][]byte // Messages of the current round.
+	early    map[uint32][]byte // Messages of the next round.
+}
+
+// NewSession creates a session of the device communicating over the given transport.
+func NewSession(d *Device, transport Transport, cfg SessionConfig) (*Session, error) {
+	if len(cfg.ID) != SessionSize {
+		return nil, errors.Errorf("session identifier has %d bytes, expected %d", len(cfg.ID), SessionSize)
+	}
+	self := false
+	seen := make(map[uint32]bool, len(cfg.Participants))
+	var others []uint32
+	for _, id := range cfg.Participants {
+		if id == Broadcast || seen[id] {
+			return nil, errors.Errorf("invalid participant %d", id)
+		}
+		seen[id] = true
+		if _, ok := cfg.Roster[id]; !ok {
+			return nil, errors.Errorf("participant %d is not in the roster", id)
+		}
+		if id == d.Identifier() {
+			self = true
+		} else {
+			others = append(others, id)
+		}
+	}
+	if !self {
+		return nil, errors.Errorf("device %d does not participate in the session", d.Identifier())
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i] < others[j] })
+
+	return &Session{
+		device:    d,
+		transport: transport,
+		cfg:       cfg,
+		others:    others,
+		received:  make(map[uint32][]byte),
+		early:     make(map[uint32][]byte),
+	}, nil
+}
+
+// Round returns the current round of the session.
+func (s *Session) Round() uint32 {
+	return s.round
+}
+
+// Run runs the protocol until it has finished, fails or a round times out.
+func (s *Session) Run(ctx context.Context, p Protocol) error {
+	out, err := p.Start()
+	if err != nil {
+		return errors.Wrapf(err, "starting %s", s.cfg.Op)
+	}
+	for {
+		if err := s.send(out); err != nil {
+			return err
+		}
+		received, err := s.collect(ctx)
+		if err != nil {
+			return err
+		}
+
+		var done bool
+		out, done, err = p.Advance(s.round, received)
+		if err != nil {
+			return errors.Wrapf(err, "%s round %d", s.cfg.Op, s.round)
+		}
+		if done {
+			if len(out) != 0 {
+				return errors.Errorf("%s finished in round %d with messages left to send", s.cfg.Op, s.round)
+			}
+			return nil
+		}
+		s.round++
+	}
+}
+
+// send signs the messages of the current round for their recipients and sends them after checking that every other
+// participant receives exactly one message.
+func (s *Session) send(out []Outgoing) error {
+	recipients := make([][]uint32, len(out))
+	addressed := make(map[uint32]bool, len(s.others))
+	for i, o := range out {
+		recipients[i] = []uint32{o.To}
+		if o.To == Broadcast {
+			recipients[i] = s.others
+		}
+		for _, to := range recipients[i] {
+			if !s.participates(to) {
+				return errors.Errorf("device %d does not participate in %s", to, s.cfg.Op)
+			}
+			if addressed[to] {
+				return errors.Errorf("%s sends several messages to device %d in round %d", s.cfg.Op, to, s.round)
+			}
+			addressed[to] = true
+		}
+	}
+	if len(addressed) != len(s.others) {
+		return errors.Errorf("%s sends no message to some devices in round %d", s.cfg.Op, s.round)
+	}
+
+	for i, o := range out {
+		m, err := s.device.SignRoundMessage(s.cfg.Op, s.cfg.ID, s.round, o.To, o.Body)
+		if err != nil {
+			return err
+		}
+		for _, to := range recipients[i] {
+			if err := s.transport.Send(to, m); err != nil {
+				return errors.Wrapf(err, "sending %s message to device %d", s.cfg.Op, to)
+			}
+		}
+	}
+	return nil
+}
+
+// collect waits for the messages of the other participants in the current round.
+func (s *Session) collect(ctx context.Context) (map[uint32][]byte, error) {
+	s.received, s.early = s.early, make(map[uint32][]byte)
+
+	var timeout <-chan time.Time
+	if s.cfg.Timeout > 0 {
+		timer := time.NewTimer(s.cfg.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(s.received) < len(s.others) {
+		select {
+		case m, ok := <-s.transport.Receive():
+			if !ok {
+				return nil, errors.Errorf("transport closed in %s round %d", s.cfg.Op, s.round)
+			}
+			if err := s.accept(m); err != nil {
+				log.Debugf("device %d dropping message: %v", s.device.Identifier(), err)
+			}
+		case <-timeout:
+			err := &TimeoutError{Round: s.round}
+			for _, id := range s.others {
+				if _, ok := s.received[id]; !ok {
+					err.Missing = append(err.Missing, id)
+				}
+			}
+			return nil, err
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "%s round %d", s.cfg.Op, s.round)
+		}
+	}
+	return s.received, nil
+}
+
+// accept verifies the message and stores it with the messages of its round.
+func (s *Session) accept(m *SignedMessage) error {
+	if m == nil || m.Op != s.cfg.Op || !bytes.Equal(m.Session, s.cfg.ID) {
+		return errors.New("message of another session")
+	}
+	if m.Sender == s.device.Identifier() || !s.participates(m.Sender) {
+		return errors.Errorf("device %d does not participate in %s", m.Sender, s.cfg.Op)
+	}
+	if m.Recipient != Broadcast && m.Recipient != s.device.Identifier() {
+		return errors.Errorf("message of device %d is addressed to device %d", m.Sender, m.Recipient)
+	}
+	if err := s.cfg.Roster.Verify(m); err != nil {
+		return err
+	}
+	if s.cfg.Guard != nil {
+		if err := s.cfg.Guard.Check(m, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	// Since every device waits for the messages of all others before advancing, the other devices are at most one
+	// round ahead.
+	var messages map[uint32][]byte
+	switch m.Round {
+	case s.round:
+		messages = s.received
+	case s.round + 1:
+		messages = s.early
+	default:
+		return errors.Errorf("message of device %d for round %d is out of order in round %d", m.Sender, m.Round, s.round)
+	}
+	if _, ok := messages[m.Sender]; ok {
+		return errors.Errorf("duplicate message of device %d for round %d", m.Sender, m.Round)
+	}
+	messages[m.Sender] = m.Body
+	return nil
+}
+
+func (s *Session) participates(id uint32) bool {
+	i := sort.Search(len(s.others), func(i int) bool { return s.others[i] >= id })
+	return i < len(s.others) && s.others[i] == id
+}
@@ -0,0 +1,210 @@
+package node_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+// countingProtocol broadcasts a message naming the round and its sender in even rounds and sends it to each device
+// separately in odd rounds, checking that the messages of each round arrive in the right round.
+type countingProtocol struct {
+	self   uint32
+	others []uint32
+	rounds uint32
+	delay  time.Duration
+}
+
+func (p *countingProtocol) messages(round uint32) []node.Outgoing {
+	body := []byte(fmt.Sprintf("round %d of %d", round, p.self))
+	if round%2 == 0 {
+		return []node.Outgoing{{To: node.Broadcast, Body: body}}
+	}
+	out := make([]node.Outgoing, len(p.others))
+	for i, id := range p.others {
+		out[i] = node.Outgoing{To: id, Body: body}
+	}
+	return out
+}
+
+func (p *countingProtocol) Start() ([]node.Outgoing, error) {
+	return p.messages(0), nil
+}
+
+func (p *countingProtocol) Advance(round uint32, received map[uint32][]byte) ([]node.Outgoing, bool, error) {
+	time.Sleep(p.delay)
+	for sender, body := range received {
+		if string(body) != fmt.Sprintf("round %d of %d", round, sender) {
+			return nil, false, errors.Errorf("unexpected message %q in round %d", body, round)
+		}
+	}
+	if round+1 == p.rounds {
+		return nil, true, nil
+	}
+	return p.messages(round + 1), false, nil
+}
+
+func newSessions(t *testing.T, devices []node.Device, cfg node.SessionConfig) []*node.Session {
+	network := node.NewMemoryNetwork()
+	cfg.Roster = node.Roster{}
+	for i := range devices {
+		cfg.Participants = append(cfg.Participants, devices[i].Identifier())
+		cfg.Roster[devices[i].Identifier()] = devices[i].DeviceKey()
+	}
+	var err error
+	if cfg.ID == nil {
+		cfg.ID, err = node.NewSessionID()
+		require.NoError(t, err)
+	}
+
+	sessions := make([]*node.Session, len(devices))
+	for i := range devices {
+		sessions[i], err = node.NewSession(&devices[i], network.Transport(devices[i].Identifier()), cfg)
+		require.NoError(t, err)
+	}
+	return sessions
+}
+
+// run runs the protocols of the sessions concurrently and returns their errors.
+func run(sessions []*node.Session, protocols []node.Protocol) []error {
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = sessions[i].Run(context.Background(), protocols[i])
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestSession(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	counting := func(delays ...time.Duration) []node.Protocol {
+		protocols := make([]node.Protocol, len(devices))
+		for i := range devices {
+			p := &countingProtocol{self: devices[i].Identifier(), rounds: 4, delay: delays[i]}
+			for j := range devices {
+				if j != i {
+					p.others = append(p.others, devices[j].Identifier())
+				}
+			}
+			protocols[i] = p
+		}
+		return protocols
+	}
+
+	t.Run("Buffer early messages", func(t *testing.T) {
+		sessions := newSessions(t, devices, node.SessionConfig{Op: "count", Timeout: time.Second})
+		for i, err := range run(sessions, counting(0, 0, 10*time.Millisecond)) {
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(3), sessions[i].Round())
+		}
+	})
+
+	t.Run("Drop invalid messages", func(t *testing.T) {
+		network := node.NewMemoryNetwork()
+		session, err := node.NewSessionID()
+		require.NoError(t, err)
+		cfg := node.SessionConfig{Op: "count", ID: session, Timeout: time.Second}
+		cfg.Roster = node.Roster{}
+		for i := range devices {
+			cfg.Participants = append(cfg.Participants, devices[i].Identifier())
+			cfg.Roster[devices[i].Identifier()] = devices[i].DeviceKey()
+		}
+
+		// Device 1 receives a message from an outsider claiming to be device 3, a message of device 2 for a later
+		// round, a message of another session, a message of device 2 addressed to device 3 and a replayed message
+		// before the genuine ones. The genuine message of device 2 is dropped as duplicate.
+		outsider := utils.CreateDevices(2, 3)[2]
+		forged, err := outsider.SignRoundMessage("count", session, 0, node.Broadcast, []byte("round 0 of 3"))
+		require.NoError(t, err)
+		ahead, err := devices[1].SignRoundMessage("count", session, 2, node.Broadcast, []byte("round 2 of 2"))
+		require.NoError(t, err)
+		other, err := devices[1].SignRoundMessage("count", make([]byte, node.SessionSize), 0, node.Broadcast, []byte("round 0 of 2"))
+		require.NoError(t, err)
+		misdirected, err := devices[1].SignRoundMessage("count", session, 0, devices[2].Identifier(), []byte("round 0 for 3"))
+		require.NoError(t, err)
+		genuine, err := devices[1].SignRoundMessage("count", session, 0, node.Broadcast, []byte("round 0 of 2"))
+		require.NoError(t, err)
+		inject := network.Transport(0)
+		for _, m := range []*node.SignedMessage{forged, ahead, other, misdirected, genuine, genuine} {
+			require.NoError(t, inject.Send(devices[0].Identifier(), m))
+		}
+
+		sessions := make([]*node.Session, len(devices))
+		protocols := counting(0, 0, 0)
+		for i := range devices {
+			cfg.Guard = node.NewReplayGuard(time.Minute)
+			sessions[i], err = node.NewSession(&devices[i], network.Transport(devices[i].Identifier()), cfg)
+			require.NoError(t, err)
+		}
+		for _, err := range run(sessions, protocols) {
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("Time out", func(t *testing.T) {
+		sessions := newSessions(t, devices, node.SessionConfig{Op: "count", Timeout: 50 * time.Millisecond})
+		errs := run(sessions[:2], counting(0, 0, 0)[:2])
+		var timeout *node.TimeoutError
+		require.ErrorAs(t, errs[0], &timeout)
+		assert.Equal(t, uint32(0), timeout.Round)
+		assert.Equal(t, []uint32{devices[2].Identifier()}, timeout.Missing)
+	})
+
+	t.Run("Reject sessions without the device", func(t *testing.T) {
+		_, err := node.NewSession(&devices[0], node.NewMemoryNetwork().Transport(1), node.SessionConfig{
+			Op:           "count",
+			ID:           make([]byte, node.SessionSize),
+			Participants: []uint32{devices[1].Identifier(), devices[2].Identifier()},
+			Roster:       node.Roster{devices[1].Identifier(): devices[1].DeviceKey(), devices[2].Identifier(): devices[2].DeviceKey()},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestRefreshProtocol(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	sk, err := node.ReconstructSecretKey(devices[:2])
+	require.NoError(t, err)
+
+	sessions := newSessions(t, devices, node.SessionConfig{Op: "refresh", Timeout: time.Second})
+	protocols := make([]node.Protocol, len(devices))
+	roster := node.Roster{}
+	var participants []uint32
+	for i := range devices {
+		participants = append(participants, devices[i].Identifier())
+		roster[devices[i].Identifier()] = devices[i].DeviceKey()
+	}
+	for i := range devices {
+		protocols[i] = node.NewRefreshProtocol(&devices[i], participants, roster)
+	}
+	for _, err := range run(sessions, protocols) {
+		require.NoError(t, err)
+	}
+
+	for i := range devices {
+		assert.Equal(t, uint64(1), devices[i].Epoch())
+	}
+	refreshed, err := node.ReconstructSecretKey(devices[1:])
+	require.NoError(t, err)
+	assert.Equal(t, sk, refreshed)
+
+	t.Run("Require all devices", func(t *testing.T) {
+		sessions := newSessions(t, devices[:2], node.SessionConfig{Op: "refresh"})
+		err := sessions[0].Run(context.Background(), node.NewRefreshProtocol(&devices[0], participants[:2], roster))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,66 @@
+package node
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Transport delivers the signed messages of protocol sessions between devices. Transports need not preserve the
+// order of messages, which is restored by the session layer.
+type Transport interface {
+	// Send delivers the message to the device holding the share with the given identifier.
+	Send(to uint32, m *SignedMessage) error
+	// Receive returns the channel of the messages delivered to the device.
+	Receive() <-chan *SignedMessage
+}
+
+// memoryInboxSize is the number of undelivered messages a device of a memory network can hold.
+const memoryInboxSize = 1024
+
+// MemoryNetwork connects the devices of a committee in-process, e.g. to test protocols.
+type MemoryNetwork struct {
+	mu      sync.Mutex
+	inboxes map[uint32]chan *SignedMessage
+}
+
+// NewMemoryNetwork creates a network without devices.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{inboxes: make(map[uint32]chan *SignedMessage)}
+}
+
+// Transport returns the transport of the device holding the share with the given identifier.
+func (n *MemoryNetwork) Transport(id uint32) Transport {
+	return memoryTransport{network: n, inbox: n.inbox(id)}
+}
+
+func (n *MemoryNetwork) inbox(id uint32) chan *SignedMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	inbox, ok := n.inboxes[id]
+	if !ok {
+		inbox = make(chan *SignedMessage, memoryInboxSize)
+		n.inboxes[id] = inbox
+	}
+	return inbox
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	inbox   chan *SignedMessage
+}
+
+// Send implements Transport.
+func (t memoryTransport) Send(to uint32, m *SignedMessage) error {
+	select {
+	case t.network.inbox(to) <- m:
+		return nil
+	default:
+		return errors.Errorf("inbox of device %d is full", to)
+	}
+}
+
+// Receive implements Transport.
+func (t memoryTransport) Receive() <-chan *SignedMessage {
+	return t.inbox
+}
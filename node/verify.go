@@ -0,0 +1,82 @@
+package node
+
+import (
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrShareMismatch is returned if a device's secret key share does not match its public key share.
+	ErrShareMismatch = errors.New("secret key share does not match the public key share")
+	// ErrCommitmentMismatch is returned if a device's public key share is not consistent with the Feldman commitments.
+	ErrCommitmentMismatch = errors.New("public key share does not match the Feldman commitments")
+	// ErrPublicKeyMismatch is returned if the Feldman commitments do not commit to the global public key.
+	ErrPublicKeyMismatch = errors.New("Feldman commitments do not match the global public key")
+)
+
+// Commitments returns the Feldman commitments to the coefficients of the polynomial the device's share lies on.
+func (d *Device) Commitments() []*curves.EcPoint {
+	return d.commitments
+}
+
+// PublicKeyShareOf computes the public key share of the device with the given share identifier from the Feldman
+// commitments.
+func (d *Device) PublicKeyShareOf(id uint32) (PublicKeyShare, error) {
+	if len(d.commitments) == 0 {
+		return nil, errors.Errorf("device %d has no Feldman commitments", d.deviceIdx)
+	}
+	return evalCommitments(d.commitments, id, 0)
+}
+
+// VerifyShare checks that the device's secret key share matches its public key share, that the public key share lies
+// on the polynomial committed to by the Feldman commitments, and that the commitments commit to the global public key.
+func (d *Device) VerifyShare() error {
+	if err := d.CheckShare(); err != nil {
+		return err
+	}
+	if d.publicKeyShare == nil || d.publicKeyGlobal == nil {
+		return errors.Errorf("device %d has no key share", d.deviceIdx)
+	}
+	if len(d.commitments) != int(d.t) {
+		return errors.Errorf("device %d has %d Feldman commitments, expected %d", d.deviceIdx, len(d.commitments), d.t)
+	}
+
+	if err := verifyShareAgainstPublicShare(d.secretKeyShare, d.publicKeyShare); err != nil {
+		return errors.Wrapf(ErrShareMismatch, "device %d", d.deviceIdx)
+	}
+
+	expected, err := evalCommitments(d.commitments, d.secretKeyShare.Identifier, 0)
+	if err != nil {
+		return errors.Wrapf(err, "evaluating commitments of device %d", d.deviceIdx)
+	}
+	if !expected.Equals((*curves.EcPoint)(d.publicKeyShare)) {
+		return errors.Wrapf(ErrCommitmentMismatch, "device %d", d.deviceIdx)
+	}
+
+	pk, err := curves.K256().Point.Set(d.commitments[0].X, d.commitments[0].Y)
+	if err != nil || !pk.Equal(*d.publicKeyGlobal) {
+		return errors.Wrapf(ErrPublicKeyMismatch, "device %d", d.deviceIdx)
+	}
+
+	return nil
+}
+
+// shiftCommitments adds the commitments to the coefficients of a public polynomial to the given commitments.
+func shiftCommitments(commitments []*curves.EcPoint, coeffs []*curves.Element) ([]*curves.EcPoint, error) {
+	if len(coeffs) > len(commitments) {
+		return nil, errors.New("polynomial degree exceeds the number of commitments")
+	}
+
+	shifted := make([]*curves.EcPoint, len(commitments))
+	copy(shifted, commitments)
+	for k, c := range coeffs {
+		cG, err := curves.NewScalarBaseMult(curve, c.BigInt())
+		if err != nil {
+			return nil, err
+		}
+		if shifted[k], err = shifted[k].Add(cG); err != nil {
+			return nil, err
+		}
+	}
+	return shifted, nil
+}
@@ -0,0 +1,51 @@
+package node_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestVerifyShare(t *testing.T) {
+	pkShares, skShares, pk, commitments := node.GenSharedKey(3, 5)
+	_, _, otherPk, otherCommitments := node.GenSharedKey(3, 5)
+	chainCode, err := node.NewMasterChainCode()
+	require.NoError(t, err)
+
+	t.Run("Valid share", func(t *testing.T) {
+		d, _ := node.NewDevice(0, 3, 5, pkShares[1].Point, skShares[1].ShamirShare, pk, commitments, 0, chainCode, nil)
+		assert.NoError(t, d.VerifyShare())
+	})
+
+	t.Run("Share does not match public share", func(t *testing.T) {
+		d, _ := node.NewDevice(0, 3, 5, pkShares[2].Point, skShares[1].ShamirShare, pk, commitments, 0, chainCode, nil)
+		assert.True(t, errors.Is(d.VerifyShare(), node.ErrShareMismatch))
+	})
+
+	t.Run("Share does not match commitments", func(t *testing.T) {
+		d, _ := node.NewDevice(0, 3, 5, pkShares[1].Point, skShares[1].ShamirShare, pk, otherCommitments, 0, chainCode, nil)
+		assert.True(t, errors.Is(d.VerifyShare(), node.ErrCommitmentMismatch))
+	})
+
+	t.Run("Commitments do not match public key", func(t *testing.T) {
+		d, _ := node.NewDevice(0, 3, 5, pkShares[1].Point, skShares[1].ShamirShare, otherPk, commitments, 0, chainCode, nil)
+		assert.True(t, errors.Is(d.VerifyShare(), node.ErrPublicKeyMismatch))
+	})
+}
+
+func TestVerifyShareAfterDerivationAndRefresh(t *testing.T) {
+	devices := utils.CreateDevices(3, 5)
+	require.NoError(t, node.RefreshDevices(devices))
+
+	for _, d := range devices {
+		child, err := d.DeriveNonHardenedChild(3)
+		require.NoError(t, err)
+		assert.NoError(t, child.VerifyShare())
+		assert.NoError(t, d.VerifyShare())
+	}
+}
@@ -0,0 +1,262 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/coinbase/kryptology/pkg/paillier"
+	v1 "github.com/coinbase/kryptology/pkg/sharing/v1"
+	"github.com/coinbase/kryptology/pkg/tecdsa/gg20/dealer"
+	"github.com/coinbase/kryptology/pkg/tecdsa/gg20/participant"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bip32_threshold_wallet/node"
+)
+
+// ECDSAParams are the public parameters for threshold ECDSA signing shared by a committee of devices.
+// They do not depend on the shared key and thus stay valid across derivations and refreshes.
+type ECDSAParams struct {
+	// ProofParams are the parameters of the range proofs used in the MtA conversions.
+	ProofParams *dealer.ProofParams
+	// EncryptKeys are the Paillier encryption keys of the devices, indexed by share identifier.
+	EncryptKeys map[uint32]*paillier.PublicKey
+}
+
+// ECDSASigner runs the GG20 signing protocol (https://eprint.iacr.org/2020/540) on behalf of a single device.
+// The rounds are those of the embedded participant.Signer.
+type ECDSASigner struct {
+	*participant.Signer
+	id        uint32
+	publicKey node.PublicKey // The key signatures are produced for.
+}
+
+// PublicKey returns the public key the signer produces signatures for.
+func (s *ECDSASigner) PublicKey() node.PublicKey {
+	return s.publicKey
+}
+
+// NewPaillierKey generates the Paillier key pair of a device, which requires sampling two safe primes and may take a
+// while.
+func NewPaillierKey() (*paillier.SecretKey, error) {
+	_, sk, err := paillier.NewKeys()
+	return sk, err
+}
+
+// NewECDSASigner prepares the device to sign together with the given cosigners, which must include the device itself
+// and at least t devices in total. The public key shares of the cosigners are computed from the device's Feldman
+// commitments, so that any node shared among the devices can be used, e.g. after DeriveNonHardenedChild.
+func NewECDSASigner(d *node.Device, decryptKey *paillier.SecretKey, params *ECDSAParams, cosigners []uint32) (*ECDSASigner, error) {
+	return NewECDSASignerForPath(d, decryptKey, params, cosigners, nil)
+}
+
+// NewECDSASignerForPath prepares the device to sign for the descendant at the non-hardened relative path below the
+// device's node. Since adding the same constant to all shares yields a sharing of the tweaked key, the cumulative
+// BIP32 tweak is added to the share and to all public key shares for this signature only, so that the devices only
+// ever store the shares of the parent node.
+func NewECDSASignerForPath(d *node.Device, decryptKey *paillier.SecretKey, params *ECDSAParams, cosigners []uint32, path []uint32) (*ECDSASigner, error) {
+	if uint32(len(cosigners)) < d.Threshold() {
+		return nil, errors.Errorf("need at least %d cosigners, got %d", d.Threshold(), len(cosigners))
+	}
+	if err := d.VerifyShare(); err != nil {
+		return nil, err
+	}
+
+	tweak, childPk, _, err := d.PathTweak(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "computing path tweak")
+	}
+	tweakG, err := curves.NewScalarBaseMult(btcec.S256(), tweak.BigInt())
+	if err != nil {
+		return nil, err
+	}
+	pk, err := publicKeyToEcPoint(childPk)
+	if err != nil {
+		return nil, err
+	}
+
+	publicShares := make(map[uint32]*dealer.PublicShare, len(cosigners))
+	for _, id := range cosigners {
+		pkShare, err := d.PublicKeyShareOf(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "computing public key share of cosigner %d", id)
+		}
+		if pkShare, err = (*curves.EcPoint)(pkShare).Add(tweakG); err != nil {
+			return nil, err
+		}
+		publicShares[id] = &dealer.PublicShare{Point: pkShare}
+	}
+	own, ok := publicShares[d.Identifier()]
+	if !ok {
+		return nil, errors.New("device must be one of the cosigners")
+	}
+
+	if err := d.CheckShare(); err != nil {
+		return nil, err
+	}
+	sk, _ := d.KeyPair()
+	share := &v1.ShamirShare{
+		Identifier: sk.Identifier,
+		Value:      sk.Value.Add(tweak),
+	}
+	signer, err := participant.NewSigner(&dealer.ParticipantData{
+		Id:             d.Identifier(),
+		DecryptKey:     decryptKey,
+		SecretKeyShare: &dealer.Share{ShamirShare: share, Point: own.Point},
+		EcdsaPublicKey: pk,
+		KeyGenType:     &dealer.TrustedDealerKeyGenType{ProofParams: params.ProofParams},
+		PublicShares:   publicShares,
+		EncryptKeys:    params.EncryptKeys,
+	}, cosigners)
+	if err != nil {
+		return nil, errors.Wrap(err, "preparing signer")
+	}
+
+	return &ECDSASigner{Signer: signer, id: d.Identifier(), publicKey: childPk}, nil
+}
+
+// SignECDSA runs all rounds of the threshold ECDSA signing protocol among the given devices and returns the signature
+// of the digest under their shared public key. The decryption keys are indexed by share identifier.
+func SignECDSA(devices []node.Device, decryptKeys map[uint32]*paillier.SecretKey, params *ECDSAParams, digest []byte) (*curves.EcdsaSignature, error) {
+	return SignECDSAForPath(devices, decryptKeys, params, nil, digest)
+}
+
+// SignECDSAForPath runs the threshold ECDSA signing protocol among the given devices for the descendant at the
+// non-hardened relative path below their node, see NewECDSASignerForPath.
+func SignECDSAForPath(devices []node.Device, decryptKeys map[uint32]*paillier.SecretKey, params *ECDSAParams, path []uint32, digest []byte) (*curves.EcdsaSignature, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices given")
+	}
+	if err := node.CheckDevices(devices); err != nil {
+		return nil, err
+	}
+
+	cosigners := make([]uint32, len(devices))
+	for i := range devices {
+		cosigners[i] = devices[i].Identifier()
+	}
+
+	signers := make(map[uint32]*ECDSASigner, len(devices))
+	for i := range devices {
+		s, err := NewECDSASignerForPath(&devices[i], decryptKeys[cosigners[i]], params, cosigners, path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "device %d", cosigners[i])
+		}
+		signers[cosigners[i]] = s
+	}
+
+	log.Trace("signing round 1")
+	bcast1 := make(map[uint32]*participant.Round1Bcast, len(signers))
+	p2p1 := make(map[uint32]map[uint32]*participant.Round1P2PSend, len(signers))
+	for id, s := range signers {
+		var err error
+		if bcast1[id], p2p1[id], err = s.SignRound1(); err != nil {
+			return nil, errors.Wrapf(err, "round 1 of device %d", id)
+		}
+	}
+
+	log.Trace("signing round 2")
+	p2p2 := make(map[uint32]map[uint32]*participant.P2PSend, len(signers))
+	for id, s := range signers {
+		var err error
+		if p2p2[id], err = s.SignRound2(others(bcast1, id), received(p2p1, id)); err != nil {
+			return nil, errors.Wrapf(err, "round 2 of device %d", id)
+		}
+	}
+
+	log.Trace("signing round 3")
+	bcast3 := make(map[uint32]*participant.Round3Bcast, len(signers))
+	for id, s := range signers {
+		var err error
+		if bcast3[id], err = s.SignRound3(received(p2p2, id)); err != nil {
+			return nil, errors.Wrapf(err, "round 3 of device %d", id)
+		}
+	}
+
+	log.Trace("signing round 4")
+	bcast4 := make(map[uint32]*participant.Round4Bcast, len(signers))
+	for id, s := range signers {
+		var err error
+		if bcast4[id], err = s.SignRound4(others(bcast3, id)); err != nil {
+			return nil, errors.Wrapf(err, "round 4 of device %d", id)
+		}
+	}
+
+	log.Trace("signing round 5")
+	bcast5 := make(map[uint32]*participant.Round5Bcast, len(signers))
+	p2p5 := make(map[uint32]map[uint32]*participant.Round5P2PSend, len(signers))
+	for id, s := range signers {
+		var err error
+		if bcast5[id], p2p5[id], err = s.SignRound5(others(bcast4, id)); err != nil {
+			return nil, errors.Wrapf(err, "round 5 of device %d", id)
+		}
+	}
+
+	log.Trace("signing round 6")
+	msg := reduceDigest(digest)
+	bcast6 := make(map[uint32]*participant.Round6FullBcast, len(signers))
+	for id, s := range signers {
+		var err error
+		if bcast6[id], err = s.SignRound6Full(msg, others(bcast5, id), received(p2p5, id)); err != nil {
+			return nil, errors.Wrapf(err, "round 6 of device %d", id)
+		}
+	}
+
+	sig, err := signers[cosigners[0]].SignOutput(others(bcast6, cosigners[0]))
+	if err != nil {
+		return nil, errors.Wrap(err, "combining signature")
+	}
+	if !VerifyECDSA(signers[cosigners[0]].PublicKey(), digest, sig) {
+		return nil, errors.New("combined signature is invalid")
+	}
+
+	return sig, nil
+}
+
+// VerifyECDSA verifies an ECDSA signature of the digest under the given public key.
+func VerifyECDSA(pk node.PublicKey, digest []byte, sig *curves.EcdsaSignature) bool {
+	pkEc, err := publicKeyToEcPoint(pk)
+	if err != nil {
+		return false
+	}
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: btcec.S256(), X: pkEc.X, Y: pkEc.Y}, digest, sig.R, sig.S)
+}
+
+// reduceDigest reduces the digest modulo the group order, as GG20 expects it to be a field element. ECDSA reduces
+// the digest anyway, hence the signature stays valid for the original digest.
+func reduceDigest(digest []byte) []byte {
+	m := new(big.Int).SetBytes(digest)
+	return m.Mod(m, btcec.S256().N).Bytes()
+}
+
+func publicKeyToEcPoint(pk node.PublicKey) (*curves.EcPoint, error) {
+	if pk == nil {
+		return nil, errors.New("missing public key")
+	}
+	b := (*pk).ToAffineUncompressed()
+	return curves.PointFromBytesUncompressed(btcec.S256(), b[1:])
+}
+
+// others returns the messages of all devices except the one with the given identifier.
+func others[T any](msgs map[uint32]T, id uint32) map[uint32]T {
+	out := make(map[uint32]T, len(msgs))
+	for j, m := range msgs {
+		if j != id {
+			out[j] = m
+		}
+	}
+	return out
+}
+
+// received returns the point-to-point messages sent to the device with the given identifier.
+func received[T any](msgs map[uint32]map[uint32]T, id uint32) map[uint32]T {
+	out := make(map[uint32]T, len(msgs))
+	for j, m := range msgs {
+		if msg, ok := m[id]; ok && j != id {
+			out[j] = msg
+		}
+	}
+	return out
+}
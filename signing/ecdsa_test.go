@@ -0,0 +1,32 @@
+package signing_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/internal/testutil"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestSignECDSAWithChildShares(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	params, decryptKeys := testutil.CreateECDSAParams(3)
+
+	children, err := derivation.NewNonHardDerivation(devices).DeriveNonHardenedChild(5)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	sig, err := signing.SignECDSA(children[1:], decryptKeys, params, digest[:])
+	require.NoError(t, err)
+
+	assert.True(t, signing.VerifyECDSA(children[0].PublicKey(), digest[:], sig))
+	assert.False(t, signing.VerifyECDSA(devices[0].PublicKey(), digest[:], sig), "signature must be for the child key")
+
+	_, err = signing.SignECDSA(children[:1], decryptKeys, params, digest[:])
+	assert.Error(t, err, "a single device must not be able to sign")
+}
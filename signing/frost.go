@@ -0,0 +1,450 @@
+package signing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bip32_threshold_wallet/node"
+)
+
+// The implementation of the two-round FROST threshold Schnorr signature (https://eprint.iacr.org/2020/852), producing
+// BIP340 signatures for possibly tweaked keys.
+
+// FrostCommitment is a device's public commitment (D, E) to its nonces, broadcast in the first round.
+type FrostCommitment struct {
+	Identifier uint32
+	D          curves.Point
+	E          curves.Point
+}
+
+// FrostPartialSignature is a device's share z_i of the signature, broadcast in the second round.
+type FrostPartialSignature struct {
+	Identifier uint32
+	Z          curves.Scalar
+}
+
+type frostNonce struct {
+	d curves.Scalar
+	e curves.Scalar
+}
+
+// FrostSigner runs FROST on behalf of a single device.
+// Nonces are kept until they are used in the second round and are deleted afterwards, so that no nonce is ever
+// used for two signatures.
+type FrostSigner struct {
+	device *node.Device
+	nonces map[string]frostNonce
+}
+
+// NewFrostSigner creates a FROST signer for the device.
+func NewFrostSigner(d *node.Device) (*FrostSigner, error) {
+	if err := d.VerifyShare(); err != nil {
+		return nil, err
+	}
+	return &FrostSigner{
+		device: d,
+		nonces: make(map[string]frostNonce),
+	}, nil
+}
+
+// Round1 samples a fresh nonce pair (d, e) and returns the commitment (D, E) = (d*G, e*G).
+func (s *FrostSigner) Round1() (*FrostCommitment, error) {
+	nonce := frostNonce{
+		d: k256.Scalar.Random(rand.Reader),
+		e: k256.Scalar.Random(rand.Reader),
+	}
+	if nonce.d.IsZero() || nonce.e.IsZero() {
+		return nil, errors.New("sampled invalid nonce")
+	}
+
+	c := &FrostCommitment{
+		Identifier: s.device.Identifier(),
+		D:          k256.ScalarBaseMult(nonce.d),
+		E:          k256.ScalarBaseMult(nonce.e),
+	}
+	s.nonces[c.key()] = nonce
+	return c, nil
+}
+
+// Discard deletes the nonce committed to in the commitment without using it, e.g. once it has expired.
+func (s *FrostSigner) Discard(c *FrostCommitment) {
+	delete(s.nonces, c.key())
+}
+
+// Round2 computes the device's signature share of the message for the given key, using the nonce committed to in
+// its commitment among the commitments of all signers.
+func (s *FrostSigner) Round2(key *SchnorrKey, msg []byte, commitments []*FrostCommitment) (*FrostPartialSignature, error) {
+	return s.round2(key, msg, commitments, nil)
+}
+
+// round2 implements Round2. If given, persist is called after the nonce has been deleted and before the signature share
+// is released, so that a nonce whose deletion could not be persisted is never used.
+func (s *FrostSigner) round2(key *SchnorrKey, msg []byte, commitments []*FrostCommitment, persist func() error) (*FrostPartialSignature, error) {
+	id := s.device.Identifier()
+	var own *FrostCommitment
+	for _, c := range commitments {
+		if c.Identifier == id {
+			own = c
+		}
+	}
+	if own == nil {
+		return nil, errors.New("commitments do not contain the device's commitment")
+	}
+	nonce, ok := s.nonces[own.key()]
+	if !ok {
+		return nil, errors.New("unknown or already used nonce")
+	}
+	// Delete the nonce before using it, so it is never used twice even if signing fails.
+	delete(s.nonces, own.key())
+
+	session, err := newFrostSession(key, msg, commitments, s.device.Threshold())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.device.CheckShare(); err != nil {
+		return nil, err
+	}
+	sk, _ := s.device.KeyPair()
+	share, err := k256.Scalar.SetBigInt(sk.Value.BigInt())
+	if err != nil {
+		return nil, errors.Wrap(err, "converting key share")
+	}
+
+	// z_i = gR*(d_i + rho_i*e_i) + c*lambda_i*gKey*s_i
+	z := nonce.d.Add(nonce.e.Mul(session.rho[id]))
+	z = z.Mul(session.gR)
+	z = z.Add(session.c.Mul(session.lambda[id]).Mul(key.signFactor()).Mul(share))
+
+	if persist != nil {
+		if err := persist(); err != nil {
+			return nil, errors.Wrap(err, "persisting used nonce")
+		}
+	}
+
+	return &FrostPartialSignature{Identifier: id, Z: z}, nil
+}
+
+// AggregateFrost verifies the signature shares against the public key shares of the signers and combines them into a
+// 64-byte BIP340 signature of the message under the key's x-only public key. The public key shares are taken from the
+// Feldman commitments of the given device.
+func AggregateFrost(d *node.Device, key *SchnorrKey, msg []byte, commitments []*FrostCommitment, partials []*FrostPartialSignature) ([]byte, error) {
+	session, err := newFrostSession(key, msg, commitments, d.Threshold())
+	if err != nil {
+		return nil, err
+	}
+	if len(partials) != len(commitments) {
+		return nil, errors.Errorf("expected %d signature shares, got %d", len(commitments), len(partials))
+	}
+
+	byId := make(map[uint32]*FrostCommitment, len(commitments))
+	for _, c := range commitments {
+		byId[c.Identifier] = c
+	}
+
+	z := key.tweakTerm().Mul(session.c)
+	seen := make(map[uint32]bool, len(partials))
+	for _, p := range partials {
+		c, ok := byId[p.Identifier]
+		if !ok || seen[p.Identifier] {
+			return nil, errors.Errorf("unexpected signature share of device %d", p.Identifier)
+		}
+		seen[p.Identifier] = true
+
+		pkShareEc, err := d.PublicKeyShareOf(p.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		pkShare, err := k256.Point.Set(pkShareEc.X, pkShareEc.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		// z_i*G = gR*(D_i + rho_i*E_i) + c*lambda_i*gKey*Y_i
+		expected := c.D.Add(c.E.Mul(session.rho[p.Identifier])).Mul(session.gR)
+		expected = expected.Add(pkShare.Mul(session.c.Mul(session.lambda[p.Identifier]).Mul(key.signFactor())))
+		if !k256.ScalarBaseMult(p.Z).Equal(expected) {
+			return nil, errors.Errorf("signature share of device %d is invalid", p.Identifier)
+		}
+
+		z = z.Add(p.Z)
+	}
+
+	sig := make([]byte, 0, 64)
+	sig = append(sig, xOnly(session.r)...)
+	sig = append(sig, z.Bytes()...)
+
+	if !VerifySchnorr(key.XOnly(), msg, sig) {
+		return nil, errors.New("combined signature is invalid")
+	}
+	return sig, nil
+}
+
+// SignSchnorr runs both rounds of FROST among the given devices and returns the BIP340 signature of the message under
+// the given key.
+func SignSchnorr(devices []node.Device, key *SchnorrKey, msg []byte) ([]byte, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices given")
+	}
+	if err := node.CheckDevices(devices); err != nil {
+		return nil, err
+	}
+
+	signers := make([]*FrostSigner, len(devices))
+	commitments := make([]*FrostCommitment, len(devices))
+	log.Trace("FROST round 1")
+	for i := range devices {
+		s, err := NewFrostSigner(&devices[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "device %d", devices[i].Identifier())
+		}
+		signers[i] = s
+		if commitments[i], err = s.Round1(); err != nil {
+			return nil, errors.Wrapf(err, "round 1 of device %d", devices[i].Identifier())
+		}
+	}
+
+	log.Trace("FROST round 2")
+	partials := make([]*FrostPartialSignature, len(devices))
+	for i, s := range signers {
+		var err error
+		if partials[i], err = s.Round2(key, msg, commitments); err != nil {
+			return nil, errors.Wrapf(err, "round 2 of device %d", devices[i].Identifier())
+		}
+	}
+
+	return AggregateFrost(&devices[0], key, msg, commitments, partials)
+}
+
+// FrostProtocol runs FROST for a device as a session protocol among the signers. In the first round, each signer
+// broadcasts its nonce commitment, in the second its signature share, after which every signer aggregates and verifies
+// the signature. The nonce of the device is only kept by the protocol, so that it is dropped with it if the session
+// fails.
+type FrostProtocol struct {
+	signer      *FrostSigner
+	key         *SchnorrKey
+	msg         []byte
+	commitments []*FrostCommitment
+	partials    []*FrostPartialSignature
+	signature   []byte
+}
+
+// NewFrostProtocol creates the FROST protocol of the device signing the message under the given key.
+func NewFrostProtocol(d *node.Device, key *SchnorrKey, msg []byte) (*FrostProtocol, error) {
+	signer, err := NewFrostSigner(d)
+	if err != nil {
+		return nil, err
+	}
+	return &FrostProtocol{signer: signer, key: key, msg: msg}, nil
+}
+
+// Signature returns the 64-byte BIP340 signature once the protocol has finished.
+func (p *FrostProtocol) Signature() []byte {
+	return p.signature
+}
+
+// Start implements node.Protocol.
+func (p *FrostProtocol) Start() ([]node.Outgoing, error) {
+	c, err := p.signer.Round1()
+	if err != nil {
+		return nil, err
+	}
+	p.commitments = []*FrostCommitment{c}
+	return broadcast(c)
+}
+
+// Advance implements node.Protocol.
+func (p *FrostProtocol) Advance(round uint32, received map[uint32][]byte) ([]node.Outgoing, bool, error) {
+	switch round {
+	case 0:
+		for sender, body := range received {
+			var c FrostCommitment
+			if err := json.Unmarshal(body, &c); err != nil {
+				return nil, false, errors.Wrapf(err, "decoding commitment of device %d", sender)
+			}
+			if c.Identifier != sender {
+				return nil, false, errors.Errorf("commitment of device %d is sent by device %d", c.Identifier, sender)
+			}
+			p.commitments = append(p.commitments, &c)
+		}
+		partial, err := p.signer.Round2(p.key, p.msg, p.commitments)
+		if err != nil {
+			return nil, false, err
+		}
+		p.partials = []*FrostPartialSignature{partial}
+		out, err := broadcast(partial)
+		return out, false, err
+	case 1:
+		for sender, body := range received {
+			var partial FrostPartialSignature
+			if err := json.Unmarshal(body, &partial); err != nil {
+				return nil, false, errors.Wrapf(err, "decoding signature share of device %d", sender)
+			}
+			if partial.Identifier != sender {
+				return nil, false, errors.Errorf("signature share of device %d is sent by device %d", partial.Identifier, sender)
+			}
+			p.partials = append(p.partials, &partial)
+		}
+		var err error
+		p.signature, err = AggregateFrost(p.signer.device, p.key, p.msg, p.commitments, p.partials)
+		return nil, true, err
+	default:
+		return nil, false, errors.Errorf("unexpected round %d", round)
+	}
+}
+
+// broadcast encodes the message for all other signers.
+func broadcast(v interface{}) ([]node.Outgoing, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding FROST message")
+	}
+	return []node.Outgoing{{To: node.Broadcast, Body: body}}, nil
+}
+
+// frostSession holds the values all signers derive from the signing set, the key and the message.
+type frostSession struct {
+	rho    map[uint32]curves.Scalar
+	lambda map[uint32]curves.Scalar
+	r      curves.Point
+	gR     curves.Scalar // Normalizes R to an even Y coordinate.
+	c      curves.Scalar
+}
+
+func newFrostSession(key *SchnorrKey, msg []byte, commitments []*FrostCommitment, t uint32) (*frostSession, error) {
+	if uint32(len(commitments)) < t {
+		return nil, errors.Errorf("need at least %d signers, got %d", t, len(commitments))
+	}
+
+	sorted := make([]*FrostCommitment, len(commitments))
+	copy(sorted, commitments)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Identifier < sorted[j].Identifier })
+
+	ids := make([]uint32, len(sorted))
+	encoded := make([]byte, 0, len(sorted)*(4+2*33))
+	for i, c := range sorted {
+		if i > 0 && sorted[i-1].Identifier == c.Identifier {
+			return nil, errors.Errorf("duplicate commitment of device %d", c.Identifier)
+		}
+		if c.D.IsIdentity() || c.E.IsIdentity() {
+			return nil, errors.Errorf("invalid commitment of device %d", c.Identifier)
+		}
+		ids[i] = c.Identifier
+		encoded = append(encoded, c.key()...)
+	}
+
+	s := &frostSession{
+		rho:    make(map[uint32]curves.Scalar, len(sorted)),
+		lambda: make(map[uint32]curves.Scalar, len(sorted)),
+		r:      k256.Point.Identity(),
+	}
+	for _, c := range sorted {
+		// rho_i = H(i || Q || msg || B)
+		data := make([]byte, 0, 4+32+len(msg)+len(encoded))
+		data = binary.BigEndian.AppendUint32(data, c.Identifier)
+		data = append(data, key.XOnly()...)
+		data = append(data, msg...)
+		data = append(data, encoded...)
+		s.rho[c.Identifier] = reduceScalar(TaggedHash("FROST/rho", data))
+
+		s.lambda[c.Identifier] = lagrangeCoefficient(c.Identifier, ids)
+		s.r = s.r.Add(c.D.Add(c.E.Mul(s.rho[c.Identifier])))
+	}
+	if s.r.IsIdentity() {
+		return nil, errors.New("group commitment is invalid")
+	}
+
+	s.gR = k256.Scalar.One()
+	if !hasEvenY(s.r) {
+		s.gR = s.gR.Neg()
+	}
+	s.c = bip340Challenge(xOnly(s.r), key.XOnly(), msg)
+
+	return s, nil
+}
+
+// key encodes the commitment, identifying the nonces it commits to.
+func (c *FrostCommitment) key() string {
+	b := make([]byte, 0, 4+2*33)
+	b = binary.BigEndian.AppendUint32(b, c.Identifier)
+	b = append(b, c.D.ToAffineCompressed()...)
+	b = append(b, c.E.ToAffineCompressed()...)
+	return string(b)
+}
+
+// lagrangeCoefficient computes the Lagrange coefficient of id at 0 with respect to the given identifiers.
+func lagrangeCoefficient(id uint32, ids []uint32) curves.Scalar {
+	lambda := k256.Scalar.One()
+	x := k256.Scalar.New(int(id))
+	for _, k := range ids {
+		if k == id {
+			continue
+		}
+		xk := k256.Scalar.New(int(k))
+		lambda = lambda.Mul(xk.Div(xk.Sub(x)))
+	}
+	return lambda
+}
+
+type frostCommitmentJSON struct {
+	Identifier uint32 `json:"identifier"`
+	D          []byte `json:"d"`
+	E          []byte `json:"e"`
+}
+
+// MarshalJSON encodes the commitment with compressed points, e.g. to send it to the other devices.
+func (c FrostCommitment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(frostCommitmentJSON{
+		Identifier: c.Identifier,
+		D:          c.D.ToAffineCompressed(),
+		E:          c.E.ToAffineCompressed(),
+	})
+}
+
+// UnmarshalJSON decodes a commitment encoded with MarshalJSON.
+func (c *FrostCommitment) UnmarshalJSON(data []byte) error {
+	var encoded frostCommitmentJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	d, err := k256.Point.FromAffineCompressed(encoded.D)
+	if err != nil {
+		return errors.Wrap(err, "decoding commitment")
+	}
+	e, err := k256.Point.FromAffineCompressed(encoded.E)
+	if err != nil {
+		return errors.Wrap(err, "decoding commitment")
+	}
+	*c = FrostCommitment{Identifier: encoded.Identifier, D: d, E: e}
+	return nil
+}
+
+type frostPartialSignatureJSON struct {
+	Identifier uint32 `json:"identifier"`
+	Z          []byte `json:"z"`
+}
+
+// MarshalJSON encodes the signature share.
+func (p FrostPartialSignature) MarshalJSON() ([]byte, error) {
+	return json.Marshal(frostPartialSignatureJSON{Identifier: p.Identifier, Z: p.Z.Bytes()})
+}
+
+// UnmarshalJSON decodes a signature share encoded with MarshalJSON.
+func (p *FrostPartialSignature) UnmarshalJSON(data []byte) error {
+	var encoded frostPartialSignatureJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	z, err := k256.Scalar.SetBytes(encoded.Z)
+	if err != nil {
+		return errors.Wrap(err, "decoding signature share")
+	}
+	*p = FrostPartialSignature{Identifier: encoded.Identifier, Z: z}
+	return nil
+}
@@ -0,0 +1,142 @@
+package signing_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestSignSchnorr(t *testing.T) {
+	devices := utils.CreateDevices(3, 5)
+	children, err := derivation.NewNonHardDerivation(devices).DeriveNonHardenedChild(1)
+	require.NoError(t, err)
+	msg := sha256.Sum256([]byte("Hello, Taproot!"))
+
+	t.Run("BIP340", func(t *testing.T) {
+		key := signing.NewBIP340Key(children[0].PublicKey())
+		sig, err := signing.SignSchnorr(children[:3], key, msg[:])
+		require.NoError(t, err)
+		assert.True(t, signing.VerifySchnorr(key.XOnly(), msg[:], sig))
+	})
+
+	t.Run("BIP341 key path", func(t *testing.T) {
+		for _, merkleRoot := range [][]byte{nil, msg[:]} {
+			key, err := signing.NewTaprootKey(children[0].PublicKey(), merkleRoot)
+			require.NoError(t, err)
+
+			sig, err := signing.SignSchnorr(children[1:4], key, msg[:])
+			require.NoError(t, err)
+			assert.True(t, signing.VerifySchnorr(key.XOnly(), msg[:], sig))
+
+			internal := signing.NewBIP340Key(children[0].PublicKey())
+			assert.False(t, signing.VerifySchnorr(internal.XOnly(), msg[:], sig), "signature must be for the tweaked key")
+		}
+	})
+
+	t.Run("Not enough signers", func(t *testing.T) {
+		key := signing.NewBIP340Key(children[0].PublicKey())
+		_, err := signing.SignSchnorr(children[:2], key, msg[:])
+		assert.Error(t, err)
+	})
+
+	t.Run("Nonces are single-use", func(t *testing.T) {
+		key := signing.NewBIP340Key(devices[0].PublicKey())
+		signers := make([]*signing.FrostSigner, 3)
+		commitments := make([]*signing.FrostCommitment, 3)
+		for i := range signers {
+			signers[i], err = signing.NewFrostSigner(&devices[i])
+			require.NoError(t, err)
+			commitments[i], err = signers[i].Round1()
+			require.NoError(t, err)
+		}
+
+		_, err := signers[0].Round2(key, msg[:], commitments)
+		require.NoError(t, err)
+		_, err = signers[0].Round2(key, msg[:], commitments)
+		assert.Error(t, err)
+	})
+
+	t.Run("Reject devices left behind by a refresh", func(t *testing.T) {
+		stale := make([]node.Device, len(devices))
+		copy(stale, devices)
+		require.NoError(t, node.RefreshDevices(devices))
+
+		key := signing.NewBIP340Key(devices[0].PublicKey())
+		_, err := signing.SignSchnorr(stale[:3], key, msg[:])
+		assert.ErrorIs(t, err, node.ErrRetiredShare)
+		sig, err := signing.SignSchnorr(devices[:3], key, msg[:])
+		require.NoError(t, err)
+		assert.True(t, signing.VerifySchnorr(key.XOnly(), msg[:], sig))
+	})
+}
+
+func TestFrostProtocol(t *testing.T) {
+	devices := utils.CreateDevices(3, 5)
+	signers := devices[1:4]
+	key := signing.NewBIP340Key(devices[0].PublicKey())
+	msg := sha256.Sum256([]byte("Hello, sessions!"))
+
+	id, err := node.NewSessionID()
+	require.NoError(t, err)
+	network := node.NewMemoryNetwork()
+	cfg := node.SessionConfig{Op: "sign", ID: id, Roster: node.Roster{}, Timeout: time.Second}
+	for i := range signers {
+		cfg.Participants = append(cfg.Participants, signers[i].Identifier())
+		cfg.Roster[signers[i].Identifier()] = signers[i].DeviceKey()
+	}
+
+	protocols := make([]*signing.FrostProtocol, len(signers))
+	errs := make([]error, len(signers))
+	var wg sync.WaitGroup
+	for i := range signers {
+		session, err := node.NewSession(&signers[i], network.Transport(signers[i].Identifier()), cfg)
+		require.NoError(t, err)
+		protocols[i], err = signing.NewFrostProtocol(&signers[i], key, msg[:])
+		require.NoError(t, err)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = session.Run(context.Background(), protocols[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range signers {
+		require.NoError(t, errs[i])
+		assert.True(t, signing.VerifySchnorr(key.XOnly(), msg[:], protocols[i].Signature()))
+	}
+}
+
+// Test vector 1 of BIP340.
+func TestVerifySchnorr(t *testing.T) {
+	pk, _ := hex.DecodeString("DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659")
+	msg, _ := hex.DecodeString("243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89")
+	sig, _ := hex.DecodeString("6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A")
+
+	assert.True(t, signing.VerifySchnorr(pk, msg, sig))
+	msg[0] ^= 1
+	assert.False(t, signing.VerifySchnorr(pk, msg, sig))
+}
+
+// First receiving address of BIP86.
+func TestTaprootKey(t *testing.T) {
+	internal, _ := hex.DecodeString("02cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115")
+	pk, err := curves.K256().Point.FromAffineCompressed(internal)
+	require.NoError(t, err)
+
+	key, err := signing.NewTaprootKey(&pk, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c", hex.EncodeToString(key.XOnly()))
+}
@@ -0,0 +1,72 @@
+package signing_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/internal/testutil"
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestSignForPath(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	path := []uint32{0, 5}
+
+	// The key the devices would share after deriving the descendant's shares.
+	descendants := devices
+	for _, idx := range path {
+		var err error
+		descendants, err = derivation.NewNonHardDerivation(descendants).DeriveNonHardenedChild(idx)
+		require.NoError(t, err)
+	}
+	childPk := descendants[0].PublicKey()
+
+	_, pk, chainCode, err := devices[0].PathTweak(path)
+	require.NoError(t, err)
+	assert.True(t, (*pk).Equal(*childPk))
+	assert.Equal(t, descendants[0].ChainCode(), chainCode)
+
+	digest := sha256.Sum256([]byte("Hello, World!"))
+
+	t.Run("ECDSA", func(t *testing.T) {
+		params, decryptKeys := testutil.CreateECDSAParams(3)
+		sig, err := signing.SignECDSAForPath(devices[1:], decryptKeys, params, path, digest[:])
+		require.NoError(t, err)
+		assert.True(t, signing.VerifyECDSA(childPk, digest[:], sig))
+		assert.False(t, signing.VerifyECDSA(devices[0].PublicKey(), digest[:], sig), "signature must be for the descendant key")
+	})
+
+	t.Run("BIP340", func(t *testing.T) {
+		key, err := signing.NewBIP340KeyForPath(&devices[0], path)
+		require.NoError(t, err)
+		assert.Equal(t, signing.NewBIP340Key(childPk).XOnly(), key.XOnly())
+
+		sig, err := signing.SignSchnorr(devices[:2], key, digest[:])
+		require.NoError(t, err)
+		assert.True(t, signing.VerifySchnorr(key.XOnly(), digest[:], sig))
+	})
+
+	t.Run("BIP341 key path", func(t *testing.T) {
+		key, err := signing.NewBIP340KeyForPath(&devices[0], path)
+		require.NoError(t, err)
+		require.NoError(t, key.TaprootTweak(nil))
+		expected, err := signing.NewTaprootKey(childPk, nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected.XOnly(), key.XOnly())
+
+		sig, err := signing.SignSchnorr(devices[1:], key, digest[:])
+		require.NoError(t, err)
+		assert.True(t, signing.VerifySchnorr(expected.XOnly(), digest[:], sig))
+	})
+
+	t.Run("Hardened index", func(t *testing.T) {
+		_, err := signing.NewBIP340KeyForPath(&devices[0], []uint32{bip32.FirstHardenedChild})
+		assert.Error(t, err)
+	})
+}
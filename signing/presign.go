@@ -0,0 +1,301 @@
+package signing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"bip32_threshold_wallet/node"
+)
+
+// The preprocessing variant of FROST: in an offline phase, each device samples a batch of nonce pairs and publishes
+// their commitments. A coordinator hands out each published commitment once, so that the online phase only consists of
+// the devices sending their signature shares.
+
+// PresignVersion is the version of the presignature pool format.
+const PresignVersion = 1
+
+const presignStorageLabel = "bip32_threshold_wallet/presignatures"
+
+type presignHeader struct {
+	Version    uint32 `json:"version"`
+	Identifier uint32 `json:"identifier"`
+	Nonce      []byte `json:"nonce"`
+}
+
+type presignFile struct {
+	presignHeader
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type presignNonce struct {
+	D []byte `json:"d"`
+	E []byte `json:"e"`
+}
+
+// PresignPool is a device's pool of single-use presignatures, i.e. nonce pairs whose commitments have been published.
+// If the pool is backed by a file, it is encrypted with a key derived from the device key and rewritten whenever a
+// presignature is added or consumed, before the corresponding commitments or signature shares are released.
+type PresignPool struct {
+	signer *FrostSigner
+	path   string
+	aead   cipher.AEAD
+}
+
+// NewPresignPool creates the presignature pool of the device, backed by the file at the given path. If the file exists,
+// the presignatures not consumed yet are loaded from it. An empty path creates a pool kept in memory only.
+func NewPresignPool(d *node.Device, path string) (*PresignPool, error) {
+	signer, err := NewFrostSigner(d)
+	if err != nil {
+		return nil, err
+	}
+	p := &PresignPool{signer: signer, path: path}
+	if path == "" {
+		return p, nil
+	}
+
+	key, err := d.StorageKey(presignStorageLabel)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if p.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading presignatures")
+	}
+	if err := p.load(data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Identifier returns the share identifier of the device the pool belongs to.
+func (p *PresignPool) Identifier() uint32 {
+	return p.signer.device.Identifier()
+}
+
+// Len returns the number of presignatures not consumed yet.
+func (p *PresignPool) Len() int {
+	return len(p.signer.nonces)
+}
+
+// Generate samples k presignatures and returns their commitments, which are to be published to the coordinator.
+func (p *PresignPool) Generate(k int) ([]*FrostCommitment, error) {
+	commitments := make([]*FrostCommitment, k)
+	for i := range commitments {
+		var err error
+		if commitments[i], err = p.signer.Round1(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.save(); err != nil {
+		// Drop the presignatures, as they would be lost on restart.
+		for _, c := range commitments {
+			delete(p.signer.nonces, c.key())
+		}
+		return nil, errors.Wrap(err, "persisting presignatures")
+	}
+	return commitments, nil
+}
+
+// Sign consumes the presignature committed to in the device's commitment among the given commitments and returns the
+// device's signature share of the message for the given key.
+func (p *PresignPool) Sign(key *SchnorrKey, msg []byte, commitments []*FrostCommitment) (*FrostPartialSignature, error) {
+	return p.signer.round2(key, msg, commitments, p.save)
+}
+
+// Commitments returns the commitments of the presignatures not consumed yet, e.g. to republish them to a coordinator
+// after a restart.
+func (p *PresignPool) Commitments() []*FrostCommitment {
+	commitments := make([]*FrostCommitment, 0, len(p.signer.nonces))
+	for _, n := range p.signer.nonces {
+		commitments = append(commitments, &FrostCommitment{
+			Identifier: p.Identifier(),
+			D:          k256.ScalarBaseMult(n.d),
+			E:          k256.ScalarBaseMult(n.e),
+		})
+	}
+	return commitments
+}
+
+func (p *PresignPool) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	nonces := make([]presignNonce, 0, len(p.signer.nonces))
+	for _, n := range p.signer.nonces {
+		nonces = append(nonces, presignNonce{D: n.d.Bytes(), E: n.e.Bytes()})
+	}
+	plaintext, err := json.Marshal(nonces)
+	if err != nil {
+		return err
+	}
+	defer wipeNonces(plaintext, nonces)
+
+	header := presignHeader{
+		Version:    PresignVersion,
+		Identifier: p.Identifier(),
+		Nonce:      make([]byte, p.aead.NonceSize()),
+	}
+	if _, err := rand.Read(header.Nonce); err != nil {
+		return errors.Wrap(err, "sampling nonce")
+	}
+	ad, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(presignFile{
+		presignHeader: header,
+		Ciphertext:    p.aead.Seal(nil, header.Nonce, plaintext, ad),
+	})
+	if err != nil {
+		return err
+	}
+	return node.WriteSecretFile(p.path, data)
+}
+
+func (p *PresignPool) load(data []byte) error {
+	var file presignFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return errors.Wrap(err, "decoding presignatures")
+	}
+	if file.Version != PresignVersion {
+		return errors.Errorf("unsupported presignature version %d", file.Version)
+	}
+	if file.Identifier != p.Identifier() {
+		return errors.Errorf("presignatures belong to device %d", file.Identifier)
+	}
+	if len(file.Nonce) != p.aead.NonceSize() {
+		return errors.New("invalid nonce")
+	}
+	ad, err := json.Marshal(file.presignHeader)
+	if err != nil {
+		return err
+	}
+	plaintext, err := p.aead.Open(nil, file.Nonce, file.Ciphertext, ad)
+	if err != nil {
+		return errors.New("presignatures cannot be decrypted with the device key")
+	}
+
+	var nonces []presignNonce
+	if err := json.Unmarshal(plaintext, &nonces); err != nil {
+		return errors.Wrap(err, "decoding presignatures")
+	}
+	defer wipeNonces(plaintext, nonces)
+
+	for _, n := range nonces {
+		d, err := k256.Scalar.SetBytes(n.D)
+		if err != nil {
+			return errors.Wrap(err, "decoding presignature")
+		}
+		e, err := k256.Scalar.SetBytes(n.E)
+		if err != nil {
+			return errors.Wrap(err, "decoding presignature")
+		}
+		c := &FrostCommitment{
+			Identifier: p.Identifier(),
+			D:          k256.ScalarBaseMult(d),
+			E:          k256.ScalarBaseMult(e),
+		}
+		p.signer.nonces[c.key()] = frostNonce{d: d, e: e}
+	}
+	return nil
+}
+
+// PresignQueue is kept by the coordinator of the online phase. It queues the published commitments of each device and
+// hands out each of them once. Handing out a commitment twice is harmless, as the device refuses to sign with a consumed
+// presignature, hence the queue need not be persisted.
+type PresignQueue struct {
+	commitments map[uint32][]*FrostCommitment
+}
+
+// NewPresignQueue creates an empty queue.
+func NewPresignQueue() *PresignQueue {
+	return &PresignQueue{commitments: make(map[uint32][]*FrostCommitment)}
+}
+
+// Add queues published commitments.
+func (q *PresignQueue) Add(commitments []*FrostCommitment) {
+	for _, c := range commitments {
+		q.commitments[c.Identifier] = append(q.commitments[c.Identifier], c)
+	}
+}
+
+// Available returns the number of queued commitments of the device with the given identifier.
+func (q *PresignQueue) Available(id uint32) int {
+	return len(q.commitments[id])
+}
+
+// Next dequeues one commitment of each of the given signers. Nothing is dequeued if any signer has none left.
+func (q *PresignQueue) Next(signers []uint32) ([]*FrostCommitment, error) {
+	for _, id := range signers {
+		if q.Available(id) == 0 {
+			return nil, errors.Errorf("no presignature of device %d left", id)
+		}
+	}
+
+	commitments := make([]*FrostCommitment, len(signers))
+	for i, id := range signers {
+		commitments[i] = q.commitments[id][0]
+		q.commitments[id] = q.commitments[id][1:]
+	}
+	return commitments, nil
+}
+
+// SignSchnorrPresigned runs the online phase among the devices of the given pools, consuming one presignature of each,
+// and returns the BIP340 signature of the message under the given key.
+func SignSchnorrPresigned(pools []*PresignPool, q *PresignQueue, key *SchnorrKey, msg []byte) ([]byte, error) {
+	if len(pools) == 0 {
+		return nil, errors.New("no devices given")
+	}
+
+	signers := make([]uint32, len(pools))
+	for i, p := range pools {
+		signers[i] = p.Identifier()
+	}
+	commitments, err := q.Next(signers)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace("FROST online round")
+	partials := make([]*FrostPartialSignature, len(pools))
+	for i, p := range pools {
+		if partials[i], err = p.Sign(key, msg, commitments); err != nil {
+			return nil, errors.Wrapf(err, "device %d", signers[i])
+		}
+	}
+
+	return AggregateFrost(pools[0].signer.device, key, msg, commitments, partials)
+}
+
+func wipeNonces(plaintext []byte, nonces []presignNonce) {
+	for i := range plaintext {
+		plaintext[i] = 0
+	}
+	for _, n := range nonces {
+		for i := range n.D {
+			n.D[i] = 0
+		}
+		for i := range n.E {
+			n.E[i] = 0
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package signing_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/signing"
+	"bip32_threshold_wallet/utils"
+)
+
+func TestPresignPool(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	dir := t.TempDir()
+	key := signing.NewBIP340Key(devices[0].PublicKey())
+	msg := sha256.Sum256([]byte("Hello, World!"))
+
+	pools := make([]*signing.PresignPool, len(devices))
+	q := signing.NewPresignQueue()
+	for i := range devices {
+		var err error
+		pools[i], err = signing.NewPresignPool(&devices[i], filepath.Join(dir, fmt.Sprintf("presign%d.json", i)))
+		require.NoError(t, err)
+		commitments, err := pools[i].Generate(2)
+		require.NoError(t, err)
+		q.Add(commitments)
+	}
+
+	sig, err := signing.SignSchnorrPresigned(pools[:2], q, key, msg[:])
+	require.NoError(t, err)
+	assert.True(t, signing.VerifySchnorr(key.XOnly(), msg[:], sig))
+	assert.Equal(t, 1, pools[0].Len())
+	assert.Equal(t, 2, pools[2].Len())
+
+	t.Run("Persisted", func(t *testing.T) {
+		restored, err := signing.NewPresignPool(&devices[0], filepath.Join(dir, "presign0.json"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, restored.Len())
+		assert.ElementsMatch(t, pools[0].Commitments(), restored.Commitments())
+
+		_, err = signing.NewPresignPool(&devices[1], filepath.Join(dir, "presign0.json"))
+		assert.Error(t, err, "presignatures of another device must not be loaded")
+	})
+
+	t.Run("Single-use", func(t *testing.T) {
+		commitments := append(pools[0].Commitments(), pools[2].Commitments()[0])
+		_, err := pools[0].Sign(key, msg[:], commitments)
+		require.NoError(t, err)
+		_, err = pools[0].Sign(key, msg[:], commitments)
+		assert.Error(t, err)
+
+		restored, err := signing.NewPresignPool(&devices[0], filepath.Join(dir, "presign0.json"))
+		require.NoError(t, err)
+		assert.Zero(t, restored.Len(), "consumed presignatures must not be restored")
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		_, err := signing.SignSchnorrPresigned(pools[1:], q, key, msg[:])
+		require.NoError(t, err)
+		_, err = signing.SignSchnorrPresigned(pools[1:], q, key, msg[:])
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,228 @@
+package signing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+
+	"bip32_threshold_wallet/node"
+)
+
+var k256 = curves.K256()
+
+// SchnorrKey is the key a Schnorr signature is produced for, given as the shared public key P of the devices and the
+// tweaks applied to it. The resulting key is Q = g*P + tacc*G, where g in {1, -1} accounts for the even-Y
+// normalizations required by x-only tweaking.
+type SchnorrKey struct {
+	internal node.PublicKey
+	q        curves.Point
+	g        curves.Scalar
+	tacc     curves.Scalar
+}
+
+// NewBIP340Key returns the key to sign for with BIP340 under the x-only version of the shared public key.
+func NewBIP340Key(pk node.PublicKey) *SchnorrKey {
+	return &SchnorrKey{
+		internal: pk,
+		q:        *pk,
+		g:        k256.Scalar.One(),
+		tacc:     k256.Scalar.Zero(),
+	}
+}
+
+// NewBIP340KeyForPath returns the key to sign for with BIP340 under the descendant at the non-hardened relative path
+// below the devices' node. The BIP32 tweak is applied as plain tweak, so that the devices sign with the shares of their
+// node only. Further x-only tweaks, e.g. TaprootTweak, may be applied afterwards.
+func NewBIP340KeyForPath(d *node.Device, path []uint32) (*SchnorrKey, error) {
+	tweak, _, _, err := d.PathTweak(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "computing path tweak")
+	}
+	t, err := k256.Scalar.SetBigInt(tweak.BigInt())
+	if err != nil {
+		return nil, err
+	}
+
+	k := NewBIP340Key(d.PublicKey())
+	if err := k.PlainTweak(t); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewTaprootKey returns the BIP341 output key for key-path spending of the shared public key, committing to the given
+// script tree merkle root. For outputs without script path, as in BIP86, the merkle root is nil.
+func NewTaprootKey(pk node.PublicKey, merkleRoot []byte) (*SchnorrKey, error) {
+	k := NewBIP340Key(pk)
+	if err := k.TaprootTweak(merkleRoot); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// TaprootTweak applies the BIP341 tweak t = hash_TapTweak(x(Q) || merkleRoot) to the even-Y version of the key.
+func (k *SchnorrKey) TaprootTweak(merkleRoot []byte) error {
+	if len(merkleRoot) != 0 && len(merkleRoot) != 32 {
+		return errors.New("merkle root must be 32 bytes")
+	}
+	data := append(xOnly(k.q), merkleRoot...)
+	t, err := k256.Scalar.SetBytes(TaggedHash("TapTweak", data))
+	if err != nil {
+		return errors.Wrap(err, "tweak is not a valid scalar")
+	}
+	return k.XOnlyTweak(t)
+}
+
+// XOnlyTweak negates the key if it has an odd Y coordinate and adds t*G afterwards.
+func (k *SchnorrKey) XOnlyTweak(t curves.Scalar) error {
+	if !hasEvenY(k.q) {
+		k.q = k.q.Neg()
+		k.g = k.g.Neg()
+		k.tacc = k.tacc.Neg()
+	}
+	return k.PlainTweak(t)
+}
+
+// PlainTweak adds t*G to the key without any normalization, as done by BIP32 non-hardened derivation.
+func (k *SchnorrKey) PlainTweak(t curves.Scalar) error {
+	q := k.q.Add(k256.ScalarBaseMult(t))
+	if q.IsIdentity() {
+		return errors.New("tweaked key is invalid")
+	}
+	k.q = q
+	k.tacc = k.tacc.Add(t)
+	return nil
+}
+
+// InternalKey returns the untweaked shared public key.
+func (k *SchnorrKey) InternalKey() node.PublicKey {
+	return k.internal
+}
+
+// PublicKey returns the tweaked key Q, which may have an odd Y coordinate.
+func (k *SchnorrKey) PublicKey() curves.Point {
+	return k.q
+}
+
+// XOnly returns the 32-byte x-only encoding of the tweaked key as used in BIP340 and in P2TR outputs.
+func (k *SchnorrKey) XOnly() []byte {
+	return xOnly(k.q)
+}
+
+// signFactor returns the factor the shared secret key is multiplied with in the effective signing key
+// gFinal*(g*sk + tacc), where gFinal normalizes Q to an even Y coordinate.
+func (k *SchnorrKey) signFactor() curves.Scalar {
+	if hasEvenY(k.q) {
+		return k.g
+	}
+	return k.g.Neg()
+}
+
+// tweakTerm returns gFinal*tacc, which is added once to the effective signing key.
+func (k *SchnorrKey) tweakTerm() curves.Scalar {
+	if hasEvenY(k.q) {
+		return k.tacc
+	}
+	return k.tacc.Neg()
+}
+
+// SignSchnorrWithKey produces a BIP340 signature of the message for the given key with the full secret key of its
+// internal key, e.g. the key of a descendant of a node obtained by hardened derivation.
+func SignSchnorrWithKey(sk []byte, key *SchnorrKey, msg []byte) ([]byte, error) {
+	x, err := k256.Scalar.SetBytes(sk)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret key")
+	}
+	if !k256.ScalarBaseMult(x).Equal(*key.internal) {
+		return nil, errors.New("secret key does not belong to the key")
+	}
+	d := key.signFactor().Mul(x).Add(key.tweakTerm())
+
+	k := k256.Scalar.Random(rand.Reader)
+	r := k256.ScalarBaseMult(k)
+	if !hasEvenY(r) {
+		k = k.Neg()
+		r = r.Neg()
+	}
+	e := bip340Challenge(xOnly(r), key.XOnly(), msg)
+
+	sig := make([]byte, 0, 64)
+	sig = append(sig, xOnly(r)...)
+	sig = append(sig, k.Add(e.Mul(d)).Bytes()...)
+	if !VerifySchnorr(key.XOnly(), msg, sig) {
+		return nil, errors.New("signature is invalid")
+	}
+	return sig, nil
+}
+
+// VerifySchnorr verifies a 64-byte BIP340 signature of the message under the 32-byte x-only public key.
+func VerifySchnorr(pkXOnly []byte, msg []byte, sig []byte) bool {
+	if len(pkXOnly) != 32 || len(sig) != 64 {
+		return false
+	}
+	pk, err := liftX(pkXOnly)
+	if err != nil {
+		return false
+	}
+	r := sig[:32]
+	if _, err := liftX(r); err != nil {
+		return false
+	}
+	s, err := k256.Scalar.SetBytes(sig[32:])
+	if err != nil {
+		return false
+	}
+
+	e := bip340Challenge(r, pkXOnly, msg)
+	R := k256.ScalarBaseMult(s).Sub(pk.Mul(e))
+	if R.IsIdentity() || !hasEvenY(R) {
+		return false
+	}
+	return string(xOnly(R)) == string(r)
+}
+
+// bip340Challenge computes e = hash_BIP0340/challenge(x(R) || x(P) || m) reduced modulo the group order.
+func bip340Challenge(rX, pkX, msg []byte) curves.Scalar {
+	data := make([]byte, 0, 64+len(msg))
+	data = append(data, rX...)
+	data = append(data, pkX...)
+	data = append(data, msg...)
+	return reduceScalar(TaggedHash("BIP0340/challenge", data))
+}
+
+// TaggedHash computes the BIP340 tagged hash SHA256(SHA256(tag) || SHA256(tag) || msg).
+func TaggedHash(tag string, msg []byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// reduceScalar interprets the bytes as big-endian integer and reduces it modulo the group order.
+func reduceScalar(b []byte) curves.Scalar {
+	n := new(big.Int).SetBytes(b)
+	s, err := k256.Scalar.SetBigInt(n.Mod(n, btcec.S256().N))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func hasEvenY(p curves.Point) bool {
+	return p.ToAffineCompressed()[0] == 0x02
+}
+
+func xOnly(p curves.Point) []byte {
+	return p.ToAffineCompressed()[1:]
+}
+
+// liftX returns the point with the given x coordinate and an even Y coordinate.
+func liftX(x []byte) (curves.Point, error) {
+	return k256.Point.FromAffineCompressed(append([]byte{0x02}, x...))
+}
@@ -0,0 +1,82 @@
+package signing
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/node"
+)
+
+// DigestSigner produces an ECDSA signature (r, s) of a 32-byte digest.
+type DigestSigner func(digest []byte) (r, s *big.Int, err error)
+
+// ThresholdSigner lets the given devices sign with threshold ECDSA for the descendant at the non-hardened relative
+// path below their node.
+func ThresholdSigner(devices []node.Device, decryptKeys map[uint32]*paillier.SecretKey, params *ECDSAParams, path []uint32) DigestSigner {
+	return func(digest []byte) (*big.Int, *big.Int, error) {
+		sig, err := SignECDSAForPath(devices, decryptKeys, params, path, digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sig.R, sig.S, nil
+	}
+}
+
+// KeySigner signs with an extended private key, e.g. a key below a node obtained by hardened derivation.
+func KeySigner(key *bip32.Key) DigestSigner {
+	return func(digest []byte) (*big.Int, *big.Int, error) {
+		if !key.IsPrivate {
+			return nil, nil, errors.New("extended private key required")
+		}
+		sk, _ := btcec.PrivKeyFromBytes(btcec.S256(), key.Key)
+		sig, err := sk.Sign(digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sig.R, sig.S, nil
+	}
+}
+
+// SignRecoverable signs the digest, normalizes s to the lower half of the group order and computes the recovery id of
+// the signature with respect to the given public key.
+func SignRecoverable(digest []byte, pk node.PublicKey, signer DigestSigner) (r, s *big.Int, recID int, err error) {
+	if r, s, err = signer(digest); err != nil {
+		return nil, nil, 0, err
+	}
+	n := btcec.S256().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s = new(big.Int).Sub(n, s)
+	}
+
+	if recID, err = RecoveryID(digest, r, s, pk); err != nil {
+		return nil, nil, 0, err
+	}
+	return r, s, recID, nil
+}
+
+// RecoveryID computes the recovery id of the signature (r, s) of the digest, i.e. the value that lets the signer's
+// public key be recovered from the signature. An error is returned if the signature is not one of the public key.
+func RecoveryID(digest []byte, r, s *big.Int, pk node.PublicKey) (int, error) {
+	if pk == nil {
+		return 0, errors.New("missing public key")
+	}
+	expected := (*pk).ToAffineCompressed()
+
+	compact := make([]byte, 65)
+	r.FillBytes(compact[1:33])
+	s.FillBytes(compact[33:])
+	// Recovery ids 2 and 3 only occur if r overflows the group order, which happens with negligible probability.
+	for recID := 0; recID < 2; recID++ {
+		compact[0] = byte(27 + 4 + recID) // Compressed public key.
+		recovered, _, err := btcec.RecoverCompact(btcec.S256(), compact, digest)
+		if err == nil && bytes.Equal(recovered.SerializeCompressed(), expected) {
+			return recID, nil
+		}
+	}
+	return 0, errors.New("signature does not belong to the public key")
+}
@@ -0,0 +1,67 @@
+package simnet
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Distribution is a distribution of durations, e.g. of the latency of a link.
+type Distribution interface {
+	// Sample draws a non-negative duration using the given source of randomness.
+	Sample(rng *rand.Rand) time.Duration
+}
+
+// Constant is the distribution always yielding the same duration.
+type Constant time.Duration
+
+// Sample implements Distribution.
+func (c Constant) Sample(*rand.Rand) time.Duration {
+	return time.Duration(c)
+}
+
+func (c Constant) String() string {
+	return time.Duration(c).String()
+}
+
+// Uniform is the uniform distribution on [Min, Max).
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+// Sample implements Distribution.
+func (u Uniform) Sample(rng *rand.Rand) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(rng.Int63n(int64(u.Max-u.Min)))
+}
+
+// Normal is the normal distribution truncated at zero.
+type Normal struct {
+	Mean, StdDev time.Duration
+}
+
+// Sample implements Distribution.
+func (n Normal) Sample(rng *rand.Rand) time.Duration {
+	return nonNegative(float64(n.Mean) + rng.NormFloat64()*float64(n.StdDev))
+}
+
+// LogNormal is the log-normal distribution with the given median, whose heavy tail models the latency of WAN links.
+// Sigma is the standard deviation of the logarithm of the duration.
+type LogNormal struct {
+	Median time.Duration
+	Sigma  float64
+}
+
+// Sample implements Distribution.
+func (l LogNormal) Sample(rng *rand.Rand) time.Duration {
+	return nonNegative(float64(l.Median) * math.Exp(rng.NormFloat64()*l.Sigma))
+}
+
+func nonNegative(d float64) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
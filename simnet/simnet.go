@@ -0,0 +1,213 @@
+// Package simnet simulates the network between the devices of a committee in-process. Each directed link between two
+// devices has a latency distribution, jitter, a loss probability and a bandwidth cap, and the devices can be split
+// into partitions. The randomness of each link is derived from the seed of the network, so that the latencies and
+// losses of the messages sent on a link only depend on the seed and the order of the messages. Only the queueing of
+// messages on links with capped bandwidth depends on the time the messages are sent at.
+package simnet
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"bip32_threshold_wallet/node"
+)
+
+// inboxSize is the number of undelivered messages a device can hold, further messages are lost.
+const inboxSize = 4096
+
+// Link models the directed link from one device to another. The zero link delivers messages immediately.
+type Link struct {
+	Latency   Distribution  // Propagation delay of a message, zero if nil.
+	Jitter    time.Duration // Maximum of the uniformly distributed delay added to the latency of each message.
+	Loss      float64       // Probability that a message is lost.
+	Bandwidth int64         // Bytes per second messages are transmitted with, unlimited if zero.
+}
+
+// Stats counts the messages sent over a network.
+type Stats struct {
+	Sent        int   // Messages sent, including those lost.
+	Delivered   int   // Messages delivered to the inbox of their recipient.
+	Lost        int   // Messages lost on their link or due to a full inbox.
+	Partitioned int   // Messages dropped between partitions.
+	Bytes       int64 // Size of the messages sent.
+}
+
+type linkID struct {
+	from, to uint32
+}
+
+type linkState struct {
+	rng       *rand.Rand
+	busyUntil time.Time // End of the transmission of the last message on a link with capped bandwidth.
+}
+
+// Network is a simulated network between devices, which are identified by the identifiers of their shares.
+type Network struct {
+	seed int64
+
+	mu         sync.Mutex
+	link       Link
+	links      map[linkID]Link
+	states     map[linkID]*linkState
+	partitions map[uint32]int
+	inboxes    map[uint32]chan *node.SignedMessage
+	stats      Stats
+}
+
+// New creates a network whose links all behave like the given link unless configured otherwise with SetLink.
+func New(seed int64, link Link) *Network {
+	return &Network{
+		seed:       seed,
+		link:       link,
+		links:      make(map[linkID]Link),
+		states:     make(map[linkID]*linkState),
+		partitions: make(map[uint32]int),
+		inboxes:    make(map[uint32]chan *node.SignedMessage),
+	}
+}
+
+// SetLink configures the link from one device to another.
+func (n *Network) SetLink(from, to uint32, link Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.links[linkID{from, to}] = link
+}
+
+// Partition splits the network into the given groups of devices, which only receive messages from devices of their
+// own group. All devices not listed form another group. Messages already in flight are still delivered.
+func (n *Network) Partition(groups ...[]uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partitions = make(map[uint32]int)
+	for i, group := range groups {
+		for _, id := range group {
+			n.partitions[id] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Stats returns the statistics of the messages sent so far.
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Transport returns the transport of the device holding the share with the given identifier.
+func (n *Network) Transport(id uint32) node.Transport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return transport{network: n, id: id, inbox: n.inbox(id)}
+}
+
+// MessageSize returns the size of the message on the wire, which is transmitted at the bandwidth of the link.
+func MessageSize(m *node.SignedMessage) int {
+	// The sender, the round, the recipient and the time are encoded with 20 bytes.
+	return len(m.Op) + len(m.Session) + len(m.Nonce) + len(m.Body) + len(m.Signature) + 20
+}
+
+func (n *Network) send(from, to uint32, m *node.SignedMessage) {
+	size := MessageSize(m)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stats.Sent++
+	n.stats.Bytes += int64(size)
+	if n.partitions[from] != n.partitions[to] {
+		n.stats.Partitioned++
+		return
+	}
+
+	id := linkID{from, to}
+	link, ok := n.links[id]
+	if !ok {
+		link = n.link
+	}
+	state := n.state(id)
+
+	// All random values are drawn for every message, so that the random stream of a link only depends on the number
+	// of messages sent on it.
+	lost := state.rng.Float64() < link.Loss
+	var delay time.Duration
+	if link.Latency != nil {
+		delay = link.Latency.Sample(state.rng)
+	}
+	if link.Jitter > 0 {
+		delay += time.Duration(state.rng.Int63n(int64(link.Jitter)))
+	}
+	if lost {
+		n.stats.Lost++
+		return
+	}
+
+	// Messages on a link with capped bandwidth are transmitted one after the other.
+	now := time.Now()
+	if link.Bandwidth > 0 {
+		start := now
+		if state.busyUntil.After(now) {
+			start = state.busyUntil
+		}
+		state.busyUntil = start.Add(time.Duration(int64(size) * int64(time.Second) / link.Bandwidth))
+		delay += state.busyUntil.Sub(now)
+	}
+
+	inbox := n.inbox(to)
+	time.AfterFunc(delay, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		select {
+		case inbox <- m:
+			n.stats.Delivered++
+		default:
+			n.stats.Lost++
+		}
+	})
+}
+
+// state returns the state of the link, seeding its randomness from the seed of the network and the link.
+func (n *Network) state(id linkID) *linkState {
+	state, ok := n.states[id]
+	if !ok {
+		h := fnv.New64a()
+		binary.Write(h, binary.BigEndian, n.seed)
+		binary.Write(h, binary.BigEndian, id.from)
+		binary.Write(h, binary.BigEndian, id.to)
+		state = &linkState{rng: rand.New(rand.NewSource(int64(h.Sum64())))}
+		n.states[id] = state
+	}
+	return state
+}
+
+func (n *Network) inbox(id uint32) chan *node.SignedMessage {
+	inbox, ok := n.inboxes[id]
+	if !ok {
+		inbox = make(chan *node.SignedMessage, inboxSize)
+		n.inboxes[id] = inbox
+	}
+	return inbox
+}
+
+type transport struct {
+	network *Network
+	id      uint32
+	inbox   chan *node.SignedMessage
+}
+
+// Send implements node.Transport. Like a datagram, the message is silently dropped if it is lost.
+func (t transport) Send(to uint32, m *node.SignedMessage) error {
+	t.network.send(t.id, to, m)
+	return nil
+}
+
+// Receive implements node.Transport.
+func (t transport) Receive() <-chan *node.SignedMessage {
+	return t.inbox
+}
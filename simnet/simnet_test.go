@@ -0,0 +1,138 @@
+package simnet_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/simnet"
+	"bip32_threshold_wallet/utils"
+)
+
+// receive returns the bodies of the messages arriving within the timeout.
+func receive(transport node.Transport, timeout time.Duration) []string {
+	var bodies []string
+	deadline := time.After(timeout)
+	for {
+		select {
+		case m := <-transport.Receive():
+			bodies = append(bodies, string(m.Body))
+		case <-deadline:
+			return bodies
+		}
+	}
+}
+
+func message(body string) *node.SignedMessage {
+	return &node.SignedMessage{Op: "test", Body: []byte(body)}
+}
+
+func TestNetwork(t *testing.T) {
+	t.Run("Deterministic losses", func(t *testing.T) {
+		delivered := func(seed int64) []string {
+			network := simnet.New(seed, simnet.Link{Loss: 0.5})
+			sender := network.Transport(1)
+			for i := 0; i < 100; i++ {
+				require.NoError(t, sender.Send(2, message(fmt.Sprint(i))))
+			}
+			bodies := receive(network.Transport(2), 50*time.Millisecond)
+			stats := network.Stats()
+			assert.Equal(t, 100, stats.Sent)
+			assert.Equal(t, len(bodies), stats.Delivered)
+			assert.Equal(t, 100, stats.Delivered+stats.Lost)
+			return bodies
+		}
+		// Messages delivered at the same time may arrive in any order.
+		first, second := delivered(1), delivered(1)
+		sort.Strings(first)
+		sort.Strings(second)
+		assert.Equal(t, first, second)
+		assert.NotEqual(t, first, delivered(2))
+	})
+
+	t.Run("Latency and jitter", func(t *testing.T) {
+		network := simnet.New(1, simnet.Link{Latency: simnet.Constant(50 * time.Millisecond), Jitter: 10 * time.Millisecond})
+		start := time.Now()
+		require.NoError(t, network.Transport(1).Send(2, message("m")))
+		<-network.Transport(2).Receive()
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("Bandwidth", func(t *testing.T) {
+		network := simnet.New(1, simnet.Link{Bandwidth: 100_000})
+		m := &node.SignedMessage{Body: make([]byte, 1000)}
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			require.NoError(t, network.Transport(1).Send(2, m))
+		}
+		for i := 0; i < 10; i++ {
+			<-network.Transport(2).Receive()
+		}
+		assert.GreaterOrEqual(t, time.Since(start), time.Duration(10*simnet.MessageSize(m))*time.Second/100_000)
+		assert.Equal(t, int64(10*simnet.MessageSize(m)), network.Stats().Bytes)
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		network := simnet.New(1, simnet.Link{})
+		network.SetLink(1, 2, simnet.Link{Loss: 1})
+		require.NoError(t, network.Transport(1).Send(2, message("lost")))
+		require.NoError(t, network.Transport(2).Send(1, message("delivered")))
+		assert.Empty(t, receive(network.Transport(2), 10*time.Millisecond))
+		assert.Equal(t, []string{"delivered"}, receive(network.Transport(1), 10*time.Millisecond))
+	})
+
+	t.Run("Partitions", func(t *testing.T) {
+		network := simnet.New(1, simnet.Link{})
+		network.Partition([]uint32{1})
+		require.NoError(t, network.Transport(1).Send(2, message("dropped")))
+		require.NoError(t, network.Transport(3).Send(2, message("delivered")))
+		assert.Equal(t, []string{"delivered"}, receive(network.Transport(2), 10*time.Millisecond))
+		assert.Equal(t, 1, network.Stats().Partitioned)
+
+		network.Heal()
+		require.NoError(t, network.Transport(1).Send(2, message("healed")))
+		assert.Equal(t, []string{"healed"}, receive(network.Transport(2), 10*time.Millisecond))
+	})
+}
+
+func TestSessionOverWAN(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	// A WAN with a heavy-tailed latency, in which the jitter reorders the deals of the devices.
+	network := simnet.New(7, simnet.Link{
+		Latency:   simnet.LogNormal{Median: 30 * time.Millisecond, Sigma: 0.5},
+		Jitter:    20 * time.Millisecond,
+		Bandwidth: 1 << 20,
+	})
+	id, err := node.NewSessionID()
+	require.NoError(t, err)
+	cfg := node.SessionConfig{Op: "refresh", ID: id, Roster: node.Roster{}, Timeout: 2 * time.Second}
+	for i := range devices {
+		cfg.Participants = append(cfg.Participants, devices[i].Identifier())
+		cfg.Roster[devices[i].Identifier()] = devices[i].DeviceKey()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(devices))
+	for i := range devices {
+		session, err := node.NewSession(&devices[i], network.Transport(devices[i].Identifier()), cfg)
+		require.NoError(t, err)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = session.Run(context.Background(), node.NewRefreshProtocol(&devices[i], cfg.Participants, cfg.Roster))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), devices[i].Epoch())
+	}
+	assert.Equal(t, 6, network.Stats().Delivered)
+}
@@ -0,0 +1,62 @@
+package tvrf
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+type partialEvaluationJSON struct {
+	Idx         uint32 `json:"idx"`
+	PubKeyShare []byte `json:"pubKeyShare"`
+	Eval        []byte `json:"eval"`
+	Res         []byte `json:"res"`
+	Ch          []byte `json:"ch"`
+}
+
+// MarshalPartialEvaluation encodes a partial evaluation, e.g. to send it to the combining party. The base point h(m)
+// of the proof is not encoded, since the receiver recomputes it from the message.
+func (t *DDHTVRF) MarshalPartialEvaluation(eval *PartialEvaluation) ([]byte, error) {
+	if eval.PubKeyShare.Value == nil || eval.Proof == nil {
+		return nil, errors.New("incomplete partial evaluation")
+	}
+	return json.Marshal(partialEvaluationJSON{
+		Idx:         eval.PubKeyShare.Idx,
+		PubKeyShare: (*eval.PubKeyShare.Value).ToAffineCompressed(),
+		Eval:        eval.Eval.ToAffineCompressed(),
+		Res:         eval.Proof.Res.Bytes(),
+		Ch:          eval.Proof.Ch.Bytes(),
+	})
+}
+
+// UnmarshalPartialEvaluation decodes a partial evaluation of the given message encoded with MarshalPartialEvaluation.
+// The evaluation is not verified, which is done when combining it.
+func (t *DDHTVRF) UnmarshalPartialEvaluation(m Message, data []byte) (*PartialEvaluation, error) {
+	var encoded partialEvaluationJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, errors.Wrap(err, "decoding partial evaluation")
+	}
+
+	pk, err := t.curve.Point.FromAffineCompressed(encoded.PubKeyShare)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key share")
+	}
+	eval, err := t.curve.Point.FromAffineCompressed(encoded.Eval)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding evaluation")
+	}
+	res, err := t.curve.Scalar.SetBytes(encoded.Res)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding proof")
+	}
+	ch, err := t.curve.Scalar.SetBytes(encoded.Ch)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding proof")
+	}
+
+	return &PartialEvaluation{
+		PubKeyShare: PublicKeyShare{Idx: encoded.Idx, Value: &pk},
+		Eval:        eval,
+		Proof:       &Proof{Res: res, Ch: ch, g: t.curve.Point.Hash(m)},
+	}, nil
+}
@@ -18,6 +18,8 @@ type TVRF interface {
 	Verify(eval Evaluation) bool
 	// Combine combines at least t partial evaluations to compute the final evaluation of the TVRF.
 	Combine(evals []*PartialEvaluation) (*Evaluation, error)
+	// VerifyPartialEval verifies the proof of a partial evaluation against the public key share it is bound to.
+	VerifyPartialEval(eval *PartialEvaluation) bool
 }
 
 type DDHTVRF struct {
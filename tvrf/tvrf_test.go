@@ -71,4 +71,20 @@ func TestTVRF(t *testing.T) {
 		assert.Truef(t, valid, "evaluation verification failed")
 	})
 
+	t.Run("Encode partial evaluation", func(t *testing.T) {
+		peval, err := ddhTvrf.PEval(message, secretKeys[1], publicKeys[1])
+		require.NoError(t, err)
+		data, err := ddhTvrf.MarshalPartialEvaluation(peval)
+		require.NoError(t, err)
+
+		decoded, err := ddhTvrf.UnmarshalPartialEvaluation(message, data)
+		require.NoError(t, err)
+		assert.True(t, ddhTvrf.VerifyPartialEval(decoded))
+		assert.True(t, decoded.Eval.Equal(peval.Eval))
+
+		// The proof is bound to the message.
+		decoded, err = ddhTvrf.UnmarshalPartialEvaluation([]byte("other"), data)
+		require.NoError(t, err)
+		assert.False(t, ddhTvrf.VerifyPartialEval(decoded))
+	})
 }
@@ -1,9 +1,13 @@
 package utils
 
-import "bip32_threshold_wallet/node"
+import (
+	"math/big"
+
+	"bip32_threshold_wallet/node"
+)
 
 func CreateDevices(t, n uint32) []node.Device {
-	pkShares, skShares, pk := node.GenSharedKey(t, n)
+	pkShares, skShares, pk, commitments := node.GenSharedKey(t, n)
 	chaincode, _ := node.NewMasterChainCode()
 	index := uint32(0x0)
 
@@ -11,14 +15,29 @@ func CreateDevices(t, n uint32) []node.Device {
 	for i := uint32(0); i < n; i++ {
 		device, _ := node.NewDevice(
 			int(i),
+			t,
+			n,
 			pkShares[i+1].Point,
 			skShares[i+1].ShamirShare,
 			pk,
+			commitments,
 			index,
 			chaincode,
 			nil,
 		)
+		if err := device.VerifyShare(); err != nil {
+			panic(err)
+		}
 		devices[i] = device
 	}
 	return devices
 }
+
+// B10 parses a decimal big integer, e.g. a fixed parameter for threshold ECDSA. It panics on invalid input.
+func B10(s string) *big.Int {
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("Couldn't derive big.Int from string")
+	}
+	return x
+}
@@ -0,0 +1,330 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+)
+
+// Purpose is the first level of a BIP43 path, determining the kind of addresses of the accounts below it.
+type Purpose uint32
+
+const (
+	BIP44 Purpose = 44 // P2PKH
+	BIP49 Purpose = 49 // P2SH-P2WPKH
+	BIP84 Purpose = 84 // P2WPKH
+	BIP86 Purpose = 86 // P2TR
+)
+
+// AddressType returns the type of the addresses of accounts with the purpose.
+func (p Purpose) AddressType() (address.Type, error) {
+	switch p {
+	case BIP44:
+		return address.P2PKH, nil
+	case BIP49:
+		return address.P2SHP2WPKH, nil
+	case BIP84:
+		return address.P2WPKH, nil
+	case BIP86:
+		return address.P2TR, nil
+	}
+	return 0, errors.Errorf("unsupported purpose %d", p)
+}
+
+// The chains below an account.
+const (
+	ExternalChain uint32 = 0 // Receiving addresses.
+	InternalChain uint32 = 1 // Change addresses.
+)
+
+// walletStateVersion is the version of the persisted wallet state.
+const walletStateVersion = 1
+
+// Account is an account at purpose'/coin'/account'. Only its extended public key and the next unused index of its
+// external and internal chain are kept, the account key itself is derived again by the committee when needed.
+type Account struct {
+	Purpose  Purpose   `json:"purpose"`
+	CoinType uint32    `json:"coinType"`
+	Index    uint32    `json:"index"`
+	XPub     string    `json:"xpub"`
+	Next     [2]uint32 `json:"next"` // Next unused index of the external and the internal chain.
+
+	// MasterFingerprint identifies the master key of the committee in key origins, hex encoded.
+	MasterFingerprint string `json:"masterFingerprint"`
+
+	watch *WatchOnly
+}
+
+// Path returns the BIP32 path of the account.
+func (a *Account) Path() string {
+	return fmt.Sprintf("m/%d'/%d'/%d'", a.Purpose, a.CoinType, a.Index)
+}
+
+// PublicKey returns the public key at the given index of the given chain of the account.
+func (a *Account) PublicKey(chain, index uint32) (node.PublicKey, error) {
+	if chain != ExternalChain && chain != InternalChain {
+		return nil, errors.Errorf("invalid chain %d", chain)
+	}
+	return a.watch.PublicKey(chain, index)
+}
+
+// Address returns the address at the given index of the given chain of the account.
+func (a *Account) Address(chain, index uint32, params *chaincfg.Params) (string, error) {
+	t, err := a.Purpose.AddressType()
+	if err != nil {
+		return "", err
+	}
+	pk, err := a.PublicKey(chain, index)
+	if err != nil {
+		return "", err
+	}
+	return address.Encode(pk, t, params)
+}
+
+// Wallet lays out accounts following BIP44, BIP49, BIP84 and BIP86 on top of threshold derivation. The purpose, coin
+// type and account levels are derived by the committee of devices one after the other using hardened threshold
+// derivation with a TVRF, so that the account key remains shared among the devices. The external and internal chains
+// are derived non-hardened from the account's extended public key, so that addresses can be generated without the
+// committee. The accounts and the next unused indices are persisted at the given path.
+type Wallet struct {
+	derivation derivation.SharedHardenedDerivation
+	coinType   uint32
+	path       string
+	accounts   []*Account
+}
+
+type walletState struct {
+	Version  uint32     `json:"version"`
+	CoinType uint32     `json:"coinType"`
+	Accounts []*Account `json:"accounts"`
+}
+
+// NewWallet creates a wallet for the given coin type on top of the threshold derivation of a committee. If the file at
+// the given path exists, the accounts are loaded from it. An empty path creates a wallet kept in memory only.
+func NewWallet(d derivation.SharedHardenedDerivation, coinType uint32, path string) (*Wallet, error) {
+	w := &Wallet{
+		derivation: d,
+		coinType:   coinType,
+		path:       path,
+	}
+	if path == "" {
+		return w, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading wallet state")
+	}
+
+	var state walletState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "decoding wallet state")
+	}
+	if state.Version != walletStateVersion {
+		return nil, errors.Errorf("unsupported wallet state version %d", state.Version)
+	}
+	if state.CoinType != coinType {
+		return nil, errors.Errorf("wallet state belongs to coin type %d", state.CoinType)
+	}
+	for _, a := range state.Accounts {
+		if a.watch, err = NewWatchOnly(a.XPub); err != nil {
+			return nil, errors.Wrapf(err, "account %s", a.Path())
+		}
+	}
+	w.accounts = state.Accounts
+
+	return w, nil
+}
+
+// Accounts returns all accounts of the wallet.
+func (w *Wallet) Accounts() []*Account {
+	return w.accounts
+}
+
+// Account returns the account with the given purpose and index, if it has been created.
+func (w *Wallet) Account(purpose Purpose, index uint32) (*Account, error) {
+	for _, a := range w.accounts {
+		if a.Purpose == purpose && a.Index == index {
+			return a, nil
+		}
+	}
+	return nil, errors.Errorf("no account m/%d'/%d'/%d'", purpose, w.coinType, index)
+}
+
+// CreateAccount derives the account with the given purpose and index and adds it to the wallet.
+func (w *Wallet) CreateAccount(purpose Purpose, index uint32) (*Account, error) {
+	if _, err := purpose.AddressType(); err != nil {
+		return nil, err
+	}
+	if _, err := w.Account(purpose, index); err == nil {
+		return nil, errors.Errorf("account m/%d'/%d'/%d' exists already", purpose, w.coinType, index)
+	}
+
+	devices, masterFingerprint, err := w.accountDevices(purpose, index)
+	if err != nil {
+		return nil, err
+	}
+	key, err := devices[0].XPub()
+	if err != nil {
+		return nil, err
+	}
+	xpub := key.String()
+	watch, err := NewWatchOnly(xpub)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Account{
+		Purpose:  purpose,
+		CoinType: w.coinType,
+		Index:    index,
+		XPub:     xpub,
+		watch:    watch,
+
+		MasterFingerprint: hex.EncodeToString(masterFingerprint),
+	}
+	w.accounts = append(w.accounts, a)
+	if err := w.save(); err != nil {
+		w.accounts = w.accounts[:len(w.accounts)-1]
+		return nil, err
+	}
+	return a, nil
+}
+
+// AccountDevices derives the shares of the account with the given purpose and index on each of the devices, e.g. for
+// threshold signing. Each hardened level is derived by the devices, so that the account key is never reconstructed.
+func (w *Wallet) AccountDevices(purpose Purpose, index uint32) ([]node.Device, error) {
+	devices, _, err := w.accountDevices(purpose, index)
+	return devices, err
+}
+
+// DevicesForPath derives the shares of the account for a path of the form purpose'/coin'/account'/... below one of the
+// wallet's accounts and returns them together with the non-hardened relative path below the account, which threshold
+// signing tweaks the shares with.
+func (w *Wallet) DevicesForPath(path []uint32) ([]node.Device, []uint32, error) {
+	if len(path) < 3 || path[0] < bip32.FirstHardenedChild || path[1] < bip32.FirstHardenedChild || path[2] < bip32.FirstHardenedChild {
+		return nil, nil, errors.New("path is not below an account")
+	}
+	if path[1]-bip32.FirstHardenedChild != w.coinType {
+		return nil, nil, errors.Errorf("path belongs to coin type %d", path[1]-bip32.FirstHardenedChild)
+	}
+	for _, idx := range path[3:] {
+		if idx >= bip32.FirstHardenedChild {
+			return nil, nil, errors.New("path below the account must not be hardened")
+		}
+	}
+
+	devices, err := w.AccountDevices(Purpose(path[0]-bip32.FirstHardenedChild), path[2]-bip32.FirstHardenedChild)
+	if err != nil {
+		return nil, nil, err
+	}
+	return devices, path[3:], nil
+}
+
+// accountDevices derives the shares of the account and returns them together with the fingerprint of the master key,
+// which is the parent fingerprint of the purpose node.
+func (w *Wallet) accountDevices(purpose Purpose, index uint32) ([]node.Device, []byte, error) {
+	if index >= bip32.FirstHardenedChild {
+		return nil, nil, errors.Errorf("invalid account index %d", index)
+	}
+	if w.coinType >= bip32.FirstHardenedChild {
+		return nil, nil, errors.Errorf("invalid coin type %d", w.coinType)
+	}
+
+	levels := []struct {
+		name  string
+		index uint32
+	}{{"purpose", uint32(purpose)}, {"coin type", w.coinType}, {"account", index}}
+	deriv := w.derivation
+	var devices []node.Device
+	var masterFingerprint []byte
+	for _, level := range levels {
+		var err error
+		if devices, err = deriv.DeriveSharedHardenedChild(level.index); err != nil {
+			return nil, nil, errors.Wrapf(err, "deriving %s node", level.name)
+		}
+		if masterFingerprint == nil {
+			key, err := devices[0].XPub()
+			if err != nil {
+				return nil, nil, err
+			}
+			masterFingerprint = key.FingerPrint
+		}
+		deriv = deriv.WithDevices(devices)
+	}
+	return devices, masterFingerprint, nil
+}
+
+// NextAddress returns the address at the next unused index of the given chain of the account and marks the index as
+// used. Indices whose keys are invalid are skipped as prescribed by BIP32. The index is persisted before the address is
+// returned, so that no address is handed out twice.
+func (w *Wallet) NextAddress(a *Account, chain uint32, params *chaincfg.Params) (string, uint32, error) {
+	if chain != ExternalChain && chain != InternalChain {
+		return "", 0, errors.Errorf("invalid chain %d", chain)
+	}
+	var addr string
+	index := a.Next[chain]
+	for ; ; index++ {
+		if index >= bip32.FirstHardenedChild {
+			return "", 0, errors.Errorf("no unused index left on chain %d", chain)
+		}
+		var err error
+		if addr, err = a.Address(chain, index, params); err == nil {
+			break
+		}
+		if !errors.Is(err, node.ErrInvalidChild) {
+			return "", 0, err
+		}
+	}
+
+	if err := w.MarkUsed(a, chain, index); err != nil {
+		return "", 0, err
+	}
+	return addr, index, nil
+}
+
+// MarkUsed records that the given index of the given chain of the account has been used, e.g. because a transaction
+// paying to its address has been observed.
+func (w *Wallet) MarkUsed(a *Account, chain, index uint32) error {
+	if chain != ExternalChain && chain != InternalChain {
+		return errors.Errorf("invalid chain %d", chain)
+	}
+	if index < a.Next[chain] {
+		return nil
+	}
+
+	prev := a.Next[chain]
+	a.Next[chain] = index + 1
+	if err := w.save(); err != nil {
+		a.Next[chain] = prev
+		return err
+	}
+	return nil
+}
+
+func (w *Wallet) save() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(walletState{
+		Version:  walletStateVersion,
+		CoinType: w.coinType,
+		Accounts: w.accounts,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(node.WriteSecretFile(w.path, data), "writing wallet state")
+}
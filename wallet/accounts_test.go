@@ -0,0 +1,86 @@
+package wallet_test
+
+import (
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/utils"
+	"bip32_threshold_wallet/wallet"
+)
+
+func TestWallet(t *testing.T) {
+	curve := curves.K256()
+	devices := utils.CreateDevices(2, 3)
+	deriv := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(2, 3, curve, sha256.New(), true), true)
+	path := filepath.Join(t.TempDir(), "wallet.json")
+
+	w, err := wallet.NewWallet(&deriv, 1, path)
+	require.NoError(t, err)
+
+	account, err := w.CreateAccount(wallet.BIP84, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "m/84'/1'/0'", account.Path())
+	_, err = w.CreateAccount(wallet.BIP84, 0)
+	assert.Error(t, err, "accounts must not be created twice")
+
+	accountDevices, err := w.AccountDevices(wallet.BIP84, 0)
+	require.NoError(t, err)
+	// The devices share the account key, which any t of them reconstruct.
+	key, err := node.ExportXPrv(accountDevices[1:])
+	require.NoError(t, err)
+	assert.Equal(t, uint8(3), key.Depth)
+	assert.Equal(t, key.PublicKey().String(), account.XPub)
+
+	// The addresses must be those of the standard derivation below the account key.
+	for i := uint32(0); i < 2; i++ {
+		addr, index, err := w.NextAddress(account, wallet.ExternalChain, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		assert.Equal(t, i, index)
+
+		chain, err := key.NewChildKey(wallet.ExternalChain)
+		require.NoError(t, err)
+		child, err := chain.NewChildKey(i)
+		require.NoError(t, err)
+		pk, err := curve.Point.FromAffineCompressed(child.PublicKey().Key)
+		require.NoError(t, err)
+		expected, err := address.Encode(&pk, address.P2WPKH, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		assert.Equal(t, expected, addr)
+	}
+	require.NoError(t, w.MarkUsed(account, wallet.InternalChain, 4))
+
+	t.Run("Persisted", func(t *testing.T) {
+		loaded, err := wallet.NewWallet(&deriv, 1, path)
+		require.NoError(t, err)
+		a, err := loaded.Account(wallet.BIP84, 0)
+		require.NoError(t, err)
+		assert.Equal(t, account.XPub, a.XPub)
+		assert.Equal(t, [2]uint32{2, 5}, a.Next)
+
+		_, index, err := loaded.NextAddress(a, wallet.InternalChain, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(5), index)
+
+		_, err = wallet.NewWallet(&deriv, 0, path)
+		assert.Error(t, err, "state of another coin type must not be loaded")
+	})
+
+	t.Run("Taproot account", func(t *testing.T) {
+		a, err := w.CreateAccount(wallet.BIP86, 0)
+		require.NoError(t, err)
+		addr, err := a.Address(wallet.ExternalChain, 0, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		assert.Regexp(t, "^tb1p", addr)
+		assert.NotEqual(t, account.XPub, a.XPub)
+	})
+}
@@ -0,0 +1,298 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/node"
+)
+
+// Output script descriptors (BIP380) of single-key accounts, i.e. pkh, sh(wpkh), wpkh (BIP382) and tr (BIP386), of the
+// form wpkh([fingerprint/84'/0'/0']xpub/0/*)#checksum.
+
+const (
+	descriptorInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// descriptorScripts maps address types to the script expressions wrapping the key expression.
+var descriptorScripts = map[address.Type][2]string{
+	address.P2PKH:      {"pkh(", ")"},
+	address.P2SHP2WPKH: {"sh(wpkh(", "))"},
+	address.P2WPKH:     {"wpkh(", ")"},
+	address.P2TR:       {"tr(", ")"},
+}
+
+// Descriptor is a parsed ranged descriptor paying to the children of an extended public key.
+type Descriptor struct {
+	Type        address.Type
+	Fingerprint []byte   // Fingerprint of the master key, if the key origin is given.
+	OriginPath  []uint32 // Path from the master key to the extended public key.
+	XPub        *bip32.Key
+	Path        []uint32 // Non-hardened path from the extended public key to the parent of the ranged children.
+
+	watch *WatchOnly
+}
+
+// Descriptor returns the descriptor of the given chain of the account, with the extended public key encoded for the
+// given network.
+func (a *Account) Descriptor(chain uint32, params *chaincfg.Params) (string, error) {
+	if chain != ExternalChain && chain != InternalChain {
+		return "", errors.Errorf("invalid chain %d", chain)
+	}
+	t, err := a.Purpose.AddressType()
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := hex.DecodeString(a.MasterFingerprint)
+	if err != nil || len(fingerprint) != 4 {
+		return "", errors.New("account has no valid master fingerprint")
+	}
+
+	d := &Descriptor{
+		Type:        t,
+		Fingerprint: fingerprint,
+		OriginPath: []uint32{
+			bip32.FirstHardenedChild + uint32(a.Purpose),
+			bip32.FirstHardenedChild + a.CoinType,
+			bip32.FirstHardenedChild + a.Index,
+		},
+		XPub: a.watch.XPub(),
+		Path: []uint32{chain},
+	}
+	return d.String(params), nil
+}
+
+// AccountFromDescriptor creates the watch-only view of an account from the descriptor of one of its chains. The key
+// origin must be a BIP44, BIP49, BIP84 or BIP86 account path matching the script type.
+func AccountFromDescriptor(desc string) (*Account, error) {
+	d, err := ParseDescriptor(desc)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.OriginPath) != 3 {
+		return nil, errors.New("descriptor has no account key origin")
+	}
+	for _, idx := range d.OriginPath {
+		if idx < bip32.FirstHardenedChild {
+			return nil, errors.New("account key origin must be hardened")
+		}
+	}
+
+	purpose := Purpose(d.OriginPath[0] - bip32.FirstHardenedChild)
+	if t, err := purpose.AddressType(); err != nil || t != d.Type {
+		return nil, errors.Errorf("purpose %d does not match the script type %s", purpose, d.Type)
+	}
+
+	xpub := *d.XPub
+	xpub.Version = bip32.PublicWalletVersion
+	return &Account{
+		Purpose:           purpose,
+		CoinType:          d.OriginPath[1] - bip32.FirstHardenedChild,
+		Index:             d.OriginPath[2] - bip32.FirstHardenedChild,
+		XPub:              xpub.String(),
+		MasterFingerprint: hex.EncodeToString(d.Fingerprint),
+		watch:             d.watch,
+	}, nil
+}
+
+// String encodes the descriptor including its checksum, with the extended public key encoded for the given network.
+func (d *Descriptor) String(params *chaincfg.Params) string {
+	var sb strings.Builder
+	script := descriptorScripts[d.Type]
+	sb.WriteString(script[0])
+
+	if d.Fingerprint != nil {
+		sb.WriteString("[")
+		sb.WriteString(hex.EncodeToString(d.Fingerprint))
+		sb.WriteString(formatPath(d.OriginPath))
+		sb.WriteString("]")
+	}
+	xpub := *d.XPub
+	xpub.Version = params.HDPublicKeyID[:]
+	sb.WriteString(xpub.String())
+	sb.WriteString(formatPath(d.Path))
+	sb.WriteString("/*")
+
+	sb.WriteString(script[1])
+	desc := sb.String()
+	checksum, _ := DescriptorChecksum(desc)
+	return desc + "#" + checksum
+}
+
+// PublicKey returns the public key of the child with the given index in the range of the descriptor.
+func (d *Descriptor) PublicKey(index uint32) (node.PublicKey, error) {
+	return d.watch.PublicKey(append(append([]uint32(nil), d.Path...), index)...)
+}
+
+// Address returns the address of the child with the given index in the range of the descriptor.
+func (d *Descriptor) Address(index uint32, params *chaincfg.Params) (string, error) {
+	pk, err := d.PublicKey(index)
+	if err != nil {
+		return "", err
+	}
+	return address.Encode(pk, d.Type, params)
+}
+
+// ParseDescriptor parses a ranged single-key descriptor. If a checksum is given, it is verified.
+func ParseDescriptor(desc string) (*Descriptor, error) {
+	if i := strings.LastIndex(desc, "#"); i >= 0 {
+		expected, err := DescriptorChecksum(desc[:i])
+		if err != nil {
+			return nil, err
+		}
+		if desc[i+1:] != expected {
+			return nil, errors.New("invalid descriptor checksum")
+		}
+		desc = desc[:i]
+	}
+
+	d := &Descriptor{}
+	found := false
+	for t, script := range descriptorScripts {
+		if strings.HasPrefix(desc, script[0]) && strings.HasSuffix(desc, script[1]) {
+			d.Type = t
+			desc = strings.TrimSuffix(strings.TrimPrefix(desc, script[0]), script[1])
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("unsupported descriptor")
+	}
+
+	if strings.HasPrefix(desc, "[") {
+		end := strings.Index(desc, "]")
+		if end < 0 {
+			return nil, errors.New("unterminated key origin")
+		}
+		origin := strings.Split(desc[1:end], "/")
+		fingerprint, err := hex.DecodeString(origin[0])
+		if err != nil || len(fingerprint) != 4 {
+			return nil, errors.New("invalid key origin fingerprint")
+		}
+		d.Fingerprint = fingerprint
+		if d.OriginPath, err = parsePath(origin[1:]); err != nil {
+			return nil, err
+		}
+		desc = desc[end+1:]
+	}
+
+	steps := strings.Split(desc, "/")
+	if len(steps) < 2 || steps[len(steps)-1] != "*" {
+		return nil, errors.New("only ranged descriptors with unhardened wildcard are supported")
+	}
+	xpub, err := bip32.B58Deserialize(steps[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding extended public key")
+	}
+	if xpub.IsPrivate {
+		return nil, errors.New("descriptor must not contain private keys")
+	}
+	d.XPub = xpub
+	if d.Path, err = parsePath(steps[1 : len(steps)-1]); err != nil {
+		return nil, err
+	}
+	for _, idx := range d.Path {
+		if idx >= bip32.FirstHardenedChild {
+			return nil, errors.New("path after the extended public key must not be hardened")
+		}
+	}
+
+	key := *xpub
+	key.Version = bip32.PublicWalletVersion
+	if d.watch, err = NewWatchOnly(key.String()); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DescriptorChecksum computes the 8-character checksum of a descriptor as defined in BIP380.
+func DescriptorChecksum(desc string) (string, error) {
+	generator := []uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+	c := uint64(1)
+	polymod := func(value uint64) {
+		top := c >> 35
+		c = (c&0x7ffffffff)<<5 ^ value
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				c ^= generator[i]
+			}
+		}
+	}
+
+	class, count := uint64(0), 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(descriptorInputCharset, ch)
+		if pos < 0 {
+			return "", errors.Errorf("invalid character %q in descriptor", ch)
+		}
+		polymod(uint64(pos) & 31)
+		class = class*3 + uint64(pos)>>5
+		if count++; count == 3 {
+			polymod(class)
+			class, count = 0, 0
+		}
+	}
+	if count > 0 {
+		polymod(class)
+	}
+	for i := 0; i < 8; i++ {
+		polymod(0)
+	}
+	c ^= 1
+
+	checksum := make([]byte, 8)
+	for i := range checksum {
+		checksum[i] = descriptorChecksumCharset[(c>>(5*(7-i)))&31]
+	}
+	return string(checksum), nil
+}
+
+func formatPath(path []uint32) string {
+	var sb strings.Builder
+	for _, idx := range path {
+		if idx >= bip32.FirstHardenedChild {
+			fmt.Fprintf(&sb, "/%d'", idx-bip32.FirstHardenedChild)
+		} else {
+			fmt.Fprintf(&sb, "/%d", idx)
+		}
+	}
+	return sb.String()
+}
+
+// ParsePath parses a BIP32 path such as m/84'/0'/0'/0/1, where hardened steps are marked with ' or h. The leading m/
+// is optional.
+func ParsePath(path string) ([]uint32, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "m"), "/")
+	if path == "" {
+		return nil, nil
+	}
+	return parsePath(strings.Split(path, "/"))
+}
+
+func parsePath(steps []string) ([]uint32, error) {
+	path := make([]uint32, len(steps))
+	for i, step := range steps {
+		hardened := strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h")
+		if hardened {
+			step = step[:len(step)-1]
+		}
+		idx, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, errors.Errorf("invalid path step %q", steps[i])
+		}
+		path[i] = uint32(idx)
+		if hardened {
+			path[i] += bip32.FirstHardenedChild
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,75 @@
+package wallet_test
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/utils"
+	"bip32_threshold_wallet/wallet"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	checksum, err := wallet.DescriptorChecksum("raw(deadbeef)")
+	require.NoError(t, err)
+	assert.Equal(t, "89f8spxm", checksum)
+}
+
+func TestParseDescriptor(t *testing.T) {
+	// Account 0 of the BIP84 test vectors, whose master key has the fingerprint 73c5da0a.
+	xpub := "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+	desc := "wpkh([73c5da0a/84'/0'/0']" + xpub + "/0/*)"
+	checksum, err := wallet.DescriptorChecksum(desc)
+	require.NoError(t, err)
+
+	d, err := wallet.ParseDescriptor(desc + "#" + checksum)
+	require.NoError(t, err)
+	assert.Equal(t, address.P2WPKH, d.Type)
+	assert.Equal(t, desc+"#"+checksum, d.String(&chaincfg.MainNetParams))
+
+	addr, err := d.Address(0, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", addr)
+
+	_, err = wallet.ParseDescriptor(desc + "#" + strings.Repeat("q", 8))
+	assert.Error(t, err, "invalid checksums must be rejected")
+	_, err = wallet.ParseDescriptor(strings.Replace(desc, "/0/*", "/0'/*", 1))
+	assert.Error(t, err, "hardened steps after the xpub cannot be derived")
+}
+
+func TestAccountDescriptor(t *testing.T) {
+	curve := curves.K256()
+	devices := utils.CreateDevices(2, 3)
+	deriv := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(2, 3, curve, sha256.New(), true), true)
+	w, err := wallet.NewWallet(&deriv, 1, "")
+	require.NoError(t, err)
+
+	for _, purpose := range []wallet.Purpose{wallet.BIP84, wallet.BIP86} {
+		account, err := w.CreateAccount(purpose, 0)
+		require.NoError(t, err)
+
+		desc, err := account.Descriptor(wallet.InternalChain, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		assert.Contains(t, desc, "tpub")
+
+		imported, err := wallet.AccountFromDescriptor(desc)
+		require.NoError(t, err)
+		assert.Equal(t, account.Path(), imported.Path())
+		assert.Equal(t, account.XPub, imported.XPub)
+		assert.Equal(t, account.MasterFingerprint, imported.MasterFingerprint)
+
+		expected, err := account.Address(wallet.InternalChain, 3, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		addr, err := imported.Address(wallet.InternalChain, 3, &chaincfg.TestNet3Params)
+		require.NoError(t, err)
+		assert.Equal(t, expected, addr)
+	}
+}
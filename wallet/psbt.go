@@ -0,0 +1,234 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/psbt"
+	"github.com/coinbase/kryptology/pkg/paillier"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/signing"
+)
+
+// PSBTSigner acts as BIP174 signer for the keys in the derivation tree of a committee of devices. The BIP32 derivation
+// fields of the inputs determine the keys to sign with:
+//   - Paths consisting of non-hardened steps only are signed by the devices with threshold ECDSA, tweaking their shares
+//     at signing time, see signing.SignECDSAForPath.
+//   - Paths starting with the hardened purpose'/coin'/account' levels of an account are signed by the devices with
+//     threshold ECDSA using their shares of the account derived by the wallet, if one is given.
+//
+// P2PKH, P2SH-P2WPKH and P2WPKH inputs are supported.
+type PSBTSigner struct {
+	devices     []node.Device // The cosigners, sharing the node the derivation paths start at.
+	decryptKeys map[uint32]*paillier.SecretKey
+	params      *signing.ECDSAParams
+	wallet      *Wallet
+	fingerprint uint32 // Fingerprint of the devices' node as encoded in BIP32 derivation fields.
+}
+
+// NewPSBTSigner creates a signer for the node shared among the given cosigners. The wallet is optional.
+func NewPSBTSigner(devices []node.Device, decryptKeys map[uint32]*paillier.SecretKey, params *signing.ECDSAParams, w *Wallet) (*PSBTSigner, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices given")
+	}
+	pk := devices[0].PublicKey()
+	fingerprint := btcutil.Hash160((*pk).ToAffineCompressed())[:4]
+
+	return &PSBTSigner{
+		devices:     devices,
+		decryptKeys: decryptKeys,
+		params:      params,
+		wallet:      w,
+		fingerprint: binary.LittleEndian.Uint32(fingerprint),
+	}, nil
+}
+
+// Sign adds a partial signature for every BIP32 derivation of the inputs that belongs to the devices' derivation tree
+// and returns the number of signatures added. Finalized inputs and derivations of other keys are skipped.
+func (s *PSBTSigner) Sign(p *psbt.Packet) (int, error) {
+	u, err := psbt.NewUpdater(p)
+	if err != nil {
+		return 0, err
+	}
+
+	signed := 0
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+
+		for _, derivation := range in.Bip32Derivation {
+			if derivation.MasterKeyFingerprint != s.fingerprint {
+				continue
+			}
+
+			log.Tracef("signing input %d for path %v", i, derivation.Bip32Path)
+			digest, err := sigHash(p, i, derivation.PubKey)
+			if err != nil {
+				return signed, errors.Wrapf(err, "input %d", i)
+			}
+			r, sigS, err := s.signDigest(derivation, digest)
+			if err != nil {
+				return signed, errors.Wrapf(err, "input %d", i)
+			}
+
+			sig := (&btcec.Signature{R: r, S: sigS}).Serialize()
+			sig = append(sig, byte(sigHashType(in)))
+			if _, err := u.Sign(i, sig, derivation.PubKey, in.RedeemScript, nil); err != nil {
+				return signed, errors.Wrapf(err, "adding signature to input %d", i)
+			}
+			signed++
+		}
+	}
+
+	return signed, nil
+}
+
+// SignAndFinalize signs the PSBT, finalizes all inputs and extracts the signed transaction.
+func (s *PSBTSigner) SignAndFinalize(p *psbt.Packet) (*wire.MsgTx, error) {
+	if _, err := s.Sign(p); err != nil {
+		return nil, err
+	}
+	if err := psbt.MaybeFinalizeAll(p); err != nil {
+		return nil, errors.Wrap(err, "finalizing inputs")
+	}
+	return psbt.Extract(p)
+}
+
+// signDigest signs the digest with the key at the derivation's path and checks that it is the derivation's key.
+func (s *PSBTSigner) signDigest(derivation *psbt.Bip32Derivation, digest []byte) (*big.Int, *big.Int, error) {
+	path := derivation.Bip32Path
+	if len(path) > 0 && path[0] >= bip32.FirstHardenedChild {
+		return s.signWithAccountKey(derivation, digest)
+	}
+
+	_, pk, _, err := s.devices[0].PathTweak(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal((*pk).ToAffineCompressed(), derivation.PubKey) {
+		return nil, nil, errors.New("public key does not match the derivation path")
+	}
+
+	sig, err := signing.SignECDSAForPath(s.devices, s.decryptKeys, s.params, path, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sig.R, sig.S, nil
+}
+
+// signWithAccountKey signs for a path of the form purpose'/coin'/account'/chain/index with the cosigners' shares of the
+// account.
+func (s *PSBTSigner) signWithAccountKey(derivation *psbt.Bip32Derivation, digest []byte) (*big.Int, *big.Int, error) {
+	if s.wallet == nil {
+		return nil, nil, errors.New("hardened paths require a wallet")
+	}
+	accountDevices, path, err := s.wallet.DevicesForPath(derivation.Bip32Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The wallet derives the shares of all devices, of which only those of the cosigners are used.
+	cosigners := make([]node.Device, 0, len(s.devices))
+	for i := range s.devices {
+		for j := range accountDevices {
+			if accountDevices[j].Identifier() == s.devices[i].Identifier() {
+				cosigners = append(cosigners, accountDevices[j])
+			}
+		}
+	}
+	if len(cosigners) != len(s.devices) {
+		return nil, nil, errors.New("wallet does not derive the shares of all cosigners")
+	}
+
+	_, pk, _, err := cosigners[0].PathTweak(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal((*pk).ToAffineCompressed(), derivation.PubKey) {
+		return nil, nil, errors.New("public key does not match the derivation path")
+	}
+
+	sig, err := signing.SignECDSAForPath(cosigners, s.decryptKeys, s.params, path, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sig.R, sig.S, nil
+}
+
+// sigHash computes the digest to sign for the given public key at the input.
+func sigHash(p *psbt.Packet, idx int, pubKey []byte) ([]byte, error) {
+	in := &p.Inputs[idx]
+	tx := p.UnsignedTx
+	prevOutPoint := tx.TxIn[idx].PreviousOutPoint
+
+	var prevOut *wire.TxOut
+	switch {
+	case in.WitnessUtxo != nil:
+		prevOut = in.WitnessUtxo
+	case in.NonWitnessUtxo != nil:
+		if in.NonWitnessUtxo.TxHash() != prevOutPoint.Hash || int(prevOutPoint.Index) >= len(in.NonWitnessUtxo.TxOut) {
+			return nil, errors.New("previous transaction does not match the input")
+		}
+		prevOut = in.NonWitnessUtxo.TxOut[prevOutPoint.Index]
+	default:
+		return nil, errors.New("input has no previous output")
+	}
+
+	script := prevOut.PkScript
+	if in.RedeemScript != nil {
+		if !txscript.IsPayToScriptHash(script) {
+			return nil, errors.New("redeem script given for non-P2SH output")
+		}
+		p2sh, err := txscript.NewScriptBuilder().
+			AddOp(txscript.OP_HASH160).
+			AddData(btcutil.Hash160(in.RedeemScript)).
+			AddOp(txscript.OP_EQUAL).
+			Script()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(p2sh, script) {
+			return nil, errors.New("redeem script does not match the output")
+		}
+		script = in.RedeemScript
+	}
+
+	pkHash := btcutil.Hash160(pubKey)
+	switch txscript.GetScriptClass(script) {
+	case txscript.WitnessV0PubKeyHashTy:
+		if !bytes.Equal(script[2:], pkHash) {
+			return nil, errors.New("output does not pay to the public key")
+		}
+		return txscript.CalcWitnessSigHash(script, txscript.NewTxSigHashes(tx), sigHashType(in), tx, idx, prevOut.Value)
+
+	case txscript.PubKeyHashTy:
+		if in.NonWitnessUtxo == nil {
+			return nil, errors.New("legacy inputs require the previous transaction")
+		}
+		if !bytes.Equal(script[3:23], pkHash) {
+			return nil, errors.New("output does not pay to the public key")
+		}
+		return txscript.CalcSignatureHash(script, sigHashType(in), tx, idx)
+	}
+
+	return nil, errors.Errorf("unsupported output script type %s", txscript.GetScriptClass(script))
+}
+
+// sigHashType returns the signature hash type requested by the input, SIGHASH_ALL by default.
+func sigHashType(in *psbt.PInput) txscript.SigHashType {
+	if in.SighashType == 0 {
+		return txscript.SigHashAll
+	}
+	return in.SighashType
+}
@@ -0,0 +1,143 @@
+package wallet_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/psbt"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/internal/testutil"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/tvrf"
+	"bip32_threshold_wallet/utils"
+	"bip32_threshold_wallet/wallet"
+)
+
+func TestPSBTSigner(t *testing.T) {
+	curve := curves.K256()
+	devices := utils.CreateDevices(2, 3)
+	params, decryptKeys := testutil.CreateECDSAParams(3)
+	deriv := derivation.NewTVRFDerivation(curve, devices, tvrf.NewDDHTVRF(2, 3, curve, sha256.New(), true), true)
+	w, err := wallet.NewWallet(&deriv, 1, "")
+	require.NoError(t, err)
+	account, err := w.CreateAccount(wallet.BIP84, 0)
+	require.NoError(t, err)
+
+	fingerprint := binary.LittleEndian.Uint32(btcutil.Hash160((*devices[0].PublicKey()).ToAffineCompressed())[:4])
+	pubKey := func(path []uint32) []byte {
+		var pk node.PublicKey
+		if len(path) > 3 {
+			pk, err = account.PublicKey(path[3], path[4])
+		} else {
+			_, pk, _, err = devices[0].PathTweak(path)
+		}
+		require.NoError(t, err)
+		return (*pk).ToAffineCompressed()
+	}
+
+	// The outputs of the funding transaction spent by the inputs of the PSBT.
+	inputs := []struct {
+		path   []uint32
+		script func(pkHash []byte) (pkScript, redeemScript []byte)
+	}{
+		{[]uint32{0, 0}, p2wpkh},
+		{[]uint32{0, 1}, func(pkHash []byte) ([]byte, []byte) {
+			redeemScript, _ := p2wpkh(pkHash)
+			addr, err := btcutil.NewAddressScriptHash(redeemScript, &chaincfg.TestNet3Params)
+			require.NoError(t, err)
+			pkScript, err := txscript.PayToAddrScript(addr)
+			require.NoError(t, err)
+			return pkScript, redeemScript
+		}},
+		{[]uint32{1, 0}, func(pkHash []byte) ([]byte, []byte) {
+			addr, err := btcutil.NewAddressPubKeyHash(pkHash, &chaincfg.TestNet3Params)
+			require.NoError(t, err)
+			pkScript, err := txscript.PayToAddrScript(addr)
+			require.NoError(t, err)
+			return pkScript, nil
+		}},
+		{[]uint32{0x80000054, 0x80000001, 0x80000000, 0, 0}, p2wpkh},
+	}
+
+	funding := wire.NewMsgTx(2)
+	funding.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	redeemScripts := make([][]byte, len(inputs))
+	for i, in := range inputs {
+		var pkScript []byte
+		pkScript, redeemScripts[i] = in.script(btcutil.Hash160(pubKey(in.path)))
+		funding.AddTxOut(wire.NewTxOut(int64(10000*(i+1)), pkScript))
+	}
+
+	tx := wire.NewMsgTx(2)
+	for i := range inputs {
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, uint32(i)), nil, nil))
+		tx.TxIn[i].PreviousOutPoint.Hash = funding.TxHash()
+	}
+	tx.AddTxOut(wire.NewTxOut(90000, funding.TxOut[0].PkScript))
+
+	p, err := psbt.NewFromUnsignedTx(tx)
+	require.NoError(t, err)
+	for i, in := range inputs {
+		if i == 2 {
+			p.Inputs[i].NonWitnessUtxo = funding
+		} else {
+			p.Inputs[i].WitnessUtxo = funding.TxOut[i]
+		}
+		p.Inputs[i].RedeemScript = redeemScripts[i]
+		p.Inputs[i].Bip32Derivation = []*psbt.Bip32Derivation{{
+			PubKey:               pubKey(in.path),
+			MasterKeyFingerprint: fingerprint,
+			Bip32Path:            in.path,
+		}}
+	}
+
+	signer, err := wallet.NewPSBTSigner(devices[1:], decryptKeys, params, w)
+	require.NoError(t, err)
+	signed, err := signer.SignAndFinalize(p)
+	require.NoError(t, err)
+
+	// Every input must be valid according to the script engine.
+	for i, prevOut := range funding.TxOut {
+		engine, err := txscript.NewEngine(prevOut.PkScript, signed, i, txscript.StandardVerifyFlags, nil,
+			txscript.NewTxSigHashes(signed), prevOut.Value)
+		require.NoError(t, err)
+		assert.NoError(t, engine.Execute(), "input %d", i)
+	}
+
+	t.Run("Foreign keys", func(t *testing.T) {
+		p, err := psbt.NewFromUnsignedTx(tx)
+		require.NoError(t, err)
+		p.Inputs[0].WitnessUtxo = funding.TxOut[0]
+		p.Inputs[0].Bip32Derivation = []*psbt.Bip32Derivation{{
+			PubKey:               pubKey(inputs[0].path),
+			MasterKeyFingerprint: fingerprint + 1,
+			Bip32Path:            inputs[0].path,
+		}}
+
+		n, err := signer.Sign(p)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+}
+
+func p2wpkh(pkHash []byte) ([]byte, []byte) {
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(pkHash, &chaincfg.TestNet3Params)
+	if err != nil {
+		panic(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		panic(err)
+	}
+	return pkScript, nil
+}
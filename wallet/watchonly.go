@@ -0,0 +1,58 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/coinbase/kryptology/pkg/core/curves"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip32"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/node"
+)
+
+// WatchOnly derives the public keys and addresses of the non-hardened descendants of a threshold node from its
+// extended public key only, i.e. without contacting any device. The derivation is the one the devices use in
+// DeriveNonHardenedChild, hence both yield the same keys.
+type WatchOnly struct {
+	xpub      *bip32.Key
+	publicKey node.PublicKey
+}
+
+// NewWatchOnly creates a watch-only wallet from the Base58Check encoded extended public key of a node, e.g. as exported
+// with Device.XPub. Extended private keys are rejected.
+func NewWatchOnly(xpub string) (*WatchOnly, error) {
+	key, err := bip32.B58Deserialize(xpub)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding extended public key")
+	}
+	if key.IsPrivate {
+		return nil, errors.New("watch-only wallets must not be given an extended private key")
+	}
+
+	pk, err := curves.K256().Point.FromAffineCompressed(key.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key")
+	}
+	return &WatchOnly{xpub: key, publicKey: &pk}, nil
+}
+
+// XPub returns the extended public key of the watched node.
+func (w *WatchOnly) XPub() *bip32.Key {
+	return w.xpub
+}
+
+// PublicKey returns the public key of the descendant at the non-hardened relative path below the watched node.
+func (w *WatchOnly) PublicKey(path ...uint32) (node.PublicKey, error) {
+	_, pk, _, err := node.NonHardenedPathTweak(w.publicKey, w.xpub.ChainCode, path)
+	return pk, err
+}
+
+// Address returns the address of the given type of the descendant at the non-hardened relative path below the watched
+// node.
+func (w *WatchOnly) Address(t address.Type, params *chaincfg.Params, path ...uint32) (string, error) {
+	pk, err := w.PublicKey(path...)
+	if err != nil {
+		return "", err
+	}
+	return address.Encode(pk, t, params)
+}
@@ -0,0 +1,56 @@
+package wallet_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bip32_threshold_wallet/address"
+	"bip32_threshold_wallet/derivation"
+	"bip32_threshold_wallet/node"
+	"bip32_threshold_wallet/utils"
+	"bip32_threshold_wallet/wallet"
+)
+
+func TestWatchOnly(t *testing.T) {
+	devices := utils.CreateDevices(2, 3)
+	xpub, err := devices[0].XPub()
+	require.NoError(t, err)
+	w, err := wallet.NewWatchOnly(xpub.String())
+	require.NoError(t, err)
+
+	receive, err := derivation.NewNonHardDerivation(devices).DeriveNonHardenedChild(0)
+	require.NoError(t, err)
+	for i := uint32(0); i < 3; i++ {
+		children, err := derivation.NewNonHardDerivation(receive).DeriveNonHardenedChild(i)
+		require.NoError(t, err)
+
+		pk, err := w.PublicKey(0, i)
+		require.NoError(t, err)
+		assert.True(t, (*pk).Equal(*children[0].PublicKey()))
+	}
+
+	t.Run("Extended private key", func(t *testing.T) {
+		xprv, err := node.ExportXPrv(devices)
+		require.NoError(t, err)
+		_, err = wallet.NewWatchOnly(xprv.String())
+		assert.Error(t, err)
+	})
+}
+
+// Account 0 and first receiving address of the BIP84 test vectors.
+func TestWatchOnlyAddress(t *testing.T) {
+	w, err := wallet.NewWatchOnly("zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs")
+	require.NoError(t, err)
+
+	pk, err := w.PublicKey(0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "0330d54fd0dd420a6e5f8d3624f5f3482cae350f79d5f0753bf5beef9c2d91af3c", hex.EncodeToString((*pk).ToAffineCompressed()))
+
+	addr, err := w.Address(address.P2WPKH, &chaincfg.MainNetParams, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", addr)
+}